@@ -25,27 +25,65 @@ package cmd
 
 // Command line parameters
 const (
-	ParamDryRun               = "dry-run"
-	ParamInstallDryRunBindKey = "install-dry-run"
-	ParamUpgradeDryRunBindKey = "upgrade-dry-run"
-	ParamFilename             = "filename"
-	ParamServer               = "server"
-	ParamToken                = "token"
-	ParamSSHKeyInstallBindKey = "install-ssh-key"
-	ParamUser                 = "user"
-	ParamSSHKey               = "ssh-key"
-	ParamSSHPort              = "ssh-port"
-	ParamCIDR                 = "cidr"
-	ParamHostnameSubstring    = "substr"
-	ParamAuth                 = "auth"
-	ParamHostnamePattern      = "hostname-pattern"
-	ParamHostnamePrefix       = "hostname-prefix"
-	ParamConfirmInstall       = "yes"
-	ParamServerConfigTmpl     = "server-cfg-tmpl"
-	ParamAgentConfigTmpl      = "agent-cfg-tmpl"
-	ParamVersion              = "version"
-	ParamK3sVersionBindKey    = "k3s-version"
-	ParamK3OSVersionBindKey   = "k3OS-version"
-	ParamUpgradeFilename      = "update-filename"
-	ParamComponent            = "component"
+	ParamDryRun                         = "dry-run"
+	ParamInstallDryRunBindKey           = "install-dry-run"
+	ParamUpgradeDryRunBindKey           = "upgrade-dry-run"
+	ParamFilename                       = "filename"
+	ParamServer                         = "server"
+	ParamToken                          = "token"
+	ParamSSHKeyInstallBindKey           = "install-ssh-key"
+	ParamUser                           = "user"
+	ParamSSHKey                         = "ssh-key"
+	ParamSSHPort                        = "ssh-port"
+	ParamCIDR                           = "cidr"
+	ParamHostnameSubstring              = "substr"
+	ParamAuth                           = "auth"
+	ParamHostnamePattern                = "hostname-pattern"
+	ParamHostnamePrefix                 = "hostname-prefix"
+	ParamConfirmInstall                 = "yes"
+	ParamServerConfigTmpl               = "server-cfg-tmpl"
+	ParamAgentConfigTmpl                = "agent-cfg-tmpl"
+	ParamVersion                        = "version"
+	ParamK3sVersionBindKey              = "k3s-version"
+	ParamK3OSVersionBindKey             = "k3OS-version"
+	ParamUpgradeFilename                = "update-filename"
+	ParamComponent                      = "component"
+	ParamConcurrency                    = "concurrency"
+	ParamHostTimeout                    = "host-timeout"
+	ParamSelector                       = "selector"
+	ParamScanSelectorBindKey            = "scan-selector"
+	ParamInstallSelectorBindKey         = "install-selector"
+	ParamOutput                         = "output"
+	ParamScanOutputBindKey              = "scan-output"
+	ParamMerge                          = "merge"
+	ParamScanConfirmBindKey             = "scan-yes"
+	ParamForce                          = "force"
+	ParamInstallForceBindKey            = "install-force"
+	ParamArch                           = "arch"
+	ParamScanArchBindKey                = "scan-arch"
+	ParamInstallArchBindKey             = "install-arch"
+	ParamJoin                           = "join"
+	ParamInterval                       = "interval"
+	ParamMaxPerInterval                 = "max-per-interval"
+	ParamAuthorizedKey                  = "authorized-key"
+	ParamStrictHostKeyChecking          = "strict-host-key-checking"
+	ParamKnownHosts                     = "known-hosts"
+	ParamHostKeyChanged                 = "host-key-changed"
+	ParamProxyJump                      = "proxy-jump"
+	ParamSSHCert                        = "ssh-cert"
+	ParamLoginKey                       = "login-key"
+	ParamInstallLoginKeyBindKey         = "install-login-key"
+	ParamInstallSSHCertBindKey          = "install-ssh-cert"
+	ParamTrustedUserCAKey               = "trusted-user-ca-key"
+	ParamInstallTrustedUserCAKeyBindKey = "install-trusted-user-ca-key"
+	ParamQuiet                          = "quiet"
+	ParamInstallQuietBindKey            = "install-quiet"
+	ParamTimeout                        = "timeout"
+	ParamConnectTimeout                 = "connect-timeout"
+	ParamOperationTimeout               = "operation-timeout"
+	ParamAskSudoPassword                = "ask-sudo-password"
+	ParamBandwidthLimit                 = "bwlimit"
+	ParamNodeBandwidthLimit             = "node-bwlimit"
+	ParamRecord                         = "record"
+	ParamSudo                           = "sudo"
 )
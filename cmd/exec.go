@@ -0,0 +1,124 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package cmd include Cobra commands
+package cmd
+
+import (
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	pkgcmd "github.com/TheNatureOfSoftware/k3pi/pkg/cmd"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] -- <command>",
+	Short: "Runs a command on nodes",
+	Long: `Runs a shell command on all nodes in parallel and prints the output of every node. Exits
+with a non-zero status if the command failed on any node. The command is a single line, multiple
+commands are separated with ';' or '&&'.
+
+	Examples:
+
+	Show the uptime of all nodes
+	$ k3pi exec --filename nodes.yaml -- uptime
+
+	Show disk usage of the Raspberry Pi 4 nodes, grouped per node
+	$ k3pi exec --filename nodes.yaml --selector 'model~="Pi 4"' --output grouped -- df -h
+
+	Run a command with sudo and get the results as JSON
+	$ k3pi exec --filename nodes.yaml --sudo --output json -- k3s --version
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		nodes := loadNodes(viper.GetString(execKey(ParamFilename)))
+
+		selector, err := model.ParseSelector(viper.GetString(execKey(ParamSelector)))
+		misc.ExitOnError(err, "invalid selector")
+
+		writer, err := pkgcmd.NewExecWriter(viper.GetString(execKey(ParamOutput)), os.Stdout)
+		misc.ExitOnError(err)
+
+		ctx, cancel := commandContext()
+		defer cancel()
+
+		clientFactory := client.NewPooledClientFactory()
+		defer clientFactory.Close()
+
+		execArgs := &pkgcmd.ExecArgs{
+			Nodes:       nodes,
+			Selector:    selector,
+			Command:     strings.Join(args, " "),
+			Sudo:        viper.GetBool(execKey(ParamSudo)),
+			Concurrency: viper.GetInt(execKey(ParamConcurrency)),
+		}
+
+		var failed, total int
+		var writeErr error
+		err = pkgcmd.ExecFunc(ctx, clientFactory, execArgs, func(result *pkgcmd.ExecResult) {
+			total++
+			if !result.Success() {
+				failed++
+			}
+			if err := writer.Write(result); err != nil && writeErr == nil {
+				writeErr = err
+			}
+		})
+		if flushErr := writer.Flush(); writeErr == nil {
+			writeErr = flushErr
+		}
+		misc.ExitOnError(err)
+		misc.ExitOnError(writeErr)
+
+		if failed > 0 {
+			// stderr keeps json output valid
+			_, _ = fmt.Fprintf(os.Stderr, "Error: command failed on %d of %d nodes\n", failed, total)
+			os.Exit(1)
+		}
+	},
+}
+
+// execKey is the viper key for an exec flag, keeps exec flags apart from the flags of other commands
+func execKey(param string) string {
+	return "exec-" + param
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	execCmd.Flags().StringP(ParamFilename, "f", "", "nodes file, nodes are read from stdin if piped in")
+	execCmd.Flags().String(ParamSelector, "", "only run on nodes matching the selector, e.g. 'arch=arm64,model~=\"Pi 4\"'")
+	execCmd.Flags().Bool(ParamSudo, false, "run the command with sudo")
+	execCmd.Flags().Int(ParamConcurrency, pkgcmd.DefaultExecConcurrency, "number of nodes to run the command on in parallel")
+	execCmd.Flags().StringP(ParamOutput, "o", pkgcmd.ExecOutputPrefixed, fmt.Sprintf("output format, one of %v", pkgcmd.ExecOutputFormats))
+
+	execCmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		_ = viper.BindPFlag(execKey(flag.Name), flag)
+	})
+}
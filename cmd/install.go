@@ -25,14 +25,15 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
 	pkgcmd "github.com/TheNatureOfSoftware/k3pi/pkg/cmd"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/install"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
-	"github.com/kubernetes-sigs/yaml"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -42,9 +43,10 @@ import (
 var installCmd = &cobra.Command{
 	Use:   "install",
 	Short: "Installs k3os on selected nodes",
-	Long: `Installs k3os on ARM devices, should be combined with the scan command.
+	Long: `Installs k3os on ARM and x86_64 devices, should be combined with the scan command.
 
-	IMPORTANT! This will overwrite your existing installation.
+	IMPORTANT! This will overwrite your existing installation. Nodes where the scan found k3OS or k3s
+	are refused unless --force is given.
 	
 	Examples:
 	
@@ -59,36 +61,26 @@ var installCmd = &cobra.Command{
 
 	$ Installs k3os on all nodes as agents joining an existing server (server is not in nodes file)
 	k3pi install --filename ./nodes.yaml -t <token|secret> --server <server ip>
+
+	Installs k3os only on nodes matching a selector over node facts and labels
+	$ k3pi install --filename ./nodes.yaml --server <server ip> --selector 'zone=shelf-1,mem>=4Gi'
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		fn := viper.GetString(ParamFilename)
-
-		var bytes []byte
-		var err error
-
-		if misc.DataPipedIn() {
-			bytes, err = ioutil.ReadAll(os.Stdin)
-		} else {
-			if fn == "" {
-				misc.ErrorExitWithMessage("must specify --filename|-f")
-			}
-			bytes, err = ioutil.ReadFile(fn)
-		}
-		misc.PanicOnError(err, "error reading input file")
-
-		var nodes []*model.Node
-		err = yaml.Unmarshal(bytes, &nodes)
-		misc.ExitOnError(err, "error parsing nodes from file")
+		nodes := loadNodes(viper.GetString(ParamFilename))
 
 		if len(nodes) == 0 {
 			misc.ErrorExitWithMessage("no nodes found in file")
 		}
 
+		selector, err := model.ParseSelector(viper.GetString(ParamInstallSelectorBindKey))
+		misc.ExitOnError(err, "invalid selector")
+
 		k3OSVersion := viper.GetString(ParamK3OSVersionBindKey)
 		if len(k3OSVersion) == 0 {
 			misc.ErrorExitWithMessage("k3OS version ( -v|--version ) is empty")
 		}
 		sshKeys := viper.GetStringSlice(ParamSSHKeyInstallBindKey)
+		caKeys := trustedUserCAKeys(viper.GetStringSlice(ParamInstallTrustedUserCAKeyBindKey))
 		server := viper.GetString(ParamServer)
 		token := viper.GetString(ParamToken)
 		dryRun := viper.GetBool(ParamInstallDryRunBindKey)
@@ -100,44 +92,115 @@ var installCmd = &cobra.Command{
 		serverConfigTmpl := loadTemplateFile(viper.GetString(ParamServerConfigTmpl))
 		agentConfigTmpl := loadTemplateFile(viper.GetString(ParamAgentConfigTmpl))
 
-		if len(sshKeys) == 0 {
+		installArgs := &pkgcmd.InstallArgs{
+			Nodes:             nodes,
+			SSHKeys:           authorizedKeys(sshKeys, caKeys),
+			TrustedUserCAKeys: caKeys,
+			Token:             token,
+			ServerID:          server,
+			HostnameSpec:      hostnameSpec,
+			DryRun:            dryRun,
+			Confirmed:         viper.GetBool(ParamConfirmInstall),
+			Force:             viper.GetBool(ParamInstallForceBindKey),
+			Quiet:             viper.GetBool(ParamInstallQuietBindKey),
+			Templates: &install.ConfigTemplates{
+				ServerTmpl: serverConfigTmpl,
+				AgentTmpl:  agentConfigTmpl,
+			},
+			K3OSVersion:   k3OSVersion,
+			Selector:      selector,
+			Architectures: viper.GetStringSlice(ParamInstallArchBindKey),
+			NodeAuth:      keyAuth("rancher", viper.GetStringSlice(ParamInstallLoginKeyBindKey), viper.GetString(ParamInstallSSHCertBindKey)),
+		}
+		if fn := viper.GetString(ParamFilename); !misc.DataPipedIn() {
+			// keeps the new hostnames and cleared host keys of installed nodes
+			installArgs.Save = func(nodes model.Nodes) error {
+				return writeInventory(fn, nodes)
+			}
+		}
+		ctx, cancel := commandContext()
+		defer cancel()
 
-			misc.ErrorExitWithMessage("at least one ssh key is required")
+		err = pkgcmd.Install(ctx, installArgs)
+		misc.ExitOnError(err)
+	},
+}
 
-		} else if len(sshKeys) == 1 && sshKeys[0] == pkgcmd.K3OSDefaultSSHAuthorizedKey {
+// authorizedKeys returns the ssh keys to authorize, reads the default public key if no other key is given.
+// The default public key is optional when nodes trust a user CA.
+func authorizedKeys(sshKeys []string, caKeys []string) []string {
+	if len(sshKeys) == 0 {
 
-			idRsaPubFile, err := homedir.Expand(pkgcmd.K3OSDefaultSSHAuthorizedKey)
-			msg := fmt.Sprintf("failed to read default ssh public key: %s", pkgcmd.K3OSDefaultSSHAuthorizedKey)
-			misc.ExitOnError(err, msg)
+		if len(caKeys) == 0 {
+			misc.ErrorExitWithMessage("at least one ssh key or trusted user CA key is required")
+		}
 
-			f, err := os.Open(idRsaPubFile)
-			misc.ExitOnError(err, msg)
-			defer f.Close()
+	} else if len(sshKeys) == 1 && sshKeys[0] == pkgcmd.K3OSDefaultSSHAuthorizedKey {
 
-			b, err := ioutil.ReadAll(f)
-			misc.ExitOnError(err, msg)
+		idRsaPubFile, err := homedir.Expand(pkgcmd.K3OSDefaultSSHAuthorizedKey)
+		msg := fmt.Sprintf("failed to read default ssh public key: %s", pkgcmd.K3OSDefaultSSHAuthorizedKey)
+		misc.ExitOnError(err, msg)
 
-			key := strings.Split(strings.TrimSpace(string(b)), " ")
-			sshKeys = []string{fmt.Sprintf("%s %s", key[0], key[1])}
+		if _, err = os.Stat(idRsaPubFile); os.IsNotExist(err) && len(caKeys) > 0 {
+			return nil
 		}
 
-		installArgs := &pkgcmd.InstallArgs{
-			Nodes:        nodes,
-			SSHKeys:      sshKeys,
-			Token:        token,
-			ServerID:     server,
-			HostnameSpec: hostnameSpec,
-			DryRun:       dryRun,
-			Confirmed:    viper.GetBool(ParamConfirmInstall),
-			Templates: &install.ConfigTemplates{
-				ServerTmpl: serverConfigTmpl,
-				AgentTmpl:  agentConfigTmpl,
-			},
-			K3OSVersion: k3OSVersion,
-		}
-		err = pkgcmd.Install(installArgs)
+		f, err := os.Open(idRsaPubFile)
+		misc.ExitOnError(err, msg)
+		defer f.Close()
+
+		b, err := ioutil.ReadAll(f)
+		misc.ExitOnError(err, msg)
+
+		key := strings.Split(strings.TrimSpace(string(b)), " ")
+		return []string{fmt.Sprintf("%s %s", key[0], key[1])}
+	}
+	return sshKeys
+}
+
+// trustedUserCAKeys reads CA public keys from files, nodes accept user certificates signed by these CAs
+func trustedUserCAKeys(caKeyFiles []string) []string {
+	var caKeys []string
+	for _, caKeyFile := range caKeyFiles {
+		fn, err := homedir.Expand(caKeyFile)
 		misc.ExitOnError(err)
-	},
+		b, err := ioutil.ReadFile(fn)
+		misc.ExitOnError(err, fmt.Sprintf("failed to read trusted user CA key: %s", caKeyFile))
+
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if _, _, _, _, err = ssh.ParseAuthorizedKey([]byte(line)); err != nil {
+				misc.ExitOnError(err, fmt.Sprintf("invalid trusted user CA key in %s", caKeyFile))
+			}
+			caKeys = append(caKeys, line)
+		}
+	}
+	return caKeys
+}
+
+// loadNodes loads nodes from stdin if data is piped in, otherwise from file
+func loadNodes(fn string) model.Nodes {
+	var nodes model.Nodes
+	var err error
+
+	if misc.DataPipedIn() {
+		nodes, err = pkgcmd.ReadNodes(os.Stdin)
+	} else {
+		if fn == "" {
+			misc.ErrorExitWithMessage("must specify --filename|-f")
+		}
+		var f *os.File
+		f, err = os.Open(fn)
+		misc.PanicOnError(err, "error reading input file")
+		defer f.Close()
+		nodes, err = pkgcmd.ReadNodes(f)
+	}
+	misc.ExitOnError(err, "error parsing nodes from file")
+
+	return nodes
 }
 
 func loadTemplateFile(configTmplFn string) string {
@@ -155,6 +218,8 @@ func init() {
 
 	installCmd.Flags().BoolP(ParamConfirmInstall, "y", false, "confirm the installation")
 	installCmd.Flags().Bool(ParamDryRun, false, "if true will run the install but not execute commands")
+	installCmd.Flags().BoolP(ParamQuiet, "q", false, "only show the last lines of command output for nodes that fail")
+	installCmd.Flags().Bool(ParamForce, false, "overwrite nodes where k3OS or k3s is already installed")
 	installCmd.Flags().String(ParamHostnamePattern, "%s%d", "hostname pattern, printf with %s and %d")
 	installCmd.Flags().String(ParamHostnamePrefix, "k3s-node", "hostname prefix, (hostname = '<prefix><index>')")
 	installCmd.Flags().StringP(ParamFilename, "f", "", "scan output file with all nodes")
@@ -163,18 +228,30 @@ func init() {
 	installCmd.Flags().Lookup(ParamFilename).NoOptDefVal = ""
 	installCmd.Flags().String(ParamServerConfigTmpl, "", "server k3OS config.yaml template file")
 	installCmd.Flags().String(ParamAgentConfigTmpl, "", "agent k3OS config.yaml template file")
+	installCmd.Flags().StringSlice(ParamArch, model.DefaultArchitectures, "supported architectures")
+	installCmd.Flags().String(ParamSelector, "", "only install nodes matching the selector, e.g. 'arch=arm64,zone=shelf-1'")
 	installCmd.Flags().String(ParamVersion, model.DefaultK3OSVersion, fmt.Sprintf("k3OS version, default is %s", model.DefaultK3OSVersion))
 
 	installCmd.Flags().StringSliceP(ParamSSHKey, "k", []string{pkgcmd.K3OSDefaultSSHAuthorizedKey}, "ssh authorized key that should be added to the rancher user")
+	installCmd.Flags().StringSlice(ParamTrustedUserCAKey, []string{}, "CA public key file, nodes accept ssh user certificates signed by the CA")
+	installCmd.Flags().StringSlice(ParamLoginKey, client.DefaultSSHKeys, "ssh keys for logging in to installed nodes as rancher, tried in order after keys in the ssh-agent")
+	installCmd.Flags().String(ParamSSHCert, "", "ssh certificate for the first login key, default is <login key>-cert.pub if it exists")
 	_ = viper.BindPFlag(ParamInstallDryRunBindKey, installCmd.Flags().Lookup(ParamDryRun))
 	_ = viper.BindPFlag(ParamConfirmInstall, installCmd.Flags().Lookup(ParamConfirmInstall))
+	_ = viper.BindPFlag(ParamInstallForceBindKey, installCmd.Flags().Lookup(ParamForce))
+	_ = viper.BindPFlag(ParamInstallQuietBindKey, installCmd.Flags().Lookup(ParamQuiet))
 	_ = viper.BindPFlag(ParamFilename, installCmd.Flags().Lookup(ParamFilename))
 	_ = viper.BindPFlag(ParamServer, installCmd.Flags().Lookup(ParamServer))
 	_ = viper.BindPFlag(ParamSSHKeyInstallBindKey, installCmd.Flags().Lookup(ParamSSHKey))
+	_ = viper.BindPFlag(ParamInstallTrustedUserCAKeyBindKey, installCmd.Flags().Lookup(ParamTrustedUserCAKey))
+	_ = viper.BindPFlag(ParamInstallLoginKeyBindKey, installCmd.Flags().Lookup(ParamLoginKey))
+	_ = viper.BindPFlag(ParamInstallSSHCertBindKey, installCmd.Flags().Lookup(ParamSSHCert))
 	_ = viper.BindPFlag(ParamToken, installCmd.Flags().Lookup(ParamToken))
 	_ = viper.BindPFlag(ParamHostnamePattern, installCmd.Flags().Lookup(ParamHostnamePattern))
 	_ = viper.BindPFlag(ParamHostnamePrefix, installCmd.Flags().Lookup(ParamHostnamePrefix))
 	_ = viper.BindPFlag(ParamServerConfigTmpl, installCmd.Flags().Lookup(ParamServerConfigTmpl))
 	_ = viper.BindPFlag(ParamAgentConfigTmpl, installCmd.Flags().Lookup(ParamAgentConfigTmpl))
 	_ = viper.BindPFlag(ParamK3OSVersionBindKey, installCmd.Flags().Lookup(ParamVersion))
+	_ = viper.BindPFlag(ParamInstallArchBindKey, installCmd.Flags().Lookup(ParamArch))
+	_ = viper.BindPFlag(ParamInstallSelectorBindKey, installCmd.Flags().Lookup(ParamSelector))
 }
@@ -24,8 +24,11 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
 	"github.com/spf13/cobra"
 	"os"
 
@@ -37,8 +40,8 @@ var cfgFile string
 
 var rootCmd = &cobra.Command{
 	Use:   "k3pi",
-	Short: "Install k3os on your favorite ARM device.",
-	Long: `Takes over the os installation and installs k3os on a set of ARM nodes.
+	Short: "Install k3os on your favorite ARM or x86_64 device.",
+	Long: `Takes over the os installation and installs k3os on a set of ARM or x86_64 nodes.
 
 	Example:
 
@@ -56,7 +59,89 @@ func Execute() {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, initSSH)
+
+	rootCmd.PersistentFlags().String(ParamStrictHostKeyChecking, client.HostKeyCheckingAcceptNew, fmt.Sprintf("host key checking, one of %v", client.HostKeyCheckings))
+	rootCmd.PersistentFlags().String(ParamKnownHosts, client.DefaultKnownHostsFile, fmt.Sprintf("known hosts file for new host keys, %s is also read", client.UserKnownHostsFile))
+	rootCmd.PersistentFlags().StringSlice(ParamHostKeyChanged, []string{}, "IP addresses of reinstalled nodes where a changed host key is accepted")
+	rootCmd.PersistentFlags().String(ParamProxyJump, "", "jump hosts for nodes without their own proxy_jump, '[user@]host[:port],...'")
+	rootCmd.PersistentFlags().Duration(ParamTimeout, 0, "max time for the whole command, e.g. 30m, zero is no limit")
+	rootCmd.PersistentFlags().Duration(ParamConnectTimeout, client.DefaultConnectTimeout, "max time for connecting to a node, zero is no limit")
+	rootCmd.PersistentFlags().Duration(ParamOperationTimeout, client.DefaultOperationTimeout, "max time for a remote command or file transfer, zero is no limit")
+	rootCmd.PersistentFlags().Bool(ParamAskSudoPassword, false, fmt.Sprintf("prompt for the sudo password of nodes without one in their auth, or set %s", client.SudoPasswordEnv))
+	rootCmd.PersistentFlags().String(ParamBandwidthLimit, "", "max upload bandwidth in bytes per second for all nodes together, e.g. 10Mi, empty is no limit")
+	rootCmd.PersistentFlags().String(ParamNodeBandwidthLimit, "", "max upload bandwidth in bytes per second for each node, e.g. 2Mi, empty is no limit")
+	rootCmd.PersistentFlags().String(ParamRecord, "", "record all commands, output and transfers with nodes to a file for replay in tests")
+	_ = viper.BindPFlag(ParamStrictHostKeyChecking, rootCmd.PersistentFlags().Lookup(ParamStrictHostKeyChecking))
+	_ = viper.BindPFlag(ParamKnownHosts, rootCmd.PersistentFlags().Lookup(ParamKnownHosts))
+	_ = viper.BindPFlag(ParamHostKeyChanged, rootCmd.PersistentFlags().Lookup(ParamHostKeyChanged))
+	_ = viper.BindPFlag(ParamProxyJump, rootCmd.PersistentFlags().Lookup(ParamProxyJump))
+	_ = viper.BindPFlag(ParamTimeout, rootCmd.PersistentFlags().Lookup(ParamTimeout))
+	_ = viper.BindPFlag(ParamConnectTimeout, rootCmd.PersistentFlags().Lookup(ParamConnectTimeout))
+	_ = viper.BindPFlag(ParamOperationTimeout, rootCmd.PersistentFlags().Lookup(ParamOperationTimeout))
+	_ = viper.BindPFlag(ParamAskSudoPassword, rootCmd.PersistentFlags().Lookup(ParamAskSudoPassword))
+	_ = viper.BindPFlag(ParamBandwidthLimit, rootCmd.PersistentFlags().Lookup(ParamBandwidthLimit))
+	_ = viper.BindPFlag(ParamNodeBandwidthLimit, rootCmd.PersistentFlags().Lookup(ParamNodeBandwidthLimit))
+	_ = viper.BindPFlag(ParamRecord, rootCmd.PersistentFlags().Lookup(ParamRecord))
+}
+
+// commandContext returns the context for running a command, it's stopped on the first interrupt,
+// cancelled on the second and when --timeout is exceeded
+func commandContext() (context.Context, context.CancelFunc) {
+	return misc.WithInterrupt(context.Background(), viper.GetDuration(ParamTimeout))
+}
+
+// initSSH configures host key verification, jump hosts, sudo and recording for all ssh connections
+func initSSH() {
+	checking := viper.GetString(ParamStrictHostKeyChecking)
+	valid := false
+	for _, c := range client.HostKeyCheckings {
+		valid = valid || c == checking
+	}
+	if !valid {
+		misc.ErrorExitWithMessage(fmt.Sprintf("invalid --%s '%s', must be one of %v", ParamStrictHostKeyChecking, checking, client.HostKeyCheckings))
+	}
+
+	verifier := client.NewHostKeyVerifier(checking, viper.GetString(ParamKnownHosts), client.UserKnownHostsFile)
+	for _, ip := range viper.GetStringSlice(ParamHostKeyChanged) {
+		verifier.ExpectChange(ip)
+	}
+	client.DefaultHostKeyVerifier = verifier
+
+	proxyJump := viper.GetString(ParamProxyJump)
+	_, err := client.ParseProxyJump(proxyJump)
+	misc.ExitOnError(err, fmt.Sprintf("invalid --%s", ParamProxyJump))
+	client.DefaultProxyJump = proxyJump
+
+	client.DefaultConnectTimeout = viper.GetDuration(ParamConnectTimeout)
+	client.DefaultOperationTimeout = viper.GetDuration(ParamOperationTimeout)
+	client.TotalBandwidthLimit = bandwidthLimit(ParamBandwidthLimit)
+	client.NodeBandwidthLimit = bandwidthLimit(ParamNodeBandwidthLimit)
+
+	if viper.GetBool(ParamAskSudoPassword) {
+		password, err := client.ReadSudoPassword()
+		misc.ExitOnError(err, "failed to read sudo password")
+		client.DefaultSudoPassword = password
+	}
+
+	if record := viper.GetString(ParamRecord); len(record) > 0 {
+		f, err := os.OpenFile(record, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		misc.ExitOnError(err, fmt.Sprintf("failed to create recording %s", record))
+		client.DefaultRecorder = client.NewRecorder(f)
+	}
+}
+
+// bandwidthLimit parses a bandwidth limit flag with an optional K, M, G, Ki, Mi or Gi suffix, 0 is no limit
+func bandwidthLimit(param string) int64 {
+	value := viper.GetString(param)
+	if len(value) == 0 {
+		return 0
+	}
+	limit, err := model.ParseQuantity(value)
+	if err != nil || limit < 0 {
+		misc.ErrorExitWithMessage(fmt.Sprintf("invalid --%s '%s'", param, value))
+	}
+	return int64(limit)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -24,23 +24,26 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
 	cmd2 "github.com/TheNatureOfSoftware/k3pi/pkg/cmd"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
-	"github.com/kubernetes-sigs/yaml"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
 	"strings"
 )
 
 // scanCmd represents the list command
 var scanCmd = &cobra.Command{
 	Use:   "scan",
-	Short: "Scans the network for ARM devices",
-	Long: `Scans the network for ARM devices with ssh enabled. The scan can use one SSH key
+	Short: "Scans the network for ARM and x86_64 devices",
+	Long: `Scans the network for ARM and x86_64 devices with ssh enabled. The scan can use one SSH key
 and multiple username and password combinations.
 
 	Examples:
@@ -56,35 +59,148 @@ and multiple username and password combinations.
 
 	# Scan filtering on hostname
 	$ k3pi scan --substr pearl
+
+	# Scan filtering on node facts
+	$ k3pi scan --selector 'arch=arm64,model~="Pi 4",mem>=4Gi'
+
+	# Scan for ARM devices only
+	$ k3pi scan --arch arm,arm64
+
+	# Show a summary of all found nodes
+	$ k3pi scan --output table
+
+	# Merge found nodes into an existing nodes file, shows the changes before writing
+	$ k3pi scan --user rancher --merge nodes.yaml
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 
-		keyPath, err := homedir.Expand(viper.GetString(ParamSSHKey))
-		if err != nil {
-			misc.ErrorExitWithError(err)
-		}
+		selector, err := model.ParseSelector(viper.GetString(ParamScanSelectorBindKey))
+		misc.ExitOnError(err, "invalid selector")
+
 		scanRequest := &cmd2.ScanRequest{
 			Cidr:              viper.GetString(ParamCIDR),
 			HostnameSubString: viper.GetString(ParamHostnameSubstring),
 			Port:              viper.GetInt(ParamSSHPort),
-			SSHAuth: &model.Auth{
-				Type:   model.AuthTypeSSHKey,
-				User:   viper.GetString(ParamUser),
-				SSHKey: keyPath,
-			},
-			UserCredentials: credentials(viper.GetStringSlice(ParamAuth)),
+			SSHAuth:           keyAuth(viper.GetString(ParamUser), viper.GetStringSlice(ParamSSHKey), viper.GetString(ParamSSHCert)),
+			UserCredentials:   credentials(viper.GetStringSlice(ParamAuth)),
+			Concurrency:       viper.GetInt(ParamConcurrency),
+			HostTimeout:       viper.GetDuration(ParamHostTimeout),
+			Selector:          selector,
+			Architectures:     viper.GetStringSlice(ParamScanArchBindKey),
 		}
 
-		nodes, err := cmd2.ScanForNodes(client.NewClientFactory(), scanRequest, misc.NewHostScanner())
-		misc.ExitOnError(err, "node scan failed")
+		ctx, cancel := commandContext()
+		defer cancel()
 
-		y, err := yaml.Marshal(nodes)
-		misc.ExitOnError(err, "node scan failed")
+		if inventoryFile := viper.GetString(ParamMerge); len(inventoryFile) > 0 {
+			mergeScan(ctx, inventoryFile, scanRequest)
+			return
+		}
+
+		writer, err := cmd2.NewNodeWriter(viper.GetString(ParamScanOutputBindKey), os.Stdout)
+		misc.ExitOnError(err, "invalid output format")
 
-		fmt.Print(string(y))
+		scanErr := cmd2.ScanForNodesFunc(ctx, client.NewClientFactory(), scanRequest, newHostScanner(), func(node *model.Node) {
+			misc.ExitOnError(writer.Write(node), "node scan failed")
+		})
+
+		// nodes found before an interrupt are written
+		err = writer.Flush()
+		misc.ExitOnError(err, "node scan failed")
+		misc.ExitOnError(scanErr, "node scan failed")
 	},
 }
 
+// mergeScan merges the scan result into an inventory file, prints the diff and writes the file when confirmed
+func mergeScan(ctx context.Context, inventoryFile string, scanRequest *cmd2.ScanRequest) {
+	inventory := readInventory(inventoryFile)
+
+	scanned, err := cmd2.ScanForNodes(ctx, client.NewClientFactory(), scanRequest, newHostScanner())
+	misc.ExitOnError(err, "node scan failed")
+
+	var scannedNodes model.Nodes
+	for i := range *scanned {
+		scannedNodes = append(scannedNodes, &(*scanned)[i])
+	}
+
+	result := cmd2.MergeNodes(inventory, scannedNodes, scanRequest)
+	if !result.HasChanges() {
+		misc.Info(fmt.Sprintf("%s is up to date", inventoryFile))
+		return
+	}
+	result.PrintDiff(os.Stdout)
+
+	if !viper.GetBool(ParamScanConfirmBindKey) {
+		fmt.Printf("Write changes to %s? (y/N): ", inventoryFile)
+		var reply string
+		_, _ = fmt.Scanln(&reply)
+		if answer := strings.TrimSpace(strings.ToUpper(reply)); answer != "YES" && answer != "Y" {
+			return
+		}
+	}
+
+	err = writeInventory(inventoryFile, result.Nodes)
+	misc.ExitOnError(err, "failed to write inventory")
+}
+
+// readInventory reads all nodes from an inventory file, a missing file is an empty inventory
+func readInventory(inventoryFile string) model.Nodes {
+	var inventory model.Nodes
+	if f, err := os.Open(inventoryFile); err == nil {
+		inventory, err = cmd2.ReadNodes(f)
+		f.Close()
+		misc.ExitOnError(err, "error parsing nodes from file")
+	} else if !os.IsNotExist(err) {
+		misc.ExitOnError(err, "error reading inventory file")
+	}
+	return inventory
+}
+
+// writeInventory writes nodes to an inventory file as yaml, or json if the file ends with .json
+func writeInventory(inventoryFile string, nodes model.Nodes) error {
+	format := cmd2.OutputYAML
+	if strings.HasSuffix(inventoryFile, ".json") {
+		format = cmd2.OutputJSON
+	}
+	var b bytes.Buffer
+	if err := cmd2.WriteNodes(format, &b, nodes); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(inventoryFile, b.Bytes(), 0644)
+}
+
+// keyAuth ssh key auth with all key files that exist, the ssh-agent is also used if running
+func keyAuth(user string, keyFiles []string, certFile string) *model.Auth {
+	var keys []string
+	for _, keyFile := range keyFiles {
+		path, err := homedir.Expand(keyFile)
+		misc.ExitOnError(err)
+		if _, err = os.Stat(path); err == nil {
+			keys = append(keys, path)
+		}
+	}
+	if len(keys) == 0 && len(keyFiles) > 0 {
+		keys = keyFiles[:1]
+	}
+
+	auth := &model.Auth{Type: model.AuthTypeSSHKey, User: user, SSHCert: certFile}
+	if len(keys) > 0 {
+		auth.SSHKey = keys[0]
+	}
+	if len(keys) > 1 {
+		auth.SSHKeys = keys[1:]
+	}
+	return auth
+}
+
+// newHostScanner pings for hosts, or returns every host in the CIDR when hosts are behind a jump host
+func newHostScanner() misc.HostScanner {
+	if jumpHosts, _ := client.ParseProxyJump(client.DefaultProxyJump); len(jumpHosts) > 0 {
+		return misc.NewCIDRHostScanner()
+	}
+	return misc.NewHostScanner()
+}
+
 // Splits slice of <username>:<password> and returns a map
 func credentials(basicAuths []string) map[string]string {
 	c := make(map[string]string)
@@ -100,15 +216,31 @@ func credentials(basicAuths []string) map[string]string {
 func init() {
 	rootCmd.AddCommand(scanCmd)
 	scanCmd.Flags().String(ParamUser, "root", "username for ssh login")
-	scanCmd.Flags().String(ParamSSHKey, "~/.ssh/id_rsa", "ssh key to use for remote login")
+	scanCmd.Flags().StringSlice(ParamSSHKey, client.DefaultSSHKeys, "ssh keys to use for remote login, tried in order after keys in the ssh-agent")
+	scanCmd.Flags().String(ParamSSHCert, "", "ssh certificate for the first ssh key, default is <ssh key>-cert.pub if it exists")
 	scanCmd.Flags().Int(ParamSSHPort, 22, "port on which to connect for ssh")
 	scanCmd.Flags().String(ParamCIDR, "192.168.1.0/24", "CIDR to scan for members")
 	scanCmd.Flags().String(ParamHostnameSubstring, "", "Substring that should be part of hostname")
 	scanCmd.Flags().StringSliceP(ParamAuth, "a", []string{}, "Username and password separated with ':' for authentication")
+	scanCmd.Flags().StringSlice(ParamArch, model.DefaultArchitectures, "architectures to scan for, as arm, arm64, amd64 or uname -m")
+	scanCmd.Flags().String(ParamSelector, "", "Selector over node facts, e.g. 'arch=arm64,model~=\"Pi 4\",mem>=4Gi'")
+	scanCmd.Flags().StringP(ParamOutput, "o", cmd2.OutputYAML, fmt.Sprintf("output format, one of %v", cmd2.OutputFormats))
+	scanCmd.Flags().String(ParamMerge, "", "nodes file to merge the scan result into")
+	scanCmd.Flags().BoolP(ParamConfirmInstall, "y", false, "confirm writing the merged nodes file")
+	scanCmd.Flags().Int(ParamConcurrency, cmd2.DefaultScanConcurrency, "number of hosts to probe in parallel")
+	scanCmd.Flags().Duration(ParamHostTimeout, cmd2.DefaultScanHostTimeout, "max time for probing a single host")
 	_ = viper.BindPFlag(ParamUser, scanCmd.Flags().Lookup(ParamUser))
 	_ = viper.BindPFlag(ParamSSHKey, scanCmd.Flags().Lookup(ParamSSHKey))
+	_ = viper.BindPFlag(ParamSSHCert, scanCmd.Flags().Lookup(ParamSSHCert))
 	_ = viper.BindPFlag(ParamSSHPort, scanCmd.Flags().Lookup(ParamSSHPort))
 	_ = viper.BindPFlag(ParamCIDR, scanCmd.Flags().Lookup(ParamCIDR))
 	_ = viper.BindPFlag(ParamHostnameSubstring, scanCmd.Flags().Lookup(ParamHostnameSubstring))
 	_ = viper.BindPFlag(ParamAuth, scanCmd.Flags().Lookup(ParamAuth))
+	_ = viper.BindPFlag(ParamScanArchBindKey, scanCmd.Flags().Lookup(ParamArch))
+	_ = viper.BindPFlag(ParamScanSelectorBindKey, scanCmd.Flags().Lookup(ParamSelector))
+	_ = viper.BindPFlag(ParamScanOutputBindKey, scanCmd.Flags().Lookup(ParamOutput))
+	_ = viper.BindPFlag(ParamMerge, scanCmd.Flags().Lookup(ParamMerge))
+	_ = viper.BindPFlag(ParamScanConfirmBindKey, scanCmd.Flags().Lookup(ParamConfirmInstall))
+	_ = viper.BindPFlag(ParamConcurrency, scanCmd.Flags().Lookup(ParamConcurrency))
+	_ = viper.BindPFlag(ParamHostTimeout, scanCmd.Flags().Lookup(ParamHostTimeout))
 }
@@ -0,0 +1,122 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	pkgcmd "github.com/TheNatureOfSoftware/k3pi/pkg/cmd"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+	"os"
+	"strings"
+)
+
+// sshCmd represents the ssh command
+var sshCmd = &cobra.Command{
+	Use:   "ssh [flags] <hostname|ip> [-- <command>]",
+	Short: "Opens a shell on a node",
+	Long: `Opens an interactive shell on a node in the inventory, or runs a command, using the node's
+credentials and jump hosts, no ssh config for the cluster is needed. Exits with the exit status of
+the remote shell or command.
+
+	Examples:
+
+	Open a shell on k3s-node1
+	$ k3pi ssh --filename nodes.yaml k3s-node1
+
+	Follow the k3s log on 192.168.1.10
+	$ k3pi ssh --filename nodes.yaml 192.168.1.10 -- sudo tail -f /var/log/k3s-service.log
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inventoryFile := viper.GetString(sshKey(ParamFilename))
+		if len(inventoryFile) == 0 {
+			misc.ErrorExitWithMessage("must specify --filename|-f")
+		}
+		_, err := os.Stat(inventoryFile)
+		misc.ExitOnError(err, "error reading inventory file")
+
+		node, err := pkgcmd.FindNode(readInventory(inventoryFile), args[0])
+		misc.ExitOnError(err)
+
+		status, err := shell(node, strings.Join(args[1:], " "))
+		misc.ExitOnError(err)
+		if status != 0 {
+			os.Exit(status)
+		}
+	},
+}
+
+// shell runs the session on the node with a pty if stdin is a terminal, returns the exit status. The
+// terminal is restored before returning.
+func shell(node *model.Node, command string) (int, error) {
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	terminal := &client.Terminal{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		// the remote pty echoes and handles line editing, keys are passed through as is
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return 0, err
+		}
+		defer term.Restore(fd, state)
+
+		terminal.Type = os.Getenv("TERM")
+		if len(terminal.Type) == 0 {
+			terminal.Type = "xterm"
+		}
+		terminal.Size = func() (int, int, error) {
+			return term.GetSize(fd)
+		}
+		terminal.Resized = misc.TerminalResized(ctx, fd)
+	}
+
+	clientFactory := client.NewClientFactory()
+	defer clientFactory.Close()
+
+	err := pkgcmd.Shell(ctx, clientFactory, node, command, terminal)
+	if cmdErr, ok := err.(*client.CommandError); ok {
+		return cmdErr.Result.ExitStatus, nil
+	}
+	return 0, err
+}
+
+// sshKey is the viper key for an ssh flag, keeps ssh flags apart from the flags of other commands
+func sshKey(param string) string {
+	return "ssh-" + param
+}
+
+func init() {
+	rootCmd.AddCommand(sshCmd)
+
+	sshCmd.Flags().StringP(ParamFilename, "f", "", "nodes file with the node and its credentials")
+
+	sshCmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		_ = viper.BindPFlag(sshKey(flag.Name), flag)
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+// Package cmd include Cobra commands
+package cmd
+
+import (
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	pkgcmd "github.com/TheNatureOfSoftware/k3pi/pkg/cmd"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/install"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"os"
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watches the network and installs new nodes as agents",
+	Long: `Watches the network for new ARM and x86_64 devices and installs k3OS on them as agents joining
+a server. Installed nodes are added to the nodes file.
+
+	IMPORTANT! This will overwrite the installation on every new node matching the selector.
+	Nodes where k3OS or k3s is already installed are never touched.
+
+	Examples:
+
+	Scan every minute and install new Raspberry Pi 4 boards as agents joining 192.168.1.10
+	$ k3pi watch --filename nodes.yaml --join 192.168.1.10 --selector 'model~="Pi 4"'
+
+	Joining a server that is not in the nodes file requires a token
+	$ k3pi watch --filename nodes.yaml --join 192.168.1.10 --token <token> --interval 5m
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inventoryFile := viper.GetString(watchKey(ParamFilename))
+		if len(inventoryFile) == 0 {
+			misc.ErrorExitWithMessage("must specify --filename|-f")
+		}
+		join := viper.GetString(watchKey(ParamJoin))
+		if len(join) == 0 {
+			misc.ErrorExitWithMessage("must specify the server to join (--join)")
+		}
+		k3OSVersion := viper.GetString(watchKey(ParamVersion))
+		if len(k3OSVersion) == 0 {
+			misc.ErrorExitWithMessage("k3OS version ( -v|--version ) is empty")
+		}
+
+		selector, err := model.ParseSelector(viper.GetString(watchKey(ParamSelector)))
+		misc.ExitOnError(err, "invalid selector")
+
+		ctx, cancel := commandContext()
+		defer cancel()
+
+		inventory := readInventory(inventoryFile)
+		// not pooled, a pool would keep a connection to every host found in the range open between scans
+		clientFactory := client.NewClientFactory()
+		defer clientFactory.Close()
+
+		serverNode, _, err := pkgcmd.SelectServerAndAgents(inventory, join)
+		misc.ExitOnError(err, "failed to resolve server")
+		serverIP := join
+		if serverNode != nil {
+			serverIP = serverNode.Address.IP
+		}
+
+		token := viper.GetString(watchKey(ParamToken))
+		if len(token) == 0 {
+			if serverNode == nil {
+				misc.ErrorExitWithMessage(fmt.Sprintf("server %s not found in %s, must specify --token", join, inventoryFile))
+			}
+			token, err = pkgcmd.FetchToken(ctx, clientFactory, serverNode)
+			misc.ExitOnError(err)
+		}
+
+		caKeys := trustedUserCAKeys(viper.GetStringSlice(watchKey(ParamTrustedUserCAKey)))
+
+		// k3OS images are downloaded once and reused for all installs
+		resourceDir, err := install.NewResourceDir()
+		misc.ExitOnError(err, "failed to create resource directory")
+		defer os.RemoveAll(resourceDir)
+
+		watcher := &pkgcmd.Watcher{
+			ClientFactory: clientFactory,
+			HostScanner:   newHostScanner(),
+			ScanRequest: &pkgcmd.ScanRequest{
+				Cidr:            viper.GetString(watchKey(ParamCIDR)),
+				Port:            viper.GetInt(watchKey(ParamSSHPort)),
+				SSHAuth:         keyAuth(viper.GetString(watchKey(ParamUser)), viper.GetStringSlice(watchKey(ParamSSHKey)), viper.GetString(watchKey(ParamSSHCert))),
+				UserCredentials: credentials(viper.GetStringSlice(watchKey(ParamAuth))),
+				Concurrency:     viper.GetInt(watchKey(ParamConcurrency)),
+				HostTimeout:     viper.GetDuration(watchKey(ParamHostTimeout)),
+				Selector:        selector,
+				Architectures:   viper.GetStringSlice(watchKey(ParamArch)),
+			},
+			Inventory:      inventory,
+			MaxPerInterval: viper.GetInt(watchKey(ParamMaxPerInterval)),
+			InstallArgs: &pkgcmd.InstallArgs{
+				SSHKeys:           authorizedKeys(viper.GetStringSlice(watchKey(ParamAuthorizedKey)), caKeys),
+				TrustedUserCAKeys: caKeys,
+				Token:             token,
+				ServerID:          serverIP,
+				HostnameSpec: &install.HostnameSpec{
+					Pattern: viper.GetString(watchKey(ParamHostnamePattern)),
+					Prefix:  viper.GetString(watchKey(ParamHostnamePrefix)),
+				},
+				DryRun: viper.GetBool(watchKey(ParamDryRun)),
+				Quiet:  viper.GetBool(watchKey(ParamQuiet)),
+				Templates: &install.ConfigTemplates{
+					AgentTmpl: loadTemplateFile(viper.GetString(watchKey(ParamAgentConfigTmpl))),
+				},
+				K3OSVersion:   k3OSVersion,
+				Architectures: viper.GetStringSlice(watchKey(ParamArch)),
+				ResourceDir:   resourceDir,
+				NodeAuth:      keyAuth("rancher", viper.GetStringSlice(watchKey(ParamSSHKey)), viper.GetString(watchKey(ParamSSHCert))),
+			},
+			Save: func(nodes model.Nodes) error {
+				return writeInventory(inventoryFile, nodes)
+			},
+		}
+
+		err = watcher.Run(ctx, viper.GetDuration(watchKey(ParamInterval)))
+		misc.ExitOnError(err)
+	},
+}
+
+// watchKey is the viper key for a watch flag, keeps watch flags apart from the scan and install flags
+func watchKey(param string) string {
+	return "watch-" + param
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().StringP(ParamFilename, "f", "", "nodes file, installed nodes are added to it")
+	watchCmd.Flags().String(ParamJoin, "", "ip address or hostname of the server new nodes join")
+	watchCmd.Flags().StringP(ParamToken, "t", "", "token for joining the server, read from the server if empty")
+	watchCmd.Flags().Duration(ParamInterval, pkgcmd.DefaultWatchInterval, "time between scans")
+	watchCmd.Flags().Int(ParamMaxPerInterval, pkgcmd.DefaultMaxPerInterval, "max number of nodes installed per interval")
+	watchCmd.Flags().Bool(ParamDryRun, false, "if true will run the install but not execute commands")
+	watchCmd.Flags().BoolP(ParamQuiet, "q", false, "only show the last lines of command output for nodes that fail")
+
+	watchCmd.Flags().String(ParamUser, "root", "username for ssh login")
+	watchCmd.Flags().StringSlice(ParamSSHKey, client.DefaultSSHKeys, "ssh keys to use for remote login, tried in order after keys in the ssh-agent")
+	watchCmd.Flags().String(ParamSSHCert, "", "ssh certificate for the first ssh key, default is <ssh key>-cert.pub if it exists")
+	watchCmd.Flags().Int(ParamSSHPort, 22, "port on which to connect for ssh")
+	watchCmd.Flags().String(ParamCIDR, "192.168.1.0/24", "CIDR to scan for members")
+	watchCmd.Flags().StringSliceP(ParamAuth, "a", []string{}, "Username and password separated with ':' for authentication")
+	watchCmd.Flags().StringSlice(ParamArch, model.DefaultArchitectures, "architectures to install, as arm, arm64, amd64 or uname -m")
+	watchCmd.Flags().String(ParamSelector, "", "only install nodes matching the selector, e.g. 'arch=arm64,model~=\"Pi 4\"'")
+	watchCmd.Flags().Int(ParamConcurrency, pkgcmd.DefaultScanConcurrency, "number of hosts to probe in parallel")
+	watchCmd.Flags().Duration(ParamHostTimeout, pkgcmd.DefaultScanHostTimeout, "max time for probing a single host")
+
+	watchCmd.Flags().StringSliceP(ParamAuthorizedKey, "k", []string{pkgcmd.K3OSDefaultSSHAuthorizedKey}, "ssh authorized key that should be added to the rancher user")
+	watchCmd.Flags().StringSlice(ParamTrustedUserCAKey, []string{}, "CA public key file, nodes accept ssh user certificates signed by the CA")
+	watchCmd.Flags().String(ParamHostnamePattern, "%s%d", "hostname pattern, printf with %s and %d")
+	watchCmd.Flags().String(ParamHostnamePrefix, "k3s-node", "hostname prefix, (hostname = '<prefix><index>')")
+	watchCmd.Flags().String(ParamAgentConfigTmpl, "", "agent k3OS config.yaml template file")
+	watchCmd.Flags().String(ParamVersion, model.DefaultK3OSVersion, fmt.Sprintf("k3OS version, default is %s", model.DefaultK3OSVersion))
+
+	watchCmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		_ = viper.BindPFlag(watchKey(flag.Name), flag)
+	})
+}
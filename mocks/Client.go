@@ -3,7 +3,11 @@
 package mocks
 
 import (
+	context "context"
+
 	client "github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	io "io"
+
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -12,6 +16,20 @@ type Client struct {
 	mock.Mock
 }
 
+// Close provides a mock function with given fields:
+func (_m *Client) Close() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Cmd provides a mock function with given fields: cmd
 func (_m *Client) Cmd(cmd string) client.Script {
 	ret := _m.Called(cmd)
@@ -47,13 +65,27 @@ func (_m *Client) Cmdf(cmd string, a ...interface{}) client.Script {
 	return r0
 }
 
-// Copy provides a mock function with given fields: filename, remotePath
-func (_m *Client) Copy(filename string, remotePath string) error {
-	ret := _m.Called(filename, remotePath)
+// Copy provides a mock function with given fields: ctx, filename, remotePath, progress
+func (_m *Client) Copy(ctx context.Context, filename string, remotePath string, progress client.ProgressFunc) error {
+	ret := _m.Called(ctx, filename, remotePath, progress)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, client.ProgressFunc) error); ok {
+		r0 = rf(ctx, filename, remotePath, progress)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CopyBytes provides a mock function with given fields: ctx, b, remotePath
+func (_m *Client) CopyBytes(ctx context.Context, b *[]byte, remotePath string) error {
+	ret := _m.Called(ctx, b, remotePath)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string) error); ok {
-		r0 = rf(filename, remotePath)
+	if rf, ok := ret.Get(0).(func(context.Context, *[]byte, string) error); ok {
+		r0 = rf(ctx, b, remotePath)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -61,16 +93,46 @@ func (_m *Client) Copy(filename string, remotePath string) error {
 	return r0
 }
 
-// CopyBytes provides a mock function with given fields: b, remotePath
-func (_m *Client) CopyBytes(b *[]byte, remotePath string) error {
-	ret := _m.Called(b, remotePath)
+// Download provides a mock function with given fields: ctx, remotePath, w
+func (_m *Client) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	ret := _m.Called(ctx, remotePath, w)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(*[]byte, string) error); ok {
-		r0 = rf(b, remotePath)
+	if rf, ok := ret.Get(0).(func(context.Context, string, io.Writer) error); ok {
+		r0 = rf(ctx, remotePath, w)
 	} else {
 		r0 = ret.Error(0)
 	}
 
 	return r0
 }
+
+// FS provides a mock function with given fields:
+func (_m *Client) FS() client.FileSystem {
+	ret := _m.Called()
+
+	var r0 client.FileSystem
+	if rf, ok := ret.Get(0).(func() client.FileSystem); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(client.FileSystem)
+		}
+	}
+
+	return r0
+}
+
+// HostKey provides a mock function with given fields:
+func (_m *Client) HostKey() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
@@ -2,20 +2,24 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // HostScanner is an autogenerated mock type for the HostScanner type
 type HostScanner struct {
 	mock.Mock
 }
 
-// ScanForAliveHosts provides a mock function with given fields: cidr
-func (_m *HostScanner) ScanForAliveHosts(cidr string) (*[]string, error) {
-	ret := _m.Called(cidr)
+// ScanForAliveHosts provides a mock function with given fields: ctx, cidr
+func (_m *HostScanner) ScanForAliveHosts(ctx context.Context, cidr string) (*[]string, error) {
+	ret := _m.Called(ctx, cidr)
 
 	var r0 *[]string
-	if rf, ok := ret.Get(0).(func(string) *[]string); ok {
-		r0 = rf(cidr)
+	if rf, ok := ret.Get(0).(func(context.Context, string) *[]string); ok {
+		r0 = rf(ctx, cidr)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*[]string)
@@ -23,8 +27,8 @@ func (_m *HostScanner) ScanForAliveHosts(cidr string) (*[]string, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(cidr)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, cidr)
 	} else {
 		r1 = ret.Error(1)
 	}
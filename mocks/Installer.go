@@ -2,20 +2,39 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+	io "io"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // Installer is an autogenerated mock type for the Installer type
 type Installer struct {
 	mock.Mock
 }
 
-// Install provides a mock function with given fields:
-func (_m *Installer) Install() error {
+// Hostname provides a mock function with given fields:
+func (_m *Installer) Hostname() string {
 	ret := _m.Called()
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
 		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// Install provides a mock function with given fields: ctx, out
+func (_m *Installer) Install(ctx context.Context, out io.Writer) error {
+	ret := _m.Called(ctx, out)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, io.Writer) error); ok {
+		r0 = rf(ctx, out)
 	} else {
 		r0 = ret.Error(0)
 	}
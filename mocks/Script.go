@@ -3,7 +3,11 @@
 package mocks
 
 import (
+	context "context"
+
 	client "github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	io "io"
+
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -47,13 +51,36 @@ func (_m *Script) Cmdf(cmd string, a ...interface{}) client.Script {
 	return r0
 }
 
-// Output provides a mock function with given fields:
-func (_m *Script) Output() ([]byte, error) {
-	ret := _m.Called()
+// Exec provides a mock function with given fields: ctx
+func (_m *Script) Exec(ctx context.Context) (client.Results, error) {
+	ret := _m.Called(ctx)
+
+	var r0 client.Results
+	if rf, ok := ret.Get(0).(func(context.Context) client.Results); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(client.Results)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Output provides a mock function with given fields: ctx
+func (_m *Script) Output(ctx context.Context) ([]byte, error) {
+	ret := _m.Called(ctx)
 
 	var r0 []byte
-	if rf, ok := ret.Get(0).(func() []byte); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) []byte); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]byte)
@@ -61,8 +88,8 @@ func (_m *Script) Output() ([]byte, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -70,13 +97,27 @@ func (_m *Script) Output() ([]byte, error) {
 	return r0, r1
 }
 
-// Run provides a mock function with given fields:
-func (_m *Script) Run() error {
-	ret := _m.Called()
+// Run provides a mock function with given fields: ctx
+func (_m *Script) Run(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Stream provides a mock function with given fields: ctx, stdout, stderr
+func (_m *Script) Stream(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+	ret := _m.Called(ctx, stdout, stderr)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context, io.Writer, io.Writer) error); ok {
+		r0 = rf(ctx, stdout, stderr)
 	} else {
 		r0 = ret.Error(0)
 	}
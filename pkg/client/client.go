@@ -24,179 +24,320 @@ THE SOFTWARE.
 package client
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"github.com/TheNatureOfSoftware/go-sshclient"
-	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
-	"github.com/bramvdbogaerde/go-scp"
-	"github.com/mitchellh/go-homedir"
 	"golang.org/x/crypto/ssh"
-	"io/ioutil"
-	"os"
-	"os/exec"
+	"io"
 	"strings"
 	"time"
 )
 
-// NewClientFactory creates a fake client factory
+const keepAliveTimeout = 5 * time.Second
+
+var (
+	// DefaultConnectTimeout max time for connecting to a node, including jump hosts, zero is no limit
+	DefaultConnectTimeout = 30 * time.Second
+	// DefaultOperationTimeout max time for running a script or transferring a file, zero is no limit
+	DefaultOperationTimeout time.Duration
+)
+
+// NewClientFactory creates a client factory, sessions are recorded if DefaultRecorder is set
 func NewClientFactory() *Factory {
-	return &Factory{Create:NewClient}
+	return recordingFactory(&Factory{Create:NewClient})
 }
 
 // Factory factory for creating new clients
 type Factory struct {
-	Create func(auth *model.Auth, address *model.Address) (Client, error)
+	Create func(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error)
+	close  func() error
+}
+
+// Close closes all connections held by a pooled factory
+func (f *Factory) Close() error {
+	if f.close == nil {
+		return nil
+	}
+	return f.close()
 }
 
 // Client runs commands an copies files
 type Client interface {
 	Cmd(cmd string) Script
 	Cmdf(cmd string, a ...interface{}) Script
-	Copy(filename, remotePath string) error
-	CopyBytes(b *[]byte, remotePath string) error
+	Copy(ctx context.Context, filename, remotePath string, progress ProgressFunc) error
+	CopyBytes(ctx context.Context, b *[]byte, remotePath string) error
+	Download(ctx context.Context, remotePath string, w io.Writer) error
+	// FS remote file system of the node
+	FS() FileSystem
+	HostKey() string
+	Close() error
 }
 
-// Script script for running remote commands
+// Script script for running remote commands. Commands starting with sudo never wait for a password,
+// the sudo password of the auth is given when sudo prompts for it.
 type Script interface {
 	Cmd(cmd string) Script
 	Cmdf(cmd string, a ...interface{}) Script
-	Run() error
-	Output() ([]byte, error)
+	Run(ctx context.Context) error
+	Output(ctx context.Context) ([]byte, error)
+	Stream(ctx context.Context, stdout, stderr io.Writer) error
+	Exec(ctx context.Context) (Results, error)
 }
 
-// NewClient factory method for creating a new node client
-func NewClient(auth *model.Auth, address *model.Address) (Client, error) {
-
-	var sshClient *sshclient.Client
-	var err error
-	if auth.Type == model.AuthTypeSSHKey {
-		privateKeyFile, err := homedir.Expand(auth.SSHKey)
-		misc.PanicOnError(err, "failed to expand ssh key file path")
-		sshClient, err = sshclient.DialWithKey(address.String(), auth.User, privateKeyFile)
-	} else {
-		sshClient, err = sshclient.DialWithPasswd(address.String(), auth.User, auth.Password)
-	}
+// NewClient factory method for creating a new node client, gives up connecting when ctx is done
+func NewClient(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error) {
 
+	config, err := clientConfig(auth)
 	if err != nil {
 		return nil, err
 	}
 
-	if sshClient == nil {
-		return nil, fmt.Errorf("ssh client is nil, maybe because of wrong user")
+	c := &client{
+		auth:    auth,
+		address: address,
+	}
+	config.HostKeyCallback = DefaultHostKeyVerifier.Callback(address, func(key string) {
+		c.hostKey = key
+	})
+
+	if DefaultConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultConnectTimeout)
+		defer cancel()
 	}
 
-	c := &client{
-		auth:      auth,
-		address:   address,
-		sshClient: sshClient,
+	c.sshClient, c.jumps, err = dial(ctx, auth, address, config)
+	if err != nil {
+		return nil, err
 	}
+	c.done = make(chan struct{})
+	go func() {
+		_ = c.sshClient.Wait()
+		close(c.done)
+	}()
 
 	return c, nil
 }
 
+// clientConfig creates the ssh client config for key or password authentication
+func clientConfig(auth *model.Auth) (*ssh.ClientConfig, error) {
+	var authMethod ssh.AuthMethod
+	if auth.Type == model.AuthTypeSSHKey {
+		authMethod = publicKeysAuth(auth)
+	} else {
+		authMethod = ssh.Password(auth.Password)
+	}
+
+	return &ssh.ClientConfig{
+		User: auth.User,
+		Auth: []ssh.AuthMethod{authMethod},
+	}, nil
+}
+
 type client struct {
-	sshClient *sshclient.Client
+	sshClient *ssh.Client
 	auth      *model.Auth
 	address   *model.Address
+	hostKey   string
+	jumps     []*ssh.Client
+	// done is closed when the connection is closed
+	done chan struct{}
 }
 
-func (c *client) Cmd(cmd string) Script {
-	rs := c.sshClient.Cmd(cmd)
-	return &script{remoteScript: rs}
+// disconnected returns true if the connection has been closed, e.g. by a reboot
+func (c *client) disconnected() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
 }
 
-func (c *client) Cmdf(cmd string, a ...interface{}) Script {
-	return c.Cmd(fmt.Sprintf(cmd, a...))
+// alive checks that the connection is still open, e.g. not lost in a reboot
+func (c *client) alive() bool {
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.sshClient.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err == nil
+	case <-time.After(keepAliveTimeout):
+		return false
+	}
 }
 
-func (c *client) Copy(filename, remotePath string) error {
-
-	if c.auth.Type != model.AuthTypeSSHKey {
-		_, err := c.copyWithPassword(filename, remotePath)
-		return err
-	}
-	out, err := c.copyWithKey(filename, remotePath)
-	if err != nil {
-		return fmt.Errorf(strings.TrimSpace(string(out)))
-	}
+func (c *client) HostKey() string {
+	return c.hostKey
+}
 
+func (c *client) Close() error {
+	err := c.sshClient.Close()
+	closeJumps(c.jumps)
 	return err
 }
 
-func (c *client) copyWithPassword(filename string, remotePath string) ([]byte, error) {
-	config, closeHandler := passwordClientConfig(c.auth.User, c.auth.Password)
-	defer closeHandler()
-
-	scpClient := scp.NewClient(c.address.String(), config)
-	err := scpClient.Connect()
-	misc.PanicOnError(err, fmt.Sprintf("failed to connect to %s", c.address))
-
-	// Open a file
-	f, _ := os.Open(filename)
+func (c *client) Cmd(cmd string) Script {
+	return &script{sshClient: c.sshClient, sudoPassword: sudoPassword(c.auth), cmds: []string{cmd}}
+}
 
-	defer scpClient.Close()
-	defer f.Close()
+func (c *client) Cmdf(cmd string, a ...interface{}) Script {
+	return c.Cmd(fmt.Sprintf(cmd, a...))
+}
 
-	err = scpClient.CopyFromFile(*f, remotePath, "0655")
-	var out []byte
+func (c *client) FS() FileSystem {
+	return &remoteFS{client: c}
+}
 
-	return out, err
+// Copy uploads a file, resumes a partial upload and reports progress to progress if not nil
+func (c *client) Copy(ctx context.Context, filename, remotePath string, progress ProgressFunc) error {
+	return c.upload(ctx, filename, remotePath, progress)
 }
 
-func passwordClientConfig(username string, password string) (*ssh.ClientConfig, func() error) {
-	return &ssh.ClientConfig{
-		User:            username,
-		Auth:            []ssh.AuthMethod{ssh.Password(password)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         time.Second * 3,
-	}, func() error { return nil }
+// CopyBytes uploads b, a new file is only readable by the user, e.g. the temp file of WriteFile
+func (c *client) CopyBytes(ctx context.Context, b *[]byte, remotePath string) error {
+	return withSession(ctx, c.sshClient, func(session *ssh.Session) error {
+		return scpUpload(session, bytes.NewReader(*b), int64(len(*b)), 0600, remotePath)
+	})
 }
 
-func (c *client) copyWithKey(filename string, remotePath string) ([]byte, error) {
-	out, err := exec.Command(
-		"scp",
-		"-i",
-		c.auth.SSHKey,
-		"-P",
-		fmt.Sprintf("%d", c.address.Port),
-		"-o",
-		"StrictHostKeyChecking=no",
-		filename,
-		fmt.Sprintf("%s@%s:%s", c.auth.User, c.address.IP, remotePath)).CombinedOutput()
-	return out, err
+func (c *client) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	return withSession(ctx, c.sshClient, func(session *ssh.Session) error {
+		return scpDownload(session, remotePath, w)
+	})
 }
 
-func (c *client) CopyBytes(b *[]byte, remotePath string) error {
-	f, err := ioutil.TempFile(os.TempDir(), "k3pi-*")
-	misc.PanicOnError(err, "failed to create temp file")
+// withSession runs fn on a new session. When ctx is done, or the operation timeout is exceeded, the
+// remote command is terminated and the session closed without waiting for fn.
+func withSession(ctx context.Context, sshClient *ssh.Client, fn func(session *ssh.Session) error) error {
+	if DefaultOperationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultOperationTimeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	f.Write(*b)
-	f.Close()
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
 
-	err = c.Copy(f.Name(), remotePath)
-	defer os.Remove(f.Name())
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(session)
+	}()
 
-	return err
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGTERM)
+		_ = session.Close()
+		return ctx.Err()
+	}
 }
 
+// script runs each command in its own session and stops at the first failing command
 type script struct {
-	remoteScript *sshclient.RemoteScript
+	sshClient    *ssh.Client
+	sudoPassword string
+	cmds         []string
 }
 
 func (s *script) Cmd(cmd string) Script {
-	s.remoteScript = s.remoteScript.Cmd(cmd)
+	s.cmds = append(s.cmds, cmd)
 	return s
 }
 
 func (s *script) Cmdf(cmd string, a ...interface{}) Script {
-	s.remoteScript = s.remoteScript.Cmd(fmt.Sprintf(cmd, a...))
-	return s
+	return s.Cmd(fmt.Sprintf(cmd, a...))
+}
+
+func (s *script) Run(ctx context.Context) error {
+	_, err := s.exec(ctx, nil, nil)
+	return err
+}
+
+// Output returns stdout, or stderr if a command fails
+func (s *script) Output(ctx context.Context) ([]byte, error) {
+	results, err := s.exec(ctx, nil, nil)
+	if err != nil {
+		if last := results.Last(); last != nil {
+			return last.Stderr, err
+		}
+		return nil, err
+	}
+	return results.Stdout(), nil
+}
+
+// Stream writes output to stdout and stderr while the commands run
+func (s *script) Stream(ctx context.Context, stdout, stderr io.Writer) error {
+	_, err := s.exec(ctx, stdout, stderr)
+	return err
 }
 
-func (s *script) Run() error {
-	return s.remoteScript.Run()
+// Exec returns the result of each command run, a command exiting with a non-zero status is a CommandError
+func (s *script) Exec(ctx context.Context) (Results, error) {
+	return s.exec(ctx, nil, nil)
 }
 
-func (s *script) Output() ([]byte, error) {
-	return s.remoteScript.SmartOutput()
+// exec runs the commands until one fails, output is captured in the results and copied to stdout and
+// stderr if not nil. The result of a command that didn't complete, e.g. cancelled, is not included.
+func (s *script) exec(ctx context.Context, stdout, stderr io.Writer) (Results, error) {
+	var results Results
+	for _, cmd := range s.cmds {
+		for _, line := range strings.Split(cmd, "\n") {
+			if len(strings.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			var outBuf, errBuf bytes.Buffer
+			start := time.Now()
+			err := withSession(ctx, s.sshClient, func(session *ssh.Session) error {
+				session.Stdout = tee(&outBuf, stdout)
+				session.Stderr = tee(&errBuf, stderr)
+				cmd, flush, err := sudo(session, line, s.sudoPassword)
+				if err != nil {
+					return err
+				}
+				defer flush()
+				return session.Run(cmd)
+			})
+			if ctx.Err() != nil {
+				// the cancelled session may still be writing
+				return results, ctx.Err()
+			}
+
+			result := &Result{Cmd: line, Stdout: outBuf.Bytes(), Stderr: errBuf.Bytes(), Duration: time.Since(start)}
+			if err != nil {
+				exitErr, ok := err.(*ssh.ExitError)
+				if !ok {
+					result.ExitStatus = ExitStatusUnknown
+					return append(results, result), err
+				}
+				result.ExitStatus = exitErr.ExitStatus()
+				if reason := sudoFailure(result.Stderr); isSudo(line) && len(reason) > 0 {
+					return append(results, result), &SudoError{Result: result, Reason: reason}
+				}
+				return append(results, result), &CommandError{Result: result}
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// tee writes to buf and w, if w is not nil
+func tee(buf *bytes.Buffer, w io.Writer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(buf, w)
 }
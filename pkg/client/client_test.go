@@ -23,6 +23,7 @@ THE SOFTWARE.
 package client
 
 import (
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
 	"reflect"
@@ -43,7 +44,7 @@ var auth = &model.Auth{
 func TestNewClientManual(t *testing.T) {
 	t.Skip("manual test")
 
-	c, err := NewClient(auth, &ManualTestAddress)
+	c, err := NewClient(context.Background(), auth, &ManualTestAddress)
 
 	if err != nil {
 		t.Error(err)
@@ -57,12 +58,12 @@ func TestNewClientManual(t *testing.T) {
 func TestClientManual_Cmd(t *testing.T) {
 	t.Skip("manual test")
 
-	c, err := NewClient(auth, &ManualTestAddress)
+	c, err := NewClient(context.Background(), auth, &ManualTestAddress)
 	if err != nil {
 		t.Error(err)
 	}
 
-	out, err := c.Cmd("whoami").Output()
+	out, err := c.Cmd("whoami").Output(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -76,12 +77,12 @@ func TestClientManual_Cmd(t *testing.T) {
 
 func TestClientManual_Copy(t *testing.T) {
 	t.Skip("manual test")
-	c, err := NewClient(auth, &ManualTestAddress)
+	c, err := NewClient(context.Background(), auth, &ManualTestAddress)
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = c.Copy("./README.md", "~/README.md")
+	err = c.Copy(context.Background(), "./README.md", "~/README.md", nil)
 
 	if err != nil {
 		t.Error(err)
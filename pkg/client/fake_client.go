@@ -1,28 +1,35 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"io"
+	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 // NewFakeClientFactory creates a fake client factory
 func NewFakeClientFactory(configurator ...func(script *FakeScript)) (*Factory, *FakeScript) {
-	fs := &FakeScript{m: sync.Mutex{}, Interactions:make(map[string][]string)}
-	return &Factory{Create: func(auth *model.Auth, address *model.Address) (i Client, e error) {
+	fs := &FakeScript{m: sync.Mutex{}, Interactions:make(map[string][]string), FS: NewFakeFS()}
+	return &Factory{Create: func(ctx context.Context, auth *model.Auth, address *model.Address) (i Client, e error) {
 		fc := &FakeClient{FakeScript: fs}
 		fc.Auth = auth
 		fc.Address = address
 		for _, it := range configurator { it(fc.FakeScript) }
+		if err := fs.connect(ctx, address); err != nil {
+			return nil, err
+		}
 		return fc, nil
 	}}, fs
 }
 
 // NewFakeClient factory method for creating a fake node client
-func NewFakeClient(auth *model.Auth, address *model.Address) (Client, error) {
+func NewFakeClient(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error) {
 	return &FakeClient{
-		FakeScript: &FakeScript{m: sync.Mutex{}, Interactions:make(map[string][]string)},
+		FakeScript: &FakeScript{m: sync.Mutex{}, Interactions:make(map[string][]string), FS: NewFakeFS()},
 		Auth:    auth,
 		Address: address,
 	}, nil
@@ -36,24 +43,78 @@ type FakeClient struct {
 }
 
 // CopyBytes fakes copy of []byte to remote path
-func (f *FakeClient) CopyBytes(b *[]byte, remotePath string) error {
-	fmt.Printf("scp %s:\n%v\n", remotePath, b)
+func (f *FakeClient) CopyBytes(ctx context.Context, b *[]byte, remotePath string) error {
+	if err := f.FakeScript.sleep(ctx); err != nil {
+		return err
+	}
+	if f.FakeScript.transferFault(remotePath) >= 0 {
+		return fmt.Errorf("failed to copy to %s: connection lost", remotePath)
+	}
 	return nil
 }
 
-// Copy fakes copy of file to remote path
-func (f *FakeClient) Copy(filename, remotePath string) error {
-	fmt.Printf("scp -i %s -P %d -o StrictHostKeyChecking=no %s %s\n",
-		f.Auth.SSHKey,
-		f.Address.Port,
-		filename,
-		fmt.Sprintf("%s@%s:%s", f.Auth.User, f.Address.IP, remotePath))
+// Copy fakes copy of file to remote path, reports the whole file as uploaded if the file exists. An
+// upload dropped with Disconnect reports what was uploaded and the next upload resumes from there.
+func (f *FakeClient) Copy(ctx context.Context, filename, remotePath string, progress ProgressFunc) error {
+	if err := f.FakeScript.sleep(ctx); err != nil {
+		return err
+	}
+	size := int64(-1)
+	if info, err := os.Stat(filename); err == nil {
+		size = info.Size()
+	}
+	if after := f.FakeScript.transferFault(remotePath); after >= 0 {
+		resumed := f.FakeScript.resume(remotePath, after)
+		if progress != nil && size >= 0 && after <= size {
+			progress(Progress{RemotePath: remotePath, Done: after, Resumed: resumed, Size: size})
+		}
+		return fmt.Errorf("failed to copy to %s: connection lost", remotePath)
+	}
+	resumed := f.FakeScript.resume(remotePath, 0)
+	if progress != nil && size >= 0 {
+		progress(Progress{RemotePath: remotePath, Done: size, Resumed: resumed, Size: size})
+	}
 	return nil
 }
 
-// Cmd adds command for fake execution
+// Download fakes copy of remote path, writes the content expected with ExpectDownload
+func (f *FakeClient) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	if err := f.FakeScript.sleep(ctx); err != nil {
+		return err
+	}
+	content, ok := f.FakeScript.Downloads[remotePath]
+	if !ok {
+		return fmt.Errorf("scp: %s: No such file or directory", remotePath)
+	}
+	if after := f.FakeScript.transferFault(remotePath); after >= 0 {
+		if after < int64(len(content)) {
+			content = content[:after]
+		}
+		_, _ = io.WriteString(w, content)
+		return fmt.Errorf("failed to copy from %s: connection lost", remotePath)
+	}
+	_, err := io.WriteString(w, content)
+	return err
+}
+
+// FS returns the fake file system
+func (f *FakeClient) FS() FileSystem {
+	return &fakeFileSystem{fs: f.FakeScript.FS}
+}
+
+// HostKey returns the host key pinned in the address
+func (f *FakeClient) HostKey() string {
+	return f.Address.HostKey
+}
+
+// Close fakes closing the connection
+func (f *FakeClient) Close() error {
+	return nil
+}
+
+// Cmd adds command for fake execution, a reboot command in the script reboots this node
 func (f *FakeClient) Cmd(cmd string) Script {
-	return f.FakeScript.Cmd(cmd)
+	return f.FakeScript.cmd(cmd, f.Address)
 }
 
 // Cmdf adds command for fake execution
@@ -61,12 +122,23 @@ func (f *FakeClient) Cmdf(cmd string, a ...interface{}) Script {
 	return f.Cmd(fmt.Sprintf(cmd, a...))
 }
 
-// FakeScript for fake script execution
+// FakeScript for fake script execution. Faults like failing connections and commands are injected
+// with FailDial, FailAuth, FailAfterReboot, FailCmd and Disconnect on the script returned by
+// NewFakeClientFactory.
 type FakeScript struct {
 	m            sync.Mutex
 	Error        error
 	InvokedCmds  []string
 	Interactions map[string][]string
+	Downloads    map[string]string
+	// FS file system of the fake clients
+	FS *FakeFS
+	// Latency added when connecting, running a script and copying
+	Latency time.Duration
+
+	faults fakeFaults
+	// addresses the node each invoked command is run on, nil if not known
+	addresses []*model.Address
 }
 
 // Expect what command to expect: stdin and stdout
@@ -79,11 +151,30 @@ func (s *FakeScript) Expect(stdin, stdout string) {
 	}
 }
 
-// Cmd add command for fake execution
+// ExpectDownload what content to return when downloading remote path
+func (s *FakeScript) ExpectDownload(remotePath, content string) {
+	if s.Downloads == nil {
+		s.Downloads = make(map[string]string)
+	}
+	s.Downloads[remotePath] = content
+}
+
+// Cmd add command for fake execution, the command is run on the node of the previous command
 func (s *FakeScript) Cmd(cmd string) Script {
+	s.m.Lock()
+	var address *model.Address
+	if n := len(s.addresses); n > 0 {
+		address = s.addresses[n-1]
+	}
+	s.m.Unlock()
+	return s.cmd(cmd, address)
+}
+
+func (s *FakeScript) cmd(cmd string, address *model.Address) Script {
 	s.m.Lock()
 	defer s.m.Unlock()
 	s.InvokedCmds = append(s.InvokedCmds, cmd)
+	s.addresses = append(s.addresses, address)
 	return s
 }
 
@@ -93,8 +184,17 @@ func (s *FakeScript) Cmdf(cmd string, a ...interface{}) Script {
 }
 
 // Run fakes running command on remote host
-func (s *FakeScript) Run() error {
-	_, err := s.Output()
+func (s *FakeScript) Run(ctx context.Context) error {
+	_, err := s.Output(ctx)
+	return err
+}
+
+// Stream fakes running command on remote host and writes configured output to stdout
+func (s *FakeScript) Stream(ctx context.Context, stdout, stderr io.Writer) error {
+	out, err := s.Output(ctx)
+	if _, werr := stdout.Write(out); werr != nil {
+		return werr
+	}
 	return err
 }
 
@@ -108,13 +208,36 @@ func (s *FakeScript) HasOutstandingCmds() bool {
 	return false
 }
 
-// Output fakes running command on remote host and returns configured output
-func (s *FakeScript) Output() ([]byte, error) {
+// Output fakes running command on remote host and returns configured output, fails if ctx is done
+func (s *FakeScript) Output(ctx context.Context) ([]byte, error) {
+	results, err := s.Exec(ctx)
+	if results == nil {
+		return nil, err
+	}
+	var output []string
+	for _, r := range results {
+		output = append(output, string(r.Stdout))
+	}
+	return []byte(strings.Join(output, "\n")), err
+}
+
+// Exec fakes running command on remote host and returns a result with the configured output for each
+// command. If Error is set the last command fails with exit status 1, a command failing with FailCmd
+// stops the script.
+func (s *FakeScript) Exec(ctx context.Context) (Results, error) {
+	// a done ctx fails below
+	_ = s.sleep(ctx)
+
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	var output []string
-	for _, v := range s.InvokedCmds {
+	if err := ctx.Err(); err != nil {
+		s.InvokedCmds, s.addresses = []string{}, nil
+		return nil, err
+	}
+
+	var results Results
+	for i, v := range s.InvokedCmds {
 		var cmdOut string
 		if out, ok := s.Interactions[v]; ok {
 			size := len(out)
@@ -129,15 +252,23 @@ func (s *FakeScript) Output() ([]byte, error) {
 		} else {
 			cmdOut = ""
 		}
-		output = append(output, cmdOut)
+		result := &Result{Cmd: v, Stdout: []byte(cmdOut)}
+		results = append(results, result)
 		fmt.Printf("$ %s\n%s\n", v, cmdOut)
+		if cmdErr := s.cmdFault(result); cmdErr != nil {
+			s.InvokedCmds, s.addresses = []string{}, nil
+			return results, cmdErr
+		}
+		if i < len(s.addresses) {
+			s.rebooted(v, s.addresses[i])
+		}
 	}
 
-	b := strings.Builder{}
-	for _, s := range output {
-		b.WriteString(fmt.Sprintf("%s\n", s))
+	if last := results.Last(); last != nil && s.Error != nil {
+		last.ExitStatus = 1
+		last.Stderr = []byte(s.Error.Error())
 	}
 
-	s.InvokedCmds = []string{}
-	return []byte(b.String()), s.Error
+	s.InvokedCmds, s.addresses = []string{}, nil
+	return results, s.Error
 }
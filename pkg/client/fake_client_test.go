@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
 	"strings"
@@ -14,13 +15,13 @@ func TestNewFakeClient(t *testing.T) {
 		script.Expect("whoami", "testuser")
 	})
 
-	client, err := cf.Create(&model.Auth{}, &address)
+	client, err := cf.Create(context.Background(), &model.Auth{}, &address)
 
 	if err != nil {
 		t.Error(err)
 	}
 
-	output, err := client.Cmd("whoami").Output()
+	output, err := client.Cmd("whoami").Output(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
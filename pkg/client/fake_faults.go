@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"regexp"
+	"time"
+)
+
+// rebootRegexp matches commands rebooting the node
+var rebootRegexp = regexp.MustCompile(`\breboot\b`)
+
+// fakeFaults faults injected by the fake clients of a FakeScript
+type fakeFaults struct {
+	dial        map[string]int
+	auth        map[string]bool
+	afterReboot map[string]int
+	cmds        []fakeCmdFault
+	transfers   map[string]*fakeTransferFault
+	resumed     map[string]int64
+}
+
+type fakeCmdFault struct {
+	pattern    *regexp.Regexp
+	exitStatus int
+	stderr     string
+}
+
+type fakeTransferFault struct {
+	after int64
+	times int
+}
+
+func (s *FakeScript) initFaults() {
+	if s.faults.dial == nil {
+		s.faults = fakeFaults{
+			dial:        make(map[string]int),
+			auth:        make(map[string]bool),
+			afterReboot: make(map[string]int),
+			transfers:   make(map[string]*fakeTransferFault),
+			resumed:     make(map[string]int64),
+		}
+	}
+}
+
+// FailDial makes connecting to the node with the ip fail, times is the number of attempts that fail,
+// -1 fails every attempt
+func (s *FakeScript) FailDial(ip string, times int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.initFaults()
+	s.faults.dial[ip] = times
+}
+
+// FailAuth makes authentication to the node with the ip fail
+func (s *FakeScript) FailAuth(ip string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.initFaults()
+	s.faults.auth[ip] = true
+}
+
+// FailAfterReboot makes connecting to the node with the ip fail after a reboot command has been run,
+// times is the number of attempts that fail, -1 and the node never comes back
+func (s *FakeScript) FailAfterReboot(ip string, times int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.initFaults()
+	s.faults.afterReboot[ip] = times
+}
+
+// FailCmd makes commands matching the regular expression exit with the status and stderr, commands
+// after a failing command in a script are not run
+func (s *FakeScript) FailCmd(pattern string, exitStatus int, stderr string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.initFaults()
+	s.faults.cmds = append(s.faults.cmds, fakeCmdFault{pattern: regexp.MustCompile(pattern), exitStatus: exitStatus, stderr: stderr})
+}
+
+// Disconnect makes the connection drop after the given number of bytes when copying to or from the
+// remote path, times is the number of transfers that fail, -1 fails every transfer. An upload
+// following a dropped upload resumes from where it was dropped.
+func (s *FakeScript) Disconnect(remotePath string, after int64, times int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.initFaults()
+	s.faults.transfers[remotePath] = &fakeTransferFault{after: after, times: times}
+}
+
+// sleep waits Latency or until ctx is done
+func (s *FakeScript) sleep(ctx context.Context) error {
+	if s.Latency <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.Latency):
+		return nil
+	}
+}
+
+// connect fails if a dial or auth failure is injected for the address
+func (s *FakeScript) connect(ctx context.Context, address *model.Address) error {
+	if err := s.sleep(ctx); err != nil {
+		return err
+	}
+	if address == nil {
+		return nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if n := s.faults.dial[address.IP]; n != 0 {
+		if n > 0 {
+			s.faults.dial[address.IP] = n - 1
+		}
+		return fmt.Errorf("dial tcp %s: connect: connection refused", address)
+	}
+	if s.faults.auth[address.IP] {
+		return fmt.Errorf("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none], no supported methods remain")
+	}
+	return nil
+}
+
+// cmdFault returns the injected failure of the command, nil if it succeeds
+func (s *FakeScript) cmdFault(result *Result) *CommandError {
+	for _, f := range s.faults.cmds {
+		if f.pattern.MatchString(result.Cmd) {
+			result.ExitStatus = f.exitStatus
+			result.Stderr = []byte(f.stderr)
+			return &CommandError{Result: result}
+		}
+	}
+	return nil
+}
+
+// rebooted injects the dial failures after reboot for the node the command is run on
+func (s *FakeScript) rebooted(cmd string, address *model.Address) {
+	if address == nil || !rebootRegexp.MatchString(cmd) {
+		return
+	}
+	if n, ok := s.faults.afterReboot[address.IP]; ok {
+		s.faults.dial[address.IP] = n
+	}
+}
+
+// transferFault returns the number of bytes transferred before the connection drops, -1 if it doesn't
+func (s *FakeScript) transferFault(remotePath string) int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	f, ok := s.faults.transfers[remotePath]
+	if !ok || f.times == 0 {
+		return -1
+	}
+	if f.times > 0 {
+		f.times--
+	}
+	return f.after
+}
+
+// resume returns the offset an upload resumes from and records the new offset
+func (s *FakeScript) resume(remotePath string, offset int64) int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	resumed := s.faults.resumed[remotePath]
+	if s.faults.resumed != nil {
+		s.faults.resumed[remotePath] = offset
+	}
+	return resumed
+}
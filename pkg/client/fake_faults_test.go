@@ -0,0 +1,121 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFakeScript_FailDial(t *testing.T) {
+	cf, script := NewFakeClientFactory()
+	script.FailDial("10.0.0.1", 2)
+	script.FailAuth("10.0.0.2")
+	node1, node2 := model.NewAddress("10.0.0.1", 22), model.NewAddress("10.0.0.2", 22)
+
+	for i := 0; i < 2; i++ {
+		_, err := cf.Create(context.Background(), &model.Auth{}, &node1)
+		assert.EqualError(t, err, "dial tcp 10.0.0.1:22: connect: connection refused")
+	}
+	_, err := cf.Create(context.Background(), &model.Auth{}, &node1)
+	assert.NoError(t, err)
+
+	_, err = cf.Create(context.Background(), &model.Auth{}, &node2)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to authenticate")
+}
+
+func TestFakeScript_FailAfterReboot(t *testing.T) {
+	cf, script := NewFakeClientFactory()
+	script.FailAfterReboot("10.0.0.1", -1)
+	address := model.NewAddress("10.0.0.1", 22)
+
+	c, err := cf.Create(context.Background(), &model.Auth{}, &address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, c.Cmd("sudo sync").Cmd("sudo sh -c 'reboot -d 1 &'").Run(context.Background()))
+
+	for i := 0; i < 3; i++ {
+		_, err = cf.Create(context.Background(), &model.Auth{}, &address)
+		assert.Error(t, err)
+	}
+}
+
+func TestFakeScript_FailAfterReboot_OtherNode(t *testing.T) {
+	cf, script := NewFakeClientFactory()
+	address1, address2 := model.NewAddress("10.0.0.1", 22), model.NewAddress("10.0.0.2", 22)
+	script.FailAfterReboot(address1.IP, -1)
+	script.FailAfterReboot(address2.IP, -1)
+
+	c1, _ := cf.Create(context.Background(), &model.Auth{}, &address1)
+	c2, _ := cf.Create(context.Background(), &model.Auth{}, &address2)
+
+	// node2 adds a command before node1's reboot is run
+	reboot := c1.Cmd("sudo sync").Cmd("sudo sh -c 'reboot -d 1 &'")
+	c2.Cmd("whoami")
+	assert.NoError(t, reboot.Run(context.Background()))
+
+	_, err := cf.Create(context.Background(), &model.Auth{}, &address1)
+	assert.Error(t, err)
+	_, err = cf.Create(context.Background(), &model.Auth{}, &address2)
+	assert.NoError(t, err, "only the rebooted node should be down")
+}
+
+func TestFakeScript_FailCmd(t *testing.T) {
+	cf, script := NewFakeClientFactory()
+	script.FailCmd(`^sudo tar `, 2, "tar: no space left on device")
+	address := model.NewAddress("10.0.0.1", 22)
+	c, _ := cf.Create(context.Background(), &model.Auth{}, &address)
+
+	results, err := c.Cmd("whoami").Cmd("sudo tar zxvf k3os.tar.gz -C /").Cmd("sudo sync").Exec(context.Background())
+	assert.EqualError(t, err, "command 'sudo tar zxvf k3os.tar.gz -C /' exited with status 2: tar: no space left on device")
+	assert.Equal(t, 2, ExitStatus(err))
+	assert.Len(t, results, 2)
+	assert.NoError(t, c.Cmd("sudo sync").Run(context.Background()))
+}
+
+func TestFakeScript_Disconnect(t *testing.T) {
+	f, err := ioutil.TempFile("", "k3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, _ = f.Write(make([]byte, 1000))
+	_ = f.Close()
+
+	cf, script := NewFakeClientFactory()
+	script.Disconnect("~/k3s", 400, 1)
+	script.Disconnect("/etc/rancher/k3s/k3s.yaml", 10, -1)
+	script.ExpectDownload("/etc/rancher/k3s/k3s.yaml", "apiVersion: v1\n")
+	address := model.NewAddress("10.0.0.1", 22)
+	c, _ := cf.Create(context.Background(), &model.Auth{}, &address)
+
+	var progress []Progress
+	record := func(p Progress) { progress = append(progress, p) }
+	assert.EqualError(t, c.Copy(context.Background(), f.Name(), "~/k3s", record), "failed to copy to ~/k3s: connection lost")
+	assert.NoError(t, c.Copy(context.Background(), f.Name(), "~/k3s", record))
+	assert.Equal(t, []Progress{
+		{RemotePath: "~/k3s", Done: 400, Size: 1000},
+		{RemotePath: "~/k3s", Done: 1000, Resumed: 400, Size: 1000},
+	}, progress)
+
+	var b bytes.Buffer
+	assert.EqualError(t, c.Download(context.Background(), "/etc/rancher/k3s/k3s.yaml", &b), "failed to copy from /etc/rancher/k3s/k3s.yaml: connection lost")
+	assert.Equal(t, "apiVersion", b.String())
+}
+
+func TestFakeScript_Latency(t *testing.T) {
+	cf, script := NewFakeClientFactory()
+	script.Latency = time.Second
+	address := model.NewAddress("10.0.0.1", 22)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := cf.Create(ctx, &model.Auth{}, &address)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
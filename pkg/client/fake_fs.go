@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FakeUser owner of files written without sudo to a FakeFS
+const FakeUser = "user"
+
+// FakeFS in-memory file system for fake clients. Writing outside ~/ and /tmp and reading files that
+// aren't readable by others requires sudo, as on a node.
+type FakeFS struct {
+	m     sync.Mutex
+	Files map[string]*FakeFile
+}
+
+// FakeFile a file, directory (Mode has os.ModeDir) or symbolic link (Link is set) in a FakeFS
+type FakeFile struct {
+	Data  []byte
+	Mode  os.FileMode
+	Owner string
+	Link  string
+}
+
+// NewFakeFS creates an empty fake file system
+func NewFakeFS() *FakeFS {
+	return &FakeFS{Files: make(map[string]*FakeFile)}
+}
+
+// AddFile adds a file readable by all, owned by root
+func (f *FakeFS) AddFile(name, content string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.Files[path.Clean(name)] = &FakeFile{Data: []byte(content), Mode: 0644, Owner: "root"}
+}
+
+// Paths returns all paths in the file system, sorted
+func (f *FakeFS) Paths() []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	var paths []string
+	for p := range f.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// resolve follows symbolic links, returns the path of the file the name refers to
+func (f *FakeFS) resolve(name string) string {
+	name = path.Clean(name)
+	for i := 0; i < 40; i++ {
+		resolved := f.resolveLink(name)
+		if resolved == name {
+			break
+		}
+		name = resolved
+	}
+	return name
+}
+
+// resolveLink replaces the first symbolic link in the path with its target
+func (f *FakeFS) resolveLink(name string) string {
+	parts := strings.Split(name, "/")
+	for i := 1; i <= len(parts); i++ {
+		prefix := strings.Join(parts[:i], "/")
+		file, ok := f.Files[prefix]
+		if !ok || len(file.Link) == 0 {
+			continue
+		}
+		target := file.Link
+		if !path.IsAbs(target) && !strings.HasPrefix(target, "~") {
+			target = path.Join(path.Dir(prefix), target)
+		}
+		return path.Join(append([]string{target}, parts[i:]...)...)
+	}
+	return name
+}
+
+// fakeFileSystem a FakeFS accessed by a fake client, as the user or as root with sudo
+type fakeFileSystem struct {
+	fs   *FakeFS
+	sudo bool
+}
+
+// writable returns an error if the path can't be written without sudo
+func (s *fakeFileSystem) writable(op, name string) error {
+	name = path.Clean(name)
+	if s.sudo || name == "~" || strings.HasPrefix(name, "~/") || strings.HasPrefix(name, "/tmp/") {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+}
+
+func (s *fakeFileSystem) owner(owner string) string {
+	if len(owner) > 0 {
+		return owner
+	}
+	if s.sudo {
+		return "root"
+	}
+	return FakeUser
+}
+
+func (s *fakeFileSystem) Sudo() FileSystem {
+	return &fakeFileSystem{fs: s.fs, sudo: true}
+}
+
+func (s *fakeFileSystem) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.fs.m.Lock()
+	defer s.fs.m.Unlock()
+
+	file, ok := s.fs.Files[s.fs.resolve(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	owner := strings.Split(file.Owner, ":")
+	group := owner[0]
+	if len(owner) > 1 {
+		group = owner[1]
+	}
+	return &FileInfo{name: path.Base(name), size: int64(len(file.Data)), mode: file.Mode, owner: owner[0], group: group}, nil
+}
+
+func (s *fakeFileSystem) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.fs.m.Lock()
+	defer s.fs.m.Unlock()
+
+	file, ok := s.fs.Files[s.fs.resolve(name)]
+	if !ok || file.Mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	}
+	if !s.sudo && file.Owner != FakeUser && file.Mode.Perm()&0004 == 0 {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrPermission}
+	}
+	return append([]byte(nil), file.Data...), nil
+}
+
+func (s *fakeFileSystem) WriteFile(ctx context.Context, name string, data []byte, perm os.FileMode, owner string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.writable("write", name); err != nil {
+		return err
+	}
+	s.fs.m.Lock()
+	defer s.fs.m.Unlock()
+
+	s.fs.Files[s.fs.resolve(name)] = &FakeFile{Data: append([]byte(nil), data...), Mode: perm.Perm(), Owner: s.owner(owner)}
+	return nil
+}
+
+func (s *fakeFileSystem) MkdirAll(ctx context.Context, name string, perm os.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.writable("mkdir", name); err != nil {
+		return err
+	}
+	s.fs.m.Lock()
+	defer s.fs.m.Unlock()
+
+	for dir := path.Clean(name); dir != "/" && dir != "." && dir != "~"; dir = path.Dir(dir) {
+		if file, ok := s.fs.Files[dir]; ok {
+			if !file.Mode.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: name, Err: fmt.Errorf("%s is not a directory", dir)}
+			}
+			continue
+		}
+		s.fs.Files[dir] = &FakeFile{Mode: os.ModeDir | perm.Perm(), Owner: s.owner("")}
+	}
+	return nil
+}
+
+func (s *fakeFileSystem) Symlink(ctx context.Context, target, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.writable("symlink", name); err != nil {
+		return err
+	}
+	s.fs.m.Lock()
+	defer s.fs.m.Unlock()
+
+	s.fs.Files[path.Clean(name)] = &FakeFile{Mode: os.ModeSymlink | 0777, Owner: s.owner(""), Link: target}
+	return nil
+}
+
+func (s *fakeFileSystem) Readlink(ctx context.Context, name string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s.fs.m.Lock()
+	defer s.fs.m.Unlock()
+
+	file, ok := s.fs.Files[path.Clean(name)]
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+	}
+	if len(file.Link) == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: fmt.Errorf("not a symbolic link")}
+	}
+	return file.Link, nil
+}
+
+func (s *fakeFileSystem) Remove(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.writable("remove", name); err != nil {
+		return err
+	}
+	s.fs.m.Lock()
+	defer s.fs.m.Unlock()
+
+	name = path.Clean(name)
+	for p := range s.fs.Files {
+		if p == name || strings.HasPrefix(p, name+"/") {
+			delete(s.fs.Files, p)
+		}
+	}
+	return nil
+}
+
+func (s *fakeFileSystem) Fetch(ctx context.Context, name, localFile string) error {
+	data, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(localFile, data, 0600)
+}
@@ -0,0 +1,289 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileSystem remote file system of a node. Paths may start with ~/ for the user's home directory.
+type FileSystem interface {
+	// Sudo returns the file system accessed as root with sudo
+	Sudo() FileSystem
+	// Stat returns info about a file, symbolic links are followed. A missing file is os.ErrNotExist.
+	Stat(ctx context.Context, name string) (*FileInfo, error)
+	ReadFile(ctx context.Context, name string) ([]byte, error)
+	// WriteFile replaces a file with data. Owner is "user[:group]", empty for the current user or
+	// root when using sudo.
+	WriteFile(ctx context.Context, name string, data []byte, perm os.FileMode, owner string) error
+	MkdirAll(ctx context.Context, name string, perm os.FileMode) error
+	// Symlink creates or replaces the symbolic link name pointing to target
+	Symlink(ctx context.Context, target, name string) error
+	Readlink(ctx context.Context, name string) (string, error)
+	// Remove removes a file, or a directory and everything in it. A missing file is not an error.
+	Remove(ctx context.Context, name string) error
+	// Fetch copies a remote file to a local file
+	Fetch(ctx context.Context, name, localFile string) error
+}
+
+// FileInfo info about a remote file
+type FileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	owner   string
+	group   string
+}
+
+// Name base name of the file
+func (fi *FileInfo) Name() string { return fi.name }
+
+// Size length in bytes
+func (fi *FileInfo) Size() int64 { return fi.size }
+
+// Mode file mode bits
+func (fi *FileInfo) Mode() os.FileMode { return fi.mode }
+
+// ModTime modification time
+func (fi *FileInfo) ModTime() time.Time { return fi.modTime }
+
+// IsDir returns true for a directory
+func (fi *FileInfo) IsDir() bool { return fi.mode.IsDir() }
+
+// Sys returns nil, there is no underlying data source
+func (fi *FileInfo) Sys() interface{} { return nil }
+
+// Owner user owning the file
+func (fi *FileInfo) Owner() string { return fi.owner }
+
+// Group group owning the file
+func (fi *FileInfo) Group() string { return fi.group }
+
+// remoteFS file system implemented with commands run by the client
+type remoteFS struct {
+	client Client
+	sudo   bool
+}
+
+// cmd prefixes a command with sudo when accessed as root
+func (fs *remoteFS) cmd(format string, a ...interface{}) string {
+	cmd := fmt.Sprintf(format, a...)
+	if fs.sudo {
+		return "sudo " + cmd
+	}
+	return cmd
+}
+
+func (fs *remoteFS) Sudo() FileSystem {
+	return &remoteFS{client: fs.client, sudo: true}
+}
+
+func (fs *remoteFS) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	results, err := fs.client.Cmd(fs.cmd("stat -L -c '%%s %%f %%Y %%U %%G' %s", quotePath(name))).Exec(ctx)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	fields := strings.Fields(string(results.Stdout()))
+	if len(fields) != 5 {
+		return nil, pathError("stat", name, fmt.Errorf("unexpected stat output: %q", results.Stdout()))
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	rawMode, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	mtime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return &FileInfo{
+		name:    path.Base(name),
+		size:    size,
+		mode:    fileMode(uint32(rawMode)),
+		modTime: time.Unix(mtime, 0),
+		owner:   fields[3],
+		group:   fields[4],
+	}, nil
+}
+
+func (fs *remoteFS) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	results, err := fs.client.Cmd(fs.cmd("cat %s", quotePath(name))).Exec(ctx)
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+	return results.Stdout(), nil
+}
+
+// WriteFile uploads data to a temporary file that is moved in place. The temporary file is only
+// readable by the user, the mode is set after the move.
+func (fs *remoteFS) WriteFile(ctx context.Context, name string, data []byte, perm os.FileMode, owner string) error {
+	tmp, err := tempPath()
+	if err != nil {
+		return err
+	}
+	if err = fs.client.CopyBytes(ctx, &data, tmp); err != nil {
+		return pathError("write", name, err)
+	}
+
+	if len(owner) == 0 && fs.sudo {
+		owner = "root:root"
+	}
+	mv := fs.cmd("mv -f %s %s", tmp, quotePath(name))
+	script := fs.client.Cmd(mv)
+	if len(owner) > 0 {
+		script = fs.client.Cmd(fs.cmd("chown %s %s", Quote(owner), tmp)).Cmd(mv)
+	}
+	if err = script.Run(ctx); err != nil {
+		_ = fs.client.Cmd(fs.cmd("rm -f %s", tmp)).Run(ctx)
+		return pathError("write", name, err)
+	}
+	return pathError("write", name, fs.client.Cmd(fs.cmd("chmod %04o %s", perm.Perm(), quotePath(name))).Run(ctx))
+}
+
+func (fs *remoteFS) MkdirAll(ctx context.Context, name string, perm os.FileMode) error {
+	return pathError("mkdir", name, fs.client.Cmd(fs.cmd("mkdir -p -m %04o %s", perm.Perm(), quotePath(name))).Run(ctx))
+}
+
+func (fs *remoteFS) Symlink(ctx context.Context, target, name string) error {
+	return pathError("symlink", name, fs.client.Cmd(fs.cmd("ln -sfn %s %s", quotePath(target), quotePath(name))).Run(ctx))
+}
+
+func (fs *remoteFS) Readlink(ctx context.Context, name string) (string, error) {
+	results, err := fs.client.Cmd(fs.cmd("readlink %s", quotePath(name))).Exec(ctx)
+	if err != nil {
+		return "", pathError("readlink", name, err)
+	}
+	return strings.TrimSuffix(string(results.Stdout()), "\n"), nil
+}
+
+func (fs *remoteFS) Remove(ctx context.Context, name string) error {
+	return pathError("remove", name, fs.client.Cmd(fs.cmd("rm -rf %s", quotePath(name))).Run(ctx))
+}
+
+// Fetch downloads the file, as root it's read with sudo
+func (fs *remoteFS) Fetch(ctx context.Context, name, localFile string) error {
+	f, err := os.OpenFile(localFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if fs.sudo {
+		var data []byte
+		if data, err = fs.ReadFile(ctx, name); err == nil {
+			_, err = f.Write(data)
+		}
+	} else if err = fs.client.Download(ctx, name, f); err != nil {
+		err = pathError("fetch", name, err)
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(localFile)
+	}
+	return err
+}
+
+// pathError wraps a failed command as a path error, a missing file is os.ErrNotExist and a denied
+// access os.ErrPermission
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		stderr := string(cmdErr.Result.Stderr)
+		switch {
+		case strings.Contains(stderr, "No such file or directory"):
+			err = os.ErrNotExist
+		case strings.Contains(stderr, "Permission denied"), strings.Contains(stderr, "Operation not permitted"):
+			err = os.ErrPermission
+		}
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// fileMode converts unix mode bits, as printed by stat %f, to a file mode
+func fileMode(raw uint32) os.FileMode {
+	mode := os.FileMode(raw & 0777)
+	switch raw & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0010000:
+		mode |= os.ModeNamedPipe
+	case 0140000:
+		mode |= os.ModeSocket
+	case 0020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		mode |= os.ModeDevice
+	}
+	if raw&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if raw&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if raw&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// quotePath quotes a path for the remote shell, a leading ~/ is kept unquoted to expand to the home directory
+func quotePath(name string) string {
+	if strings.HasPrefix(name, "~/") {
+		return "~/" + Quote(name[2:])
+	}
+	return Quote(name)
+}
+
+// Quote single quotes s as one word for the remote shell
+func Quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// tempPath a random path in /tmp for uploading a file before it's moved in place
+func tempPath() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "/tmp/.k3pi-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestRemoteFS_Stat(t *testing.T) {
+	cf, script := NewFakeClientFactory(func(script *FakeScript) {
+		script.Expect("sudo stat -L -c '%s %f %Y %U %G' '/k3os/system/config.yaml'", "812 8180 1580000000 root root")
+	})
+	c, _ := cf.Create(context.Background(), &model.Auth{}, &model.Address{})
+	fs := &remoteFS{client: c}
+
+	fi, err := fs.Sudo().Stat(context.Background(), "/k3os/system/config.yaml")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "config.yaml", fi.Name())
+	assert.Equal(t, int64(812), fi.Size())
+	assert.Equal(t, os.FileMode(0600), fi.Mode())
+	assert.Equal(t, "root", fi.Owner())
+	assert.False(t, script.HasOutstandingCmds())
+}
+
+func TestPathError(t *testing.T) {
+	missing := &CommandError{Result: &Result{Cmd: "cat /etc/k3s", ExitStatus: 1, Stderr: []byte("cat: can't open '/etc/k3s': No such file or directory\n")}}
+	denied := &CommandError{Result: &Result{Cmd: "cat /etc/shadow", ExitStatus: 1, Stderr: []byte("cat: /etc/shadow: Permission denied\n")}}
+
+	assert.True(t, os.IsNotExist(pathError("read", "/etc/k3s", missing)))
+	assert.True(t, os.IsPermission(pathError("read", "/etc/shadow", denied)))
+	assert.Nil(t, pathError("read", "/etc/hostname", nil))
+}
+
+func TestFileMode(t *testing.T) {
+	assert.Equal(t, os.ModeDir|0755, fileMode(0x41ed))
+	assert.Equal(t, os.FileMode(0644), fileMode(0x81a4))
+	assert.Equal(t, os.ModeSymlink|0777, fileMode(0xa1ff))
+}
+
+func TestQuotePath(t *testing.T) {
+	assert.Equal(t, "~/'k3os rootfs.tar.gz'", quotePath("~/k3os rootfs.tar.gz"))
+	assert.Equal(t, `'/tmp/it'\''s'`, quotePath("/tmp/it's"))
+}
+
+func TestFakeFS(t *testing.T) {
+	ctx := context.Background()
+	fs := &fakeFileSystem{fs: NewFakeFS()}
+
+	assert.True(t, os.IsPermission(fs.MkdirAll(ctx, "/k3os/system/k3s/v1.17.2+k3s1", 0755)))
+	assert.NoError(t, fs.Sudo().MkdirAll(ctx, "/k3os/system/k3s/v1.17.2+k3s1", 0755))
+	assert.NoError(t, fs.Sudo().WriteFile(ctx, "/k3os/system/k3s/v1.17.2+k3s1/k3s", []byte("k3s"), 0755, ""))
+	assert.NoError(t, fs.Sudo().Symlink(ctx, "/k3os/system/k3s/v1.17.2+k3s1", "/k3os/system/k3s/current"))
+
+	target, err := fs.Readlink(ctx, "/k3os/system/k3s/current")
+	assert.NoError(t, err)
+	assert.Equal(t, "/k3os/system/k3s/v1.17.2+k3s1", target)
+
+	fi, err := fs.Stat(ctx, "/k3os/system/k3s/current/k3s")
+	assert.NoError(t, err)
+	assert.Equal(t, "root", fi.Owner())
+
+	assert.NoError(t, fs.WriteFile(ctx, "~/token", []byte("secret"), 0600, ""))
+	b, err := fs.ReadFile(ctx, "~/token")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(b))
+
+	assert.NoError(t, fs.Sudo().Remove(ctx, "/k3os/system/k3s"))
+	_, err = fs.Stat(ctx, "/k3os/system/k3s/v1.17.2+k3s1/k3s")
+	assert.True(t, os.IsNotExist(err))
+}
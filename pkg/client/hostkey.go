@@ -0,0 +1,248 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package client client supporting installing and upgrading remote k3OS nodes
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// HostKeyCheckingStrict only known host keys are accepted
+	HostKeyCheckingStrict = "yes"
+	// HostKeyCheckingAcceptNew unknown host keys are trusted on first use and recorded, changed keys are refused
+	HostKeyCheckingAcceptNew = "accept-new"
+	// HostKeyCheckingOff host keys are not verified
+	HostKeyCheckingOff = "no"
+	// DefaultKnownHostsFile known hosts file managed by k3pi, new host keys are recorded here
+	DefaultKnownHostsFile = "~/.k3pi/known_hosts"
+	// UserKnownHostsFile the users OpenSSH known hosts file, only read
+	UserKnownHostsFile = "~/.ssh/known_hosts"
+)
+
+// HostKeyCheckings all host key checking modes
+var HostKeyCheckings = []string{HostKeyCheckingStrict, HostKeyCheckingAcceptNew, HostKeyCheckingOff}
+
+// DefaultHostKeyVerifier verifier used by clients created with NewClient
+var DefaultHostKeyVerifier = NewHostKeyVerifier(HostKeyCheckingAcceptNew, DefaultKnownHostsFile, UserKnownHostsFile)
+
+// HostKeyVerifier verifies host keys against keys pinned in the inventory and known hosts files
+type HostKeyVerifier struct {
+	// Checking one of HostKeyCheckings
+	Checking string
+	// KnownHostsFile known hosts file where new and changed keys are recorded
+	KnownHostsFile string
+	// UserKnownHostsFiles additional known hosts files that are only read
+	UserKnownHostsFiles []string
+
+	m        sync.Mutex
+	expected map[string]bool
+}
+
+// NewHostKeyVerifier creates a new host key verifier
+func NewHostKeyVerifier(checking, knownHostsFile string, userKnownHostsFiles ...string) *HostKeyVerifier {
+	return &HostKeyVerifier{
+		Checking:            checking,
+		KnownHostsFile:      knownHostsFile,
+		UserKnownHostsFiles: userKnownHostsFiles,
+		expected:            make(map[string]bool),
+	}
+}
+
+// ExpectChange accepts and records the next changed host key for an IP, e.g. after a reinstall
+func (v *HostKeyVerifier) ExpectChange(ip string) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	v.expected[ip] = true
+}
+
+// Callback creates a host key callback for an address, presented is called with the verified key
+func (v *HostKeyVerifier) Callback(address *model.Address, presented func(key string)) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := v.verify(address, hostname, remote, key)
+		if err == nil && presented != nil {
+			presented(MarshalHostKey(key))
+		}
+		return err
+	}
+}
+
+func (v *HostKeyVerifier) verify(address *model.Address, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if v.Checking == HostKeyCheckingOff {
+		return nil
+	}
+
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	// a key pinned in the inventory takes precedence over known hosts files
+	if len(address.HostKey) > 0 {
+		if address.HostKey == MarshalHostKey(key) {
+			return nil
+		}
+		if !v.expected[address.IP] {
+			return fmt.Errorf("host key for %s does not match the key pinned in the inventory, if the node was reinstalled use --host-key-changed %s", address, address.IP)
+		}
+		return v.replace(address, key)
+	}
+
+	files, err := v.existingFiles()
+	if err != nil {
+		return err
+	}
+
+	if len(files) > 0 {
+		callback, err := knownhosts.New(files...)
+		if err != nil {
+			return err
+		}
+		err = callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			if !v.expected[address.IP] {
+				return fmt.Errorf("host key for %s has changed, if the node was reinstalled use --host-key-changed %s", address, address.IP)
+			}
+			return v.replace(address, key)
+		}
+	}
+
+	if v.Checking == HostKeyCheckingStrict && !v.expected[address.IP] {
+		return fmt.Errorf("host key for %s is unknown and host key checking is strict", address)
+	}
+	delete(v.expected, address.IP)
+	return v.record(address, key)
+}
+
+// existingFiles known hosts files that exist, the managed file first so that recorded keys take precedence
+func (v *HostKeyVerifier) existingFiles() ([]string, error) {
+	var files []string
+	for _, fn := range append([]string{v.KnownHostsFile}, v.UserKnownHostsFiles...) {
+		if len(fn) == 0 {
+			continue
+		}
+		path, err := homedir.Expand(fn)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// record appends the host key to the known hosts file
+func (v *HostKeyVerifier) record(address *model.Address, key ssh.PublicKey) error {
+	if len(v.KnownHostsFile) == 0 {
+		return nil
+	}
+	path, err := homedir.Expand(v.KnownHostsFile)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(address.String())}, key)); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: Permanently added '%s' (%s) to the list of known hosts.\n", address, key.Type())
+	return nil
+}
+
+// Forget removes all keys for the address from the known hosts file, e.g. when a node is reinstalled
+// and its new key can't be recorded before the program exits
+func (v *HostKeyVerifier) Forget(address *model.Address) error {
+	v.m.Lock()
+	defer v.m.Unlock()
+	return v.forget(address)
+}
+
+func (v *HostKeyVerifier) forget(address *model.Address) error {
+	if len(v.KnownHostsFile) == 0 {
+		return nil
+	}
+	path, err := homedir.Expand(v.KnownHostsFile)
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	host := knownhosts.Normalize(address.String())
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(string(b)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == host {
+			continue
+		}
+		lines = append(lines, line+"\n")
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "")), 0600)
+}
+
+// replace removes all keys for the address from the known hosts file and records the new key
+func (v *HostKeyVerifier) replace(address *model.Address, key ssh.PublicKey) error {
+	delete(v.expected, address.IP)
+
+	if err := v.forget(address); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: host key for %s has changed as expected.\n", address)
+	return v.record(address, key)
+}
+
+// MarshalHostKey formats a host key as in known hosts and authorized keys files, "<type> <base64 key>"
+func MarshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
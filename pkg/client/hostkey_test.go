@@ -0,0 +1,169 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func hostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func verifier(t *testing.T, checking string) (*HostKeyVerifier, string, func()) {
+	dir, err := ioutil.TempDir("", "k3pi-known-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, "known_hosts")
+	return NewHostKeyVerifier(checking, fn), fn, func() { os.RemoveAll(dir) }
+}
+
+func verify(v *HostKeyVerifier, address *model.Address, key ssh.PublicKey) error {
+	remote := &net.TCPAddr{IP: net.ParseIP(address.IP), Port: address.Port}
+	return v.Callback(address, nil)(address.String(), remote, key)
+}
+
+func TestHostKeyVerifier_AcceptNew(t *testing.T) {
+	v, fn, cleanup := verifier(t, HostKeyCheckingAcceptNew)
+	defer cleanup()
+
+	address := model.NewAddress("10.0.0.1", 22)
+	key := hostKey(t)
+
+	if err := verify(v, &address, key); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(fn)
+	if string(b) != "10.0.0.1 "+MarshalHostKey(key)+"\n" {
+		t.Errorf("key not recorded: %s", b)
+	}
+
+	if err := verify(v, &address, key); err != nil {
+		t.Error(err)
+	}
+
+	changed := hostKey(t)
+	if err := verify(v, &address, changed); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Errorf("expected changed host key error, got: %v", err)
+	}
+
+	v.ExpectChange(address.IP)
+	if err := verify(v, &address, changed); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadFile(fn)
+	if string(b) != "10.0.0.1 "+MarshalHostKey(changed)+"\n" {
+		t.Errorf("key not replaced: %s", b)
+	}
+
+	if err := verify(v, &address, key); err == nil {
+		t.Error("expected change should only be accepted once")
+	}
+}
+
+func TestHostKeyVerifier_Strict(t *testing.T) {
+	v, _, cleanup := verifier(t, HostKeyCheckingStrict)
+	defer cleanup()
+
+	address := model.NewAddress("10.0.0.1", 2222)
+	if err := verify(v, &address, hostKey(t)); err == nil {
+		t.Error("unknown host key accepted")
+	}
+}
+
+func TestHostKeyVerifier_Forget(t *testing.T) {
+	v, fn, cleanup := verifier(t, HostKeyCheckingStrict)
+	defer cleanup()
+
+	address := model.NewAddress("10.0.0.1", 22)
+	other := model.NewAddress("10.0.0.2", 22)
+	key := hostKey(t)
+	_ = ioutil.WriteFile(fn, []byte("10.0.0.1 "+MarshalHostKey(key)+"\n10.0.0.2 "+MarshalHostKey(key)+"\n"), 0600)
+
+	if err := v.Forget(&address); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(fn)
+	if string(b) != "10.0.0.2 "+MarshalHostKey(key)+"\n" {
+		t.Errorf("key not forgotten: %s", b)
+	}
+	if err := verify(v, &other, key); err != nil {
+		t.Error(err)
+	}
+
+	// a reinstalled node is accepted once with strict checking when the change is expected
+	v.ExpectChange(address.IP)
+	if err := verify(v, &address, hostKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(v, &address, hostKey(t)); err == nil {
+		t.Error("expected change should only be accepted once")
+	}
+}
+
+func TestHostKeyVerifier_Pinned(t *testing.T) {
+	v, _, cleanup := verifier(t, HostKeyCheckingStrict)
+	defer cleanup()
+
+	key := hostKey(t)
+	address := model.NewAddress("10.0.0.1", 22)
+	address.HostKey = MarshalHostKey(key)
+
+	if err := verify(v, &address, key); err != nil {
+		t.Error(err)
+	}
+	if err := verify(v, &address, hostKey(t)); err == nil || !strings.Contains(err.Error(), "pinned") {
+		t.Errorf("expected pinned host key error, got: %v", err)
+	}
+}
+
+func TestHostKeyVerifier_Off(t *testing.T) {
+	v, fn, cleanup := verifier(t, HostKeyCheckingOff)
+	defer cleanup()
+
+	address := model.NewAddress("10.0.0.1", 22)
+	address.HostKey = "ssh-ed25519 AAAA"
+	if err := verify(v, &address, hostKey(t)); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Error("no keys should be recorded")
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package client client supporting installing and upgrading remote k3OS nodes
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+)
+
+const (
+	// PassphraseEnv environment variable with the passphrase for encrypted ssh keys
+	PassphraseEnv = "K3PI_SSH_PASSPHRASE"
+	// AgentSocketEnv environment variable with the ssh-agent socket
+	AgentSocketEnv = "SSH_AUTH_SOCK"
+	// CertSuffix suffix of a certificate file next to its private key, as used by OpenSSH
+	CertSuffix = "-cert.pub"
+)
+
+// DefaultSSHKeys key files tried when no key is given
+var DefaultSSHKeys = []string{"~/.ssh/id_rsa", "~/.ssh/id_ecdsa", "~/.ssh/id_ed25519"}
+
+// PassphrasePrompt reads the passphrase for an encrypted key, prompts on the terminal by default
+var PassphrasePrompt = promptPassphrase
+
+var (
+	agentOnce   sync.Once
+	agentClient agent.ExtendedAgent
+
+	passphraseMutex sync.Mutex
+	passphrases     = make(map[string][]byte)
+)
+
+// sshAgent connects to the ssh-agent once, returns nil if no agent is running
+func sshAgent() agent.ExtendedAgent {
+	agentOnce.Do(func() {
+		socket := os.Getenv(AgentSocketEnv)
+		if len(socket) == 0 {
+			return
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return
+		}
+		agentClient = agent.NewClient(conn)
+	})
+	return agentClient
+}
+
+// sshKeys all key files of an auth, tried in order
+func sshKeys(auth *model.Auth) []string {
+	var keys []string
+	if len(auth.SSHKey) > 0 {
+		keys = append(keys, auth.SSHKey)
+	}
+	return append(keys, auth.SSHKeys...)
+}
+
+// publicKeysAuth authenticates with keys held by the ssh-agent followed by the key files
+func publicKeysAuth(auth *model.Auth) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		var agentSigners []ssh.Signer
+		if a := sshAgent(); a != nil {
+			if s, err := a.Signers(); err == nil {
+				agentSigners = s
+			}
+		}
+		return keySigners(auth, agentSigners)
+	})
+}
+
+// keySigners the agent signers followed by the signers of the key files and their certificates. A
+// certificate of an encrypted key held by the agent is paired with the agent signer.
+func keySigners(auth *model.Auth, agentSigners []ssh.Signer) ([]ssh.Signer, error) {
+	signers := append([]ssh.Signer(nil), agentSigners...)
+
+	var lastErr error
+	for i, keyFile := range sshKeys(auth) {
+		signer, held, err := loadKey(keyFile, agentSigners)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if signer == nil {
+			continue
+		}
+
+		certFile := ""
+		if i == 0 {
+			certFile = auth.SSHCert
+		}
+		certSigner, err := loadCert(keyFile, certFile, signer)
+		if err != nil {
+			lastErr = err
+		} else if certSigner != nil {
+			signers = append(signers, certSigner)
+		}
+		if !held {
+			signers = append(signers, signer)
+		}
+	}
+
+	if len(signers) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("no ssh keys found, tried ssh-agent and %v", sshKeys(auth))
+	}
+	return signers, nil
+}
+
+// loadKey parses a private key file, asks for the passphrase of encrypted keys not already held by the agent.
+// Returns nil if the key file doesn't exist, and the agent signer with held set for an encrypted key held
+// by the agent.
+func loadKey(keyFile string, agentSigners []ssh.Signer) (signer ssh.Signer, held bool, err error) {
+	path, err := homedir.Expand(keyFile)
+	if err != nil {
+		return nil, false, err
+	}
+	pemBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	signer, err = ssh.ParsePrivateKey(pemBytes)
+	if err == nil {
+		return signer, false, nil
+	}
+	missing, ok := err.(*ssh.PassphraseMissingError)
+	if !ok {
+		return nil, false, fmt.Errorf("failed to parse ssh key %s: %v", keyFile, err)
+	}
+	if missing.PublicKey != nil {
+		for _, s := range agentSigners {
+			if bytes.Equal(s.PublicKey().Marshal(), missing.PublicKey.Marshal()) {
+				return s, true, nil
+			}
+		}
+	}
+
+	passphraseMutex.Lock()
+	defer passphraseMutex.Unlock()
+
+	passphrase, ok := passphrases[path]
+	if !ok {
+		if env, set := os.LookupEnv(PassphraseEnv); set {
+			passphrase = []byte(env)
+		} else if passphrase, err = PassphrasePrompt(keyFile); err != nil {
+			return nil, false, err
+		}
+	}
+
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, passphrase)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt ssh key %s: %v", keyFile, err)
+	}
+	passphrases[path] = passphrase
+	return signer, false, nil
+}
+
+// loadCert creates a signer for the certificate of a key, certFile defaults to "<key file>-cert.pub".
+// Returns nil if there is no certificate.
+func loadCert(keyFile, certFile string, signer ssh.Signer) (ssh.Signer, error) {
+	explicit := len(certFile) > 0
+	if !explicit {
+		certFile = keyFile + CertSuffix
+	}
+	path, err := homedir.Expand(certFile)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh certificate %s: %v", certFile, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ssh certificate", certFile)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("ssh certificate %s doesn't match key %s: %v", certFile, keyFile, err)
+	}
+	return certSigner, nil
+}
+
+// promptPassphrase prompts for a passphrase on the controlling terminal, also when stdin is piped
+func promptPassphrase(keyFile string) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("ssh key %s is encrypted, set %s or add it to the ssh-agent", keyFile, PassphraseEnv)
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "Enter passphrase for key '%s': ", keyFile)
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return passphrase, err
+}
@@ -0,0 +1,208 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeKey(t *testing.T, dir, name string, key crypto.PrivateKey, passphrase string) string {
+	var block *pem.Block
+	var err error
+	if len(passphrase) > 0 {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(fn, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func keyDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "k3pi-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadKey(t *testing.T) {
+	dir, cleanup := keyDir(t)
+	defer cleanup()
+
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	signer, _, err := loadKey(writeKey(t, dir, "id_ed25519", ed25519Key, ""), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ssh.KeyAlgoED25519, signer.PublicKey().Type())
+
+	signer, _, err = loadKey(writeKey(t, dir, "id_ecdsa", ecdsaKey, ""), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ssh.KeyAlgoECDSA256, signer.PublicKey().Type())
+
+	signer, _, err = loadKey(filepath.Join(dir, "id_rsa"), nil)
+	assert.NoError(t, err)
+	assert.Nil(t, signer, "missing key files are skipped")
+}
+
+func TestLoadKey_Encrypted(t *testing.T) {
+	dir, cleanup := keyDir(t)
+	defer cleanup()
+
+	prompts := 0
+	PassphrasePrompt = func(keyFile string) ([]byte, error) {
+		prompts++
+		return []byte("secret"), nil
+	}
+	defer func() { PassphrasePrompt = promptPassphrase }()
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	fn := writeKey(t, dir, "id_ed25519", key, "secret")
+
+	for i := 0; i < 2; i++ {
+		signer, _, err := loadKey(fn, nil)
+		assert.NoError(t, err)
+		assert.NotNil(t, signer)
+	}
+	assert.Equal(t, 1, prompts, "passphrase should only be asked for once")
+
+	PassphrasePrompt = func(keyFile string) ([]byte, error) {
+		return nil, fmt.Errorf("no terminal")
+	}
+	os.Setenv(PassphraseEnv, "secret")
+	defer os.Unsetenv(PassphraseEnv)
+
+	signer, _, err := loadKey(writeKey(t, dir, "id_other", key, "secret"), nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, signer)
+
+	_, _, err = loadKey(writeKey(t, dir, "id_wrong", key, "wrong"), nil)
+	assert.Error(t, err)
+}
+
+func TestLoadKey_HeldByAgent(t *testing.T) {
+	dir, cleanup := keyDir(t)
+	defer cleanup()
+
+	PassphrasePrompt = func(keyFile string) ([]byte, error) {
+		t.Error("should not ask for passphrase of a key held by the agent")
+		return nil, fmt.Errorf("no terminal")
+	}
+	defer func() { PassphrasePrompt = promptPassphrase }()
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	keyring := agent.NewKeyring()
+	assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: key}))
+	agentSigners, _ := keyring.Signers()
+
+	signer, held, err := loadKey(writeKey(t, dir, "id_ed25519", key, "secret"), agentSigners)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	assert.Equal(t, agentSigners[0].PublicKey(), signer.PublicKey())
+}
+
+func TestKeySigners_CertOfKeyHeldByAgent(t *testing.T) {
+	dir, cleanup := keyDir(t)
+	defer cleanup()
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	caSigner, _ := ssh.NewSignerFromKey(caKey)
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	keyring := agent.NewKeyring()
+	assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: key}))
+	agentSigners, _ := keyring.Signers()
+
+	fn := writeKey(t, dir, "id_ed25519", key, "secret")
+	cert := &ssh.Certificate{
+		Key:             agentSigners[0].PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"rancher"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	assert.NoError(t, cert.SignCert(rand.Reader, caSigner))
+	assert.NoError(t, ioutil.WriteFile(fn+CertSuffix, ssh.MarshalAuthorizedKey(cert), 0644))
+
+	signers, err := keySigners(&model.Auth{Type: model.AuthTypeSSHKey, SSHKey: fn}, agentSigners)
+	assert.NoError(t, err)
+	if assert.Len(t, signers, 2, "the agent key and its certificate") {
+		assert.Equal(t, ssh.CertAlgoED25519v01, signers[1].PublicKey().Type())
+	}
+}
+
+func TestLoadCert(t *testing.T) {
+	dir, cleanup := keyDir(t)
+	defer cleanup()
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	caSigner, _ := ssh.NewSignerFromKey(caKey)
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	fn := writeKey(t, dir, "id_ed25519", key, "")
+	signer, _, err := loadKey(fn, nil)
+	assert.NoError(t, err)
+
+	certSigner, err := loadCert(fn, "", signer)
+	assert.NoError(t, err)
+	assert.Nil(t, certSigner, "keys without a certificate are used as is")
+
+	_, err = loadCert(fn, filepath.Join(dir, "missing-cert.pub"), signer)
+	assert.Error(t, err, "an explicit certificate must exist")
+
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "k3pi",
+		ValidPrincipals: []string{"rancher"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	assert.NoError(t, cert.SignCert(rand.Reader, caSigner))
+	assert.NoError(t, ioutil.WriteFile(fn+CertSuffix, ssh.MarshalAuthorizedKey(cert), 0644))
+
+	certSigner, err = loadCert(fn, "", signer)
+	assert.NoError(t, err)
+	assert.Equal(t, ssh.CertAlgoED25519v01, certSigner.PublicKey().Type())
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherKey)
+	_, err = loadCert(fn, "", otherSigner)
+	assert.Error(t, err, "certificate for another key")
+}
@@ -0,0 +1,261 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package client client supporting installing and upgrading remote k3OS nodes
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PoolIdleCheck time a pooled connection can be idle before it's checked with a keepalive when used
+var PoolIdleCheck = 10 * time.Second
+
+// NewPooledClientFactory creates a factory that keeps one connection per node and auth. Clients
+// share the connection, closing a client keeps it open until the factory is closed. Lost
+// connections, e.g. after a reboot, are reconnected on next use, connections idle for PoolIdleCheck
+// are checked with a keepalive first. Sessions are recorded if DefaultRecorder is set.
+func NewPooledClientFactory() *Factory {
+	return recordingFactory(newPooledFactory(NewClient))
+}
+
+func newPooledFactory(create func(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error)) *Factory {
+	p := &pool{create: create, conns: make(map[string]*pooledConn)}
+	return &Factory{Create: p.client, close: p.close}
+}
+
+type pool struct {
+	create func(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error)
+	m      sync.Mutex
+	conns  map[string]*pooledConn
+}
+
+// pooledConn a connection, connecting is serialized per node and auth
+type pooledConn struct {
+	m      sync.Mutex
+	client Client
+	used   time.Time
+}
+
+// lost returns true if the connection has been closed, or if it has been idle and doesn't answer a keepalive
+func (pc *pooledConn) lost() bool {
+	if c, ok := pc.client.(interface{ disconnected() bool }); ok && c.disconnected() {
+		return true
+	}
+	if time.Since(pc.used) < PoolIdleCheck {
+		return false
+	}
+	c, ok := pc.client.(interface{ alive() bool })
+	return ok && !c.alive()
+}
+
+// poolKey identifies a connection, passwords are hashed so that auths with other passwords get their own
+func poolKey(auth *model.Auth, address *model.Address) string {
+	secrets := sha256.Sum256([]byte(auth.Password + "\x00" + auth.SudoPassword))
+	return fmt.Sprintf("%s|%s|%s|%x|%s|%s|%s", address, auth.Type, auth.User, secrets, strings.Join(sshKeys(auth), ","), auth.SSHCert, auth.ProxyJump)
+}
+
+func (p *pool) client(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error) {
+	c := &pooledClient{pool: p, auth: auth, address: address}
+	if _, err := c.conn(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// conn returns the pooled connection, reconnects if there is none or it has been lost
+func (p *pool) conn(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error) {
+	key := poolKey(auth, address)
+
+	p.m.Lock()
+	pc, ok := p.conns[key]
+	if !ok {
+		pc = &pooledConn{}
+		p.conns[key] = pc
+	}
+	p.m.Unlock()
+
+	pc.m.Lock()
+	defer pc.m.Unlock()
+
+	if pc.client != nil {
+		if !pc.lost() {
+			pc.used = time.Now()
+			return pc.client, nil
+		}
+		_ = pc.client.Close()
+		pc.client = nil
+	}
+
+	c, err := p.create(ctx, auth, address)
+	if err != nil {
+		return nil, err
+	}
+	pc.client = c
+	pc.used = time.Now()
+	return c, nil
+}
+
+func (p *pool) close() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var err error
+	for key, pc := range p.conns {
+		pc.m.Lock()
+		if pc.client != nil {
+			if closeErr := pc.client.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		pc.m.Unlock()
+		delete(p.conns, key)
+	}
+	return err
+}
+
+// pooledClient client using the pooled connection for a node and auth
+type pooledClient struct {
+	pool    *pool
+	auth    *model.Auth
+	address *model.Address
+}
+
+func (c *pooledClient) conn(ctx context.Context) (Client, error) {
+	return c.pool.conn(ctx, c.auth, c.address)
+}
+
+// Cmd returns a script that gets the connection when it's run
+func (c *pooledClient) Cmd(cmd string) Script {
+	return &pooledScript{client: c, cmds: []string{cmd}}
+}
+
+func (c *pooledClient) Cmdf(cmd string, a ...interface{}) Script {
+	return c.Cmd(fmt.Sprintf(cmd, a...))
+}
+
+func (c *pooledClient) Copy(ctx context.Context, filename, remotePath string, progress ProgressFunc) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Copy(ctx, filename, remotePath, progress)
+}
+
+func (c *pooledClient) CopyBytes(ctx context.Context, b *[]byte, remotePath string) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.CopyBytes(ctx, b, remotePath)
+}
+
+func (c *pooledClient) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Download(ctx, remotePath, w)
+}
+
+func (c *pooledClient) FS() FileSystem {
+	return &remoteFS{client: c}
+}
+
+func (c *pooledClient) HostKey() string {
+	conn, err := c.conn(context.Background())
+	if err != nil {
+		return ""
+	}
+	return conn.HostKey()
+}
+
+// Close releases the client, the connection is closed with the factory
+func (c *pooledClient) Close() error {
+	return nil
+}
+
+// pooledScript script run on the pooled connection, reconnects if the connection has been lost
+type pooledScript struct {
+	client *pooledClient
+	cmds   []string
+}
+
+func (s *pooledScript) Cmd(cmd string) Script {
+	s.cmds = append(s.cmds, cmd)
+	return s
+}
+
+func (s *pooledScript) Cmdf(cmd string, a ...interface{}) Script {
+	return s.Cmd(fmt.Sprintf(cmd, a...))
+}
+
+func (s *pooledScript) script(ctx context.Context) (Script, error) {
+	conn, err := s.client.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	script := conn.Cmd(s.cmds[0])
+	for _, cmd := range s.cmds[1:] {
+		script = script.Cmd(cmd)
+	}
+	return script, nil
+}
+
+func (s *pooledScript) Run(ctx context.Context) error {
+	script, err := s.script(ctx)
+	if err != nil {
+		return err
+	}
+	return script.Run(ctx)
+}
+
+func (s *pooledScript) Output(ctx context.Context) ([]byte, error) {
+	script, err := s.script(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return script.Output(ctx)
+}
+
+func (s *pooledScript) Stream(ctx context.Context, stdout, stderr io.Writer) error {
+	script, err := s.script(ctx)
+	if err != nil {
+		return err
+	}
+	return script.Stream(ctx, stdout, stderr)
+}
+
+func (s *pooledScript) Exec(ctx context.Context) (Results, error) {
+	script, err := s.script(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return script.Exec(ctx)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"testing"
+	"time"
+)
+
+// poolTestClient fake client that can lose its connection
+type poolTestClient struct {
+	FakeClient
+	lost    bool
+	closed  bool
+	checked int
+}
+
+func (c *poolTestClient) disconnected() bool {
+	return c.lost
+}
+
+func (c *poolTestClient) alive() bool {
+	c.checked++
+	return !c.lost
+}
+
+func (c *poolTestClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+type poolTestDialer struct {
+	clients []*poolTestClient
+	err     error
+}
+
+func (d *poolTestDialer) create(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	c := &poolTestClient{FakeClient: FakeClient{Auth: auth, Address: address, FakeScript: &FakeScript{Interactions: make(map[string][]string)}}}
+	d.clients = append(d.clients, c)
+	return c, nil
+}
+
+func TestPooledFactory_ReusesConnection(t *testing.T) {
+	dialer := &poolTestDialer{}
+	factory := newPooledFactory(dialer.create)
+
+	address1 := model.NewAddress("10.0.0.1", 22)
+	address2 := model.NewAddress("10.0.0.2", 22)
+	for i := 0; i < 3; i++ {
+		c, err := factory.Create(context.Background(), auth, &address1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = c.Cmd("whoami").Run(context.Background())
+		_ = c.Close()
+	}
+	if len(dialer.clients) != 1 {
+		t.Errorf("expected 1 connection, got %d", len(dialer.clients))
+	}
+	if dialer.clients[0].closed {
+		t.Error("closing a pooled client should not close the connection")
+	}
+
+	if _, err := factory.Create(context.Background(), auth, &address2); err != nil {
+		t.Fatal(err)
+	}
+	rancher := &model.Auth{Type: model.AuthTypeBasicAuth, User: "rancher", Password: "rancher"}
+	if _, err := factory.Create(context.Background(), rancher, &address2); err != nil {
+		t.Fatal(err)
+	}
+	other := &model.Auth{Type: model.AuthTypeBasicAuth, User: "rancher", Password: "other"}
+	if _, err := factory.Create(context.Background(), other, &address2); err != nil {
+		t.Fatal(err)
+	}
+	if len(dialer.clients) != 4 {
+		t.Errorf("expected one connection per node and auth, got %d", len(dialer.clients))
+	}
+
+	if err := factory.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range dialer.clients {
+		if !c.closed {
+			t.Errorf("connection to %s not closed", c.Address)
+		}
+	}
+}
+
+func TestPooledFactory_Reconnect(t *testing.T) {
+	dialer := &poolTestDialer{}
+	factory := newPooledFactory(dialer.create)
+	defer factory.Close()
+
+	address := model.NewAddress("10.0.0.1", 22)
+	c, err := factory.Create(context.Background(), auth, &address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the node reboots
+	dialer.clients[0].lost = true
+	dialer.err = fmt.Errorf("connection refused")
+	if err = c.Cmd("whoami").Run(context.Background()); err == nil {
+		t.Error("expected connection error while node is down")
+	}
+
+	dialer.err = nil
+	if err = c.Cmd("whoami").Run(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if len(dialer.clients) != 2 || !dialer.clients[0].closed {
+		t.Error("lost connection should be closed and replaced")
+	}
+}
+
+func TestPooledFactory_IdleCheck(t *testing.T) {
+	idleCheck := PoolIdleCheck
+	defer func() { PoolIdleCheck = idleCheck }()
+	PoolIdleCheck = time.Hour
+
+	dialer := &poolTestDialer{}
+	factory := newPooledFactory(dialer.create)
+	defer factory.Close()
+
+	address := model.NewAddress("10.0.0.1", 22)
+	c, err := factory.Create(context.Background(), auth, &address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_ = c.Cmd("whoami").Run(context.Background())
+	}
+	if dialer.clients[0].checked != 0 {
+		t.Errorf("connection in use checked %d times", dialer.clients[0].checked)
+	}
+
+	PoolIdleCheck = 0
+	_ = c.Cmd("whoami").Run(context.Background())
+	if dialer.clients[0].checked != 1 {
+		t.Error("idle connection should be checked")
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package client client supporting installing and upgrading remote k3OS nodes
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ProxyJumpNone disables the default jump host for a node
+	ProxyJumpNone = "none"
+	// DefaultJumpHostKey ssh key used for jump hosts when the node is not using key authentication
+	DefaultJumpHostKey = "~/.ssh/id_rsa"
+)
+
+// DefaultProxyJump jump hosts used for nodes without their own proxy jump, "[user@]host[:port],..."
+var DefaultProxyJump string
+
+// JumpHost a host used to reach a node
+type JumpHost struct {
+	User    string
+	Address model.Address
+}
+
+// ParseProxyJump parses comma separated jump hosts "[user@]host[:port]", connected in order
+func ParseProxyJump(proxyJump string) ([]*JumpHost, error) {
+	if len(proxyJump) == 0 || proxyJump == ProxyJumpNone {
+		return nil, nil
+	}
+
+	var jumpHosts []*JumpHost
+	for _, spec := range strings.Split(proxyJump, ",") {
+		spec = strings.TrimSpace(spec)
+		jumpHost := &JumpHost{Address: model.Address{Port: 22}}
+
+		if i := strings.LastIndex(spec, "@"); i >= 0 {
+			jumpHost.User = spec[:i]
+			spec = spec[i+1:]
+		}
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			port, err := strconv.Atoi(spec[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in jump host: %s", spec)
+			}
+			jumpHost.Address.Port = port
+			spec = spec[:i]
+		}
+		if len(spec) == 0 {
+			return nil, fmt.Errorf("invalid jump host: '%s'", proxyJump)
+		}
+		jumpHost.Address.IP = spec
+
+		if len(jumpHost.User) == 0 {
+			if u, err := user.Current(); err == nil {
+				jumpHost.User = u.Username
+			}
+		}
+		jumpHosts = append(jumpHosts, jumpHost)
+	}
+	return jumpHosts, nil
+}
+
+// proxyJump jump hosts for a node, the nodes own or the default
+func proxyJump(auth *model.Auth) ([]*JumpHost, error) {
+	if len(auth.ProxyJump) > 0 {
+		return ParseProxyJump(auth.ProxyJump)
+	}
+	return ParseProxyJump(DefaultProxyJump)
+}
+
+// jumpHostAuth jump hosts use the ssh-agent and the nodes ssh keys, or the default key when the node uses password authentication
+func jumpHostAuth(jumpHost *JumpHost, auth *model.Auth) *model.Auth {
+	if auth.Type != model.AuthTypeSSHKey || len(sshKeys(auth)) == 0 {
+		return &model.Auth{Type: model.AuthTypeSSHKey, User: jumpHost.User, SSHKey: DefaultJumpHostKey}
+	}
+	return &model.Auth{Type: model.AuthTypeSSHKey, User: jumpHost.User, SSHKey: auth.SSHKey, SSHCert: auth.SSHCert, SSHKeys: auth.SSHKeys}
+}
+
+// dial connects to the address through all jump hosts, returns the client and the jump host clients to close
+func dial(ctx context.Context, auth *model.Auth, address *model.Address, config *ssh.ClientConfig) (*ssh.Client, []*ssh.Client, error) {
+	jumps, err := dialJumps(ctx, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sshClient, err := dialVia(ctx, lastJump(jumps), address.String(), config)
+	if err != nil {
+		closeJumps(jumps)
+		return nil, nil, err
+	}
+	return sshClient, jumps, nil
+}
+
+// DialPort connects to a port on the address the way the node with auth is reached, through its jump
+// hosts if it has any, e.g. to check the k3s API. Closing the connection closes the jump hosts too.
+func DialPort(ctx context.Context, auth *model.Auth, address *model.Address) (net.Conn, error) {
+	jumps, err := dialJumps(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialConn(ctx, lastJump(jumps), address.String())
+	if err != nil {
+		closeJumps(jumps)
+		return nil, err
+	}
+	return &jumpConn{Conn: conn, jumps: jumps}, nil
+}
+
+// dialJumps connects to all jump hosts for a node in order, each through the previous one
+func dialJumps(ctx context.Context, auth *model.Auth) ([]*ssh.Client, error) {
+	jumpHosts, err := proxyJump(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	var jumps []*ssh.Client
+	for _, jumpHost := range jumpHosts {
+		jumpConfig, err := clientConfig(jumpHostAuth(jumpHost, auth))
+		if err != nil {
+			closeJumps(jumps)
+			return nil, err
+		}
+		jumpAddress := jumpHost.Address
+		jumpConfig.HostKeyCallback = DefaultHostKeyVerifier.Callback(&jumpAddress, nil)
+
+		next, err := dialVia(ctx, lastJump(jumps), jumpAddress.String(), jumpConfig)
+		if err != nil {
+			closeJumps(jumps)
+			return nil, fmt.Errorf("failed to connect to jump host %s: %v", jumpAddress, err)
+		}
+		jumps = append(jumps, next)
+	}
+	return jumps, nil
+}
+
+// lastJump the jump host connections are made through, nil for a direct connection
+func lastJump(jumps []*ssh.Client) *ssh.Client {
+	if len(jumps) == 0 {
+		return nil
+	}
+	return jumps[len(jumps)-1]
+}
+
+// closeJumps closes jump host clients, the last one first
+func closeJumps(jumps []*ssh.Client) {
+	for i := len(jumps) - 1; i >= 0; i-- {
+		_ = jumps[i].Close()
+	}
+}
+
+// jumpConn a connection tunneled through jump hosts, the jump hosts are closed with the connection
+type jumpConn struct {
+	net.Conn
+	jumps []*ssh.Client
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	closeJumps(c.jumps)
+	return err
+}
+
+// dialVia connects to addr directly or tunneled through a jump host, the connection is closed if ctx
+// is done before the ssh handshake completes
+func dialVia(ctx context.Context, jump *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := dialConn(ctx, jump, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	handshake := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-handshake:
+		}
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	close(handshake)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func dialConn(ctx context.Context, jump *ssh.Client, addr string) (net.Conn, error) {
+	if jump == nil {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	result := make(chan dialResult, 1)
+	go func() {
+		conn, err := jump.Dial("tcp", addr)
+		result <- dialResult{conn, err}
+	}()
+
+	select {
+	case r := <-result:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-result; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseProxyJump(t *testing.T) {
+	jumpHosts, err := ParseProxyJump("admin@bastion.example.com,pi@10.0.0.1:2222")
+
+	assert.NoError(t, err)
+	assert.Len(t, jumpHosts, 2)
+	assert.Equal(t, &JumpHost{User: "admin", Address: model.NewAddress("bastion.example.com", 22)}, jumpHosts[0])
+	assert.Equal(t, &JumpHost{User: "pi", Address: model.NewAddress("10.0.0.1", 2222)}, jumpHosts[1])
+}
+
+func TestParseProxyJump_None(t *testing.T) {
+	jumpHosts, err := ParseProxyJump(ProxyJumpNone)
+	assert.NoError(t, err)
+	assert.Empty(t, jumpHosts)
+
+	_, err = ParseProxyJump("bastion:ssh")
+	assert.Error(t, err)
+}
+
+func TestProxyJump_NodeOverridesDefault(t *testing.T) {
+	DefaultProxyJump = "admin@bastion"
+	defer func() { DefaultProxyJump = "" }()
+
+	jumpHosts, err := proxyJump(&model.Auth{})
+	assert.NoError(t, err)
+	assert.Equal(t, "bastion", jumpHosts[0].Address.IP)
+
+	jumpHosts, err = proxyJump(&model.Auth{ProxyJump: ProxyJumpNone})
+	assert.NoError(t, err)
+	assert.Empty(t, jumpHosts)
+}
+
+func TestJumpHostAuth(t *testing.T) {
+	jumpHost := &JumpHost{User: "admin"}
+
+	keyAuth := jumpHostAuth(jumpHost, &model.Auth{Type: model.AuthTypeSSHKey, User: "rancher", SSHKey: "~/.ssh/k3pi", SSHKeys: []string{"~/.ssh/id_ed25519"}})
+	assert.Equal(t, &model.Auth{Type: model.AuthTypeSSHKey, User: "admin", SSHKey: "~/.ssh/k3pi", SSHKeys: []string{"~/.ssh/id_ed25519"}}, keyAuth)
+
+	passwordAuth := jumpHostAuth(jumpHost, &model.Auth{Type: model.AuthTypeBasicAuth, User: "pi", Password: "raspberry"})
+	assert.Equal(t, &model.Auth{Type: model.AuthTypeSSHKey, User: "admin", SSHKey: DefaultJumpHostKey}, passwordAuth)
+}
+
+func TestDialVia_Cancelled(t *testing.T) {
+	// accepts connections but never completes the ssh handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	config := &ssh.ClientConfig{User: "pi", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	_, err = dialVia(ctx, nil, l.Addr().String(), config)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,323 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package client client supporting installing and upgrading remote k3OS nodes
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"io"
+	"sync"
+)
+
+// Interaction kinds
+const (
+	InteractionConnect   = "connect"
+	InteractionScript    = "script"
+	InteractionCopy      = "copy"
+	InteractionCopyBytes = "copy-bytes"
+	InteractionDownload  = "download"
+)
+
+// Script methods of script interactions
+const (
+	MethodRun    = "run"
+	MethodOutput = "output"
+	MethodStream = "stream"
+	MethodExec   = "exec"
+)
+
+// DefaultRecorder records the sessions of clients created by NewClientFactory and
+// NewPooledClientFactory when set
+var DefaultRecorder *Recorder
+
+// Interaction a connect, script run or file transfer recorded with a node
+type Interaction struct {
+	// Node user@ip:port
+	Node       string            `json:"node"`
+	Kind       string            `json:"kind"`
+	Method     string            `json:"method,omitempty"`
+	Cmds       []string          `json:"cmds,omitempty"`
+	Results    []*RecordedResult `json:"results,omitempty"`
+	Stdout     string            `json:"stdout,omitempty"`
+	Stderr     string            `json:"stderr,omitempty"`
+	RemotePath string            `json:"remote_path,omitempty"`
+	Size       int64             `json:"size,omitempty"`
+	Resumed    int64             `json:"resumed,omitempty"`
+	Data       string            `json:"data,omitempty"`
+	HostKey    string            `json:"host_key,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	// Failed the failed command when Error is a command error
+	Failed *RecordedResult `json:"failed,omitempty"`
+}
+
+// RecordedResult result of a recorded command
+type RecordedResult struct {
+	Cmd        string `json:"cmd"`
+	ExitStatus int    `json:"exit_status"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+}
+
+func recordResult(r *Result) *RecordedResult {
+	return &RecordedResult{Cmd: r.Cmd, ExitStatus: r.ExitStatus, Stdout: string(r.Stdout), Stderr: string(r.Stderr)}
+}
+
+func (r *RecordedResult) result() *Result {
+	return &Result{Cmd: r.Cmd, ExitStatus: r.ExitStatus, Stdout: []byte(r.Stdout), Stderr: []byte(r.Stderr)}
+}
+
+// setError records err, a command error keeps the failed command
+func (i *Interaction) setError(err error) {
+	if err == nil {
+		return
+	}
+	i.Error = err.Error()
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		i.Failed = recordResult(cmdErr.Result)
+	}
+}
+
+// err the recorded error, wraps a command error if a command failed
+func (i *Interaction) err() error {
+	if len(i.Error) == 0 {
+		return nil
+	}
+	err := &replayedError{msg: i.Error}
+	if i.Failed != nil {
+		err.cmdErr = &CommandError{Result: i.Failed.result()}
+	}
+	return err
+}
+
+// replayedError an error as recorded, unwraps to the command error if a command failed
+type replayedError struct {
+	msg    string
+	cmdErr *CommandError
+}
+
+func (e *replayedError) Error() string {
+	return e.msg
+}
+
+func (e *replayedError) Unwrap() error {
+	if e.cmdErr == nil {
+		return nil
+	}
+	return e.cmdErr
+}
+
+// Recorder writes recorded interactions as JSON, one interaction per line. Recordings contain all
+// output and copied data, e.g. tokens, kubeconfig and config.yaml, but not uploaded files.
+type Recorder struct {
+	m   sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecorder creates a recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Err returns the first error writing the recording
+func (r *Recorder) Err() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(i *Interaction) {
+	b, err := json.Marshal(i)
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err == nil {
+		_, err = r.w.Write(append(b, '\n'))
+	}
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// ReadRecording reads interactions written by a recorder
+func ReadRecording(r io.Reader) ([]*Interaction, error) {
+	var interactions []*Interaction
+	decoder := json.NewDecoder(r)
+	for {
+		i := &Interaction{}
+		if err := decoder.Decode(i); err == io.EOF {
+			return interactions, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid recording: %v", err)
+		}
+		interactions = append(interactions, i)
+	}
+}
+
+// recordingFactory returns the factory recording to DefaultRecorder if set
+func recordingFactory(factory *Factory) *Factory {
+	if DefaultRecorder == nil {
+		return factory
+	}
+	return NewRecordingFactory(factory, DefaultRecorder)
+}
+
+// NewRecordingFactory creates a factory recording every connect, script run and file transfer of the
+// clients created by factory
+func NewRecordingFactory(factory *Factory, recorder *Recorder) *Factory {
+	return &Factory{
+		Create: func(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error) {
+			node := nodeKey(auth, address)
+			c, err := factory.Create(ctx, auth, address)
+			i := &Interaction{Node: node, Kind: InteractionConnect}
+			i.setError(err)
+			if err == nil {
+				i.HostKey = c.HostKey()
+			}
+			recorder.record(i)
+			if err != nil {
+				return nil, err
+			}
+			return &recordingClient{Client: c, node: node, recorder: recorder}, nil
+		},
+		close: factory.Close,
+	}
+}
+
+func nodeKey(auth *model.Auth, address *model.Address) string {
+	return fmt.Sprintf("%s@%s", auth.User, address)
+}
+
+// recordingClient records everything done with the client
+type recordingClient struct {
+	Client
+	node     string
+	recorder *Recorder
+}
+
+func (c *recordingClient) Cmd(cmd string) Script {
+	return &recordingScript{script: c.Client.Cmd(cmd), client: c, cmds: []string{cmd}}
+}
+
+func (c *recordingClient) Cmdf(cmd string, a ...interface{}) Script {
+	return c.Cmd(fmt.Sprintf(cmd, a...))
+}
+
+func (c *recordingClient) Copy(ctx context.Context, filename, remotePath string, progress ProgressFunc) error {
+	i := &Interaction{Node: c.node, Kind: InteractionCopy, RemotePath: remotePath}
+	err := c.Client.Copy(ctx, filename, remotePath, func(p Progress) {
+		i.Size, i.Resumed = p.Size, p.Resumed
+		if progress != nil {
+			progress(p)
+		}
+	})
+	i.setError(err)
+	c.recorder.record(i)
+	return err
+}
+
+func (c *recordingClient) CopyBytes(ctx context.Context, b *[]byte, remotePath string) error {
+	err := c.Client.CopyBytes(ctx, b, remotePath)
+	i := &Interaction{Node: c.node, Kind: InteractionCopyBytes, RemotePath: remotePath, Data: string(*b)}
+	i.setError(err)
+	c.recorder.record(i)
+	return err
+}
+
+func (c *recordingClient) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	var data bytes.Buffer
+	err := c.Client.Download(ctx, remotePath, io.MultiWriter(w, &data))
+	i := &Interaction{Node: c.node, Kind: InteractionDownload, RemotePath: remotePath, Data: data.String()}
+	i.setError(err)
+	c.recorder.record(i)
+	return err
+}
+
+// FS file system running its commands and transfers through the recording client
+func (c *recordingClient) FS() FileSystem {
+	return &remoteFS{client: c}
+}
+
+// recordingScript records the commands and output when the script is run
+type recordingScript struct {
+	script Script
+	client *recordingClient
+	cmds   []string
+}
+
+func (s *recordingScript) Cmd(cmd string) Script {
+	s.script = s.script.Cmd(cmd)
+	s.cmds = append(s.cmds, cmd)
+	return s
+}
+
+func (s *recordingScript) Cmdf(cmd string, a ...interface{}) Script {
+	return s.Cmd(fmt.Sprintf(cmd, a...))
+}
+
+func (s *recordingScript) interaction(method string) *Interaction {
+	return &Interaction{Node: s.client.node, Kind: InteractionScript, Method: method, Cmds: s.cmds}
+}
+
+func (s *recordingScript) Run(ctx context.Context) error {
+	err := s.script.Run(ctx)
+	i := s.interaction(MethodRun)
+	i.setError(err)
+	s.client.recorder.record(i)
+	return err
+}
+
+func (s *recordingScript) Output(ctx context.Context) ([]byte, error) {
+	out, err := s.script.Output(ctx)
+	i := s.interaction(MethodOutput)
+	i.Stdout = string(out)
+	i.setError(err)
+	s.client.recorder.record(i)
+	return out, err
+}
+
+func (s *recordingScript) Stream(ctx context.Context, stdout, stderr io.Writer) error {
+	var out, errOut bytes.Buffer
+	err := s.script.Stream(ctx, io.MultiWriter(stdout, &out), io.MultiWriter(stderr, &errOut))
+	i := s.interaction(MethodStream)
+	i.Stdout, i.Stderr = out.String(), errOut.String()
+	i.setError(err)
+	s.client.recorder.record(i)
+	return err
+}
+
+func (s *recordingScript) Exec(ctx context.Context) (Results, error) {
+	results, err := s.script.Exec(ctx)
+	i := s.interaction(MethodExec)
+	for _, r := range results {
+		i.Results = append(i.Results, recordResult(r))
+	}
+	i.setError(err)
+	s.client.recorder.record(i)
+	return results, err
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+// session does the same things with a node whether recorded or replayed
+func session(t *testing.T, factory *Factory) (out []byte, downloaded string) {
+	ctx := context.Background()
+	address := model.NewAddress("10.0.0.1", 22)
+	c, err := factory.Create(ctx, &model.Auth{User: "rancher"}, &address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ssh-ed25519 AAAA", c.HostKey())
+
+	out, err = c.Cmd("uname -m").Output(ctx)
+	assert.NoError(t, err)
+
+	results, err := c.Cmd("whoami").Cmd("sudo tar zxvf k3os.tar.gz -C /").Exec(ctx)
+	assert.Len(t, results, 2)
+	assert.Equal(t, 2, ExitStatus(err))
+	assert.EqualError(t, err, "command 'sudo tar zxvf k3os.tar.gz -C /' exited with status 2: tar: no space left on device")
+
+	var stdout bytes.Buffer
+	assert.NoError(t, c.Cmd("sudo sync").Stream(ctx, &stdout, ioutil.Discard))
+	assert.NoError(t, c.FS().Sudo().WriteFile(ctx, "/k3os/system/config.yaml", []byte("k3os: {}\n"), 0600, ""))
+
+	var b bytes.Buffer
+	assert.NoError(t, c.Download(ctx, "/etc/rancher/k3s/k3s.yaml", &b))
+	return out, b.String()
+}
+
+func TestRecordReplay(t *testing.T) {
+	cf, script := NewFakeClientFactory(func(script *FakeScript) {
+		script.Expect("uname -m", "aarch64")
+		script.ExpectDownload("/etc/rancher/k3s/k3s.yaml", "apiVersion: v1\n")
+	})
+	script.FailCmd(`^sudo tar `, 2, "tar: no space left on device")
+
+	var recording bytes.Buffer
+	recorder := NewRecorder(&recording)
+	address := model.NewAddress("10.0.0.1", 22)
+	address.HostKey = "ssh-ed25519 AAAA"
+	recordingFactory := NewRecordingFactory(&Factory{Create: func(ctx context.Context, auth *model.Auth, _ *model.Address) (Client, error) {
+		return cf.Create(ctx, auth, &address)
+	}}, recorder)
+	out, downloaded := session(t, recordingFactory)
+	assert.NoError(t, recorder.Err())
+
+	interactions, err := ReadRecording(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := NewReplayFactory(interactions)
+	replayedOut, replayedDownload := session(t, replay)
+	assert.Equal(t, out, replayedOut)
+	assert.Equal(t, downloaded, replayedDownload)
+	assert.NoError(t, replay.Close())
+}
+
+func TestReplay_Unexpected(t *testing.T) {
+	replay := NewReplayFactory([]*Interaction{
+		{Node: "rancher@10.0.0.1:22", Kind: InteractionConnect},
+		{Node: "rancher@10.0.0.1:22", Kind: InteractionScript, Method: MethodOutput, Cmds: []string{"uname -m"}, Stdout: "aarch64\n"},
+		{Node: "rancher@10.0.0.1:22", Kind: InteractionDownload, RemotePath: "/etc/rancher/k3s/k3s.yaml", Error: "scp: /etc/rancher/k3s/k3s.yaml: No such file or directory"},
+	})
+	address := model.NewAddress("10.0.0.1", 22)
+	other := model.NewAddress("10.0.0.2", 22)
+
+	_, err := replay.Create(context.Background(), &model.Auth{User: "rancher"}, &other)
+	assert.EqualError(t, err, "replay: connect with rancher@10.0.0.2:22 wasn't recorded")
+
+	c, err := replay.Create(context.Background(), &model.Auth{User: "rancher"}, &address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Cmd("uname -r").Output(context.Background())
+	assert.EqualError(t, err, "replay: output 'uname -r' with rancher@10.0.0.1:22 wasn't recorded, next recorded is output 'uname -m'")
+
+	out, err := c.Cmd("uname -m").Output(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "aarch64\n", string(out))
+
+	assert.EqualError(t, replay.Close(), "replay: 1 recorded interactions not replayed:\n\trancher@10.0.0.1:22: download /etc/rancher/k3s/k3s.yaml")
+}
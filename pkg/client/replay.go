@@ -0,0 +1,239 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package client client supporting installing and upgrading remote k3OS nodes
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// tempPathRegexp matches the random temp files written by the remote file system
+var tempPathRegexp = regexp.MustCompile(`/tmp/\.k3pi-[0-9a-f]+`)
+
+// NewReplayFactory creates a factory serving recorded interactions instead of connecting to nodes.
+// Interactions are replayed in the recorded order for each node, anything else done with a node fails.
+// Commands and remote paths must match, uploaded files and copied data aren't compared. Closing the
+// factory fails if not all interactions have been replayed.
+func NewReplayFactory(interactions []*Interaction) *Factory {
+	r := &replay{queues: make(map[string][]*Interaction)}
+	for _, i := range interactions {
+		r.queues[i.Node] = append(r.queues[i.Node], i)
+	}
+	return &Factory{Create: r.client, close: r.close}
+}
+
+type replay struct {
+	m      sync.Mutex
+	queues map[string][]*Interaction
+}
+
+// next returns the next interaction with the node if it's the expected one
+func (r *replay) next(ctx context.Context, expected *Interaction) (*Interaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	queue := r.queues[expected.Node]
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("replay: %s with %s wasn't recorded", describe(expected), expected.Node)
+	}
+	i := queue[0]
+	if describe(i) != describe(expected) {
+		return nil, fmt.Errorf("replay: %s with %s wasn't recorded, next recorded is %s", describe(expected), expected.Node, describe(i))
+	}
+	r.queues[expected.Node] = queue[1:]
+	return i, nil
+}
+
+// describe describes what an interaction did, temp file names are replaced as they are random
+func describe(i *Interaction) string {
+	var s string
+	switch i.Kind {
+	case InteractionScript:
+		s = fmt.Sprintf("%s '%s'", i.Method, strings.Join(i.Cmds, "; "))
+	case InteractionConnect:
+		s = i.Kind
+	default:
+		s = fmt.Sprintf("%s %s", i.Kind, i.RemotePath)
+	}
+	return tempPathRegexp.ReplaceAllString(s, "/tmp/.k3pi-*")
+}
+
+func (r *replay) close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	var remaining []string
+	for node, queue := range r.queues {
+		for _, i := range queue {
+			remaining = append(remaining, fmt.Sprintf("\t%s: %s", node, describe(i)))
+		}
+	}
+	if len(remaining) > 0 {
+		sort.Strings(remaining)
+		return fmt.Errorf("replay: %d recorded interactions not replayed:\n%s", len(remaining), strings.Join(remaining, "\n"))
+	}
+	return nil
+}
+
+func (r *replay) client(ctx context.Context, auth *model.Auth, address *model.Address) (Client, error) {
+	node := nodeKey(auth, address)
+	i, err := r.next(ctx, &Interaction{Node: node, Kind: InteractionConnect})
+	if err != nil {
+		return nil, err
+	}
+	if err = i.err(); err != nil {
+		return nil, err
+	}
+	return &replayClient{replay: r, node: node, hostKey: i.HostKey}, nil
+}
+
+// replayClient client replaying the recorded interactions with a node
+type replayClient struct {
+	replay  *replay
+	node    string
+	hostKey string
+}
+
+func (c *replayClient) Cmd(cmd string) Script {
+	return &replayScript{client: c, cmds: []string{cmd}}
+}
+
+func (c *replayClient) Cmdf(cmd string, a ...interface{}) Script {
+	return c.Cmd(fmt.Sprintf(cmd, a...))
+}
+
+func (c *replayClient) Copy(ctx context.Context, filename, remotePath string, progress ProgressFunc) error {
+	i, err := c.replay.next(ctx, &Interaction{Node: c.node, Kind: InteractionCopy, RemotePath: remotePath})
+	if err != nil {
+		return err
+	}
+	if progress != nil && i.Size > 0 {
+		done := i.Size
+		if len(i.Error) > 0 {
+			done = i.Resumed
+		}
+		progress(Progress{RemotePath: remotePath, Done: done, Resumed: i.Resumed, Size: i.Size})
+	}
+	return i.err()
+}
+
+func (c *replayClient) CopyBytes(ctx context.Context, b *[]byte, remotePath string) error {
+	i, err := c.replay.next(ctx, &Interaction{Node: c.node, Kind: InteractionCopyBytes, RemotePath: remotePath})
+	if err != nil {
+		return err
+	}
+	return i.err()
+}
+
+func (c *replayClient) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	i, err := c.replay.next(ctx, &Interaction{Node: c.node, Kind: InteractionDownload, RemotePath: remotePath})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(w, i.Data); err != nil {
+		return err
+	}
+	return i.err()
+}
+
+func (c *replayClient) FS() FileSystem {
+	return &remoteFS{client: c}
+}
+
+func (c *replayClient) HostKey() string {
+	return c.hostKey
+}
+
+func (c *replayClient) Close() error {
+	return nil
+}
+
+// replayScript script replaying the recorded run of the same commands
+type replayScript struct {
+	client *replayClient
+	cmds   []string
+}
+
+func (s *replayScript) Cmd(cmd string) Script {
+	s.cmds = append(s.cmds, cmd)
+	return s
+}
+
+func (s *replayScript) Cmdf(cmd string, a ...interface{}) Script {
+	return s.Cmd(fmt.Sprintf(cmd, a...))
+}
+
+func (s *replayScript) next(ctx context.Context, method string) (*Interaction, error) {
+	return s.client.replay.next(ctx, &Interaction{Node: s.client.node, Kind: InteractionScript, Method: method, Cmds: s.cmds})
+}
+
+func (s *replayScript) Run(ctx context.Context) error {
+	i, err := s.next(ctx, MethodRun)
+	if err != nil {
+		return err
+	}
+	return i.err()
+}
+
+func (s *replayScript) Output(ctx context.Context) ([]byte, error) {
+	i, err := s.next(ctx, MethodOutput)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(i.Stdout), i.err()
+}
+
+func (s *replayScript) Stream(ctx context.Context, stdout, stderr io.Writer) error {
+	i, err := s.next(ctx, MethodStream)
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(stdout, i.Stdout); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(stderr, i.Stderr); err != nil {
+		return err
+	}
+	return i.err()
+}
+
+func (s *replayScript) Exec(ctx context.Context) (Results, error) {
+	i, err := s.next(ctx, MethodExec)
+	if err != nil {
+		return nil, err
+	}
+	var results Results
+	for _, r := range i.Results {
+		results = append(results, r.result())
+	}
+	return results, i.err()
+}
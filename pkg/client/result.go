@@ -0,0 +1,91 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ExitStatusUnknown exit status of a command that didn't report one, e.g. killed by a signal
+const ExitStatusUnknown = -1
+
+// Result result of running one command of a script
+type Result struct {
+	Cmd            string
+	ExitStatus     int
+	Stdout, Stderr []byte
+	Duration       time.Duration
+}
+
+// Success returns true if the command exited with status 0
+func (r *Result) Success() bool {
+	return r.ExitStatus == 0
+}
+
+// Results results of the commands run by a script, in order
+type Results []*Result
+
+// Stdout returns stdout of all commands
+func (results Results) Stdout() []byte {
+	var b bytes.Buffer
+	for _, r := range results {
+		b.Write(r.Stdout)
+	}
+	return b.Bytes()
+}
+
+// Last returns the result of the last command run, the failing command if the script failed
+func (results Results) Last() *Result {
+	if len(results) == 0 {
+		return nil
+	}
+	return results[len(results)-1]
+}
+
+// CommandError a command exited with a non-zero exit status
+type CommandError struct {
+	Result *Result
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("command '%s' exited with status %d", e.Result.Cmd, e.Result.ExitStatus)
+	if stderr := strings.TrimSpace(string(e.Result.Stderr)); len(stderr) > 0 {
+		lines := strings.Split(stderr, "\n")
+		msg = fmt.Sprintf("%s: %s", msg, lines[len(lines)-1])
+	}
+	return msg
+}
+
+// ExitStatus returns the exit status of a failed command, or ExitStatusUnknown if err doesn't wrap
+// a CommandError
+func ExitStatus(err error) int {
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Result.ExitStatus
+	}
+	return ExitStatusUnknown
+}
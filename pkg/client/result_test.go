@@ -0,0 +1,66 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCommandError(t *testing.T) {
+	err := &CommandError{Result: &Result{Cmd: "sudo cat /etc/token", ExitStatus: 1, Stderr: []byte("sudo: 1 incorrect attempt\ncat: /etc/token: No such file or directory\n")}}
+
+	assert.EqualError(t, err, "command 'sudo cat /etc/token' exited with status 1: cat: /etc/token: No such file or directory")
+	assert.Equal(t, 1, ExitStatus(errors.Wrap(err, "failed to read token")))
+	assert.Equal(t, ExitStatusUnknown, ExitStatus(fmt.Errorf("connection lost")))
+}
+
+func TestResults(t *testing.T) {
+	results := Results{
+		{Cmd: "hostname", Stdout: []byte("node1\n")},
+		{Cmd: "uname -m", Stdout: []byte("aarch64\n"), ExitStatus: 2},
+	}
+
+	assert.Equal(t, "node1\naarch64\n", string(results.Stdout()))
+	assert.Equal(t, "uname -m", results.Last().Cmd)
+	assert.False(t, results.Last().Success())
+	assert.Nil(t, Results{}.Last())
+}
+
+func TestFakeScript_Exec(t *testing.T) {
+	cf, script := NewFakeClientFactory(func(script *FakeScript) {
+		script.Expect("hostname", "node1")
+	})
+	script.Error = fmt.Errorf("failed")
+
+	c, _ := cf.Create(context.Background(), nil, nil)
+	results, err := c.Cmd("hostname").Cmd("false").Exec(context.Background())
+
+	assert.EqualError(t, err, "failed")
+	assert.Len(t, results, 2)
+	assert.Equal(t, "node1", string(results[0].Stdout))
+	assert.True(t, results[0].Success())
+	assert.Equal(t, 1, results.Last().ExitStatus)
+}
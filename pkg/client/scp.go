@@ -0,0 +1,165 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package client client supporting installing and upgrading remote k3OS nodes
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// scpUpload copies data to remotePath using the scp protocol on the session
+func scpUpload(session *ssh.Session, r io.Reader, size int64, mode os.FileMode, remotePath string) error {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = session.Start(fmt.Sprintf("scp -qt %s", quotePath(remotePath))); err != nil {
+		return err
+	}
+
+	err = scpSend(stdin, bufio.NewReader(stdout), r, size, mode, path.Base(remotePath))
+	stdin.Close()
+	if err != nil {
+		return fmt.Errorf("failed to copy to %s: %v", remotePath, err)
+	}
+	return session.Wait()
+}
+
+// scpDownload copies remotePath to w using the scp protocol on the session
+func scpDownload(session *ssh.Session, remotePath string, w io.Writer) error {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = session.Start(fmt.Sprintf("scp -qf %s", quotePath(remotePath))); err != nil {
+		return err
+	}
+
+	err = scpReceive(stdin, bufio.NewReader(stdout), w)
+	stdin.Close()
+	if err != nil {
+		return fmt.Errorf("failed to copy from %s: %v", remotePath, err)
+	}
+	return session.Wait()
+}
+
+// scpSend is the source side of the scp protocol, sends one file to a sink
+func scpSend(w io.Writer, acks *bufio.Reader, r io.Reader, size int64, mode os.FileMode, name string) error {
+	if err := scpReadAck(acks); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "C%04o %d %s\n", mode.Perm(), size, name); err != nil {
+		return err
+	}
+	if err := scpReadAck(acks); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("short read, copied %d of %d bytes", n, size)
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+	return scpReadAck(acks)
+}
+
+// scpReceive is the sink side of the scp protocol, receives one file from a source
+func scpReceive(w io.Writer, r *bufio.Reader, out io.Writer) error {
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	switch header[0] {
+	case 'C':
+	case 1, 2:
+		return errors.New(strings.TrimSpace(header[1:]))
+	default:
+		return fmt.Errorf("unexpected scp message: %q", header)
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(header[1:]), " ", 3)
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid scp file header: %q", header)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid scp file size: %q", header)
+	}
+
+	if _, err = w.Write([]byte{0}); err != nil {
+		return err
+	}
+	if _, err = io.CopyN(out, r, size); err != nil {
+		return err
+	}
+	if err = scpReadAck(r); err != nil {
+		return err
+	}
+	_, err = w.Write([]byte{0})
+	return err
+}
+
+// scpReadAck reads a scp response, 0 is ok and 1 (warning) or 2 (error) is followed by a message
+func scpReadAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	msg, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if code == 1 || code == 2 {
+		return errors.New(strings.TrimSpace(msg))
+	}
+	return fmt.Errorf("unexpected scp response: %q", string(code)+msg)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// remote runs one side of the scp protocol, as the remote scp would
+func remote(side func(in *bufio.Reader, out io.Writer)) (*bufio.Reader, io.Writer) {
+	localIn, remoteOut := io.Pipe()
+	remoteIn, localOut := io.Pipe()
+	go func() {
+		side(bufio.NewReader(remoteIn), remoteOut)
+		remoteOut.Close()
+	}()
+	return bufio.NewReader(localIn), localOut
+}
+
+func TestScpSend(t *testing.T) {
+	var header string
+	var received []byte
+	in, out := remote(func(in *bufio.Reader, out io.Writer) {
+		out.Write([]byte{0})
+		header, _ = in.ReadString('\n')
+		out.Write([]byte{0})
+		received = make([]byte, 7)
+		io.ReadFull(in, received)
+		out.Write([]byte{0})
+	})
+
+	err := scpSend(out, in, strings.NewReader("hello\n"), 6, 0644, "config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != "C0644 6 config.yaml\n" {
+		t.Errorf("unexpected header: %q", header)
+	}
+	if string(received) != "hello\n\x00" {
+		t.Errorf("unexpected content: %q", received)
+	}
+}
+
+func TestScpSend_Error(t *testing.T) {
+	in, out := remote(func(in *bufio.Reader, out io.Writer) {
+		out.Write([]byte{0})
+		in.ReadString('\n')
+		out.Write([]byte("\x01scp: /k3os/system/config.yaml: Permission denied\n"))
+		io.Copy(ioutil.Discard, in)
+	})
+
+	err := scpSend(out, in, strings.NewReader("hello\n"), 6, 0644, "config.yaml")
+	if err == nil || err.Error() != "scp: /k3os/system/config.yaml: Permission denied" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestScpReceive(t *testing.T) {
+	in, out := remote(func(in *bufio.Reader, out io.Writer) {
+		in.ReadByte()
+		out.Write([]byte("C0600 12 k3s.yaml\n"))
+		in.ReadByte()
+		out.Write([]byte("apiVersion: \x00"))
+		in.ReadByte()
+	})
+
+	var b bytes.Buffer
+	if err := scpReceive(out, in, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "apiVersion: " {
+		t.Errorf("unexpected content: %q", b.String())
+	}
+}
+
+func TestScpReceive_NoSuchFile(t *testing.T) {
+	in, out := remote(func(in *bufio.Reader, out io.Writer) {
+		in.ReadByte()
+		out.Write([]byte("\x01scp: /etc/rancher/k3s/k3s.yaml: No such file or directory\n"))
+	})
+
+	var b bytes.Buffer
+	err := scpReceive(out, in, &b)
+	if err == nil || !strings.Contains(err.Error(), "No such file or directory") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
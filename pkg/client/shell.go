@@ -0,0 +1,138 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+)
+
+const (
+	// DefaultTerminalWidth width of the remote terminal if the local size is unknown
+	DefaultTerminalWidth = 80
+	// DefaultTerminalHeight height of the remote terminal if the local size is unknown
+	DefaultTerminalHeight = 24
+)
+
+// Terminal the local side of an interactive session
+type Terminal struct {
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+	// Type terminal type, e.g. $TERM, a pty is requested if set
+	Type string
+	// Size returns the width and height of the local terminal
+	Size func() (width, height int, err error)
+	// Resized receives when the local terminal has been resized
+	Resized <-chan struct{}
+}
+
+// size returns the size of the terminal, or the default size if unknown
+func (t *Terminal) size() (width, height int) {
+	if t.Size != nil {
+		if w, h, err := t.Size(); err == nil && w > 0 && h > 0 {
+			return w, h
+		}
+	}
+	return DefaultTerminalWidth, DefaultTerminalHeight
+}
+
+// Shell runs an interactive session on the node connected to the terminal, a login shell or the
+// command if not empty. The remote terminal follows the size of the local terminal. A non-zero exit
+// status is returned as a CommandError.
+func Shell(ctx context.Context, c Client, command string, terminal *Terminal) error {
+	sc, ok := c.(interface {
+		shell(ctx context.Context, command string, terminal *Terminal) error
+	})
+	if !ok {
+		return fmt.Errorf("interactive sessions are not supported by %T", c)
+	}
+	return sc.shell(ctx, command, terminal)
+}
+
+func (c *client) shell(ctx context.Context, command string, terminal *Terminal) error {
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if len(terminal.Type) > 0 {
+		width, height := terminal.size()
+		modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}
+		if err = session.RequestPty(terminal.Type, height, width, modes); err != nil {
+			return fmt.Errorf("failed to request pty: %v", err)
+		}
+	}
+
+	session.Stdin = terminal.Stdin
+	session.Stdout = terminal.Stdout
+	session.Stderr = terminal.Stderr
+	if len(command) == 0 {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	for {
+		select {
+		case err = <-done:
+			if exitErr, ok := err.(*ssh.ExitError); ok {
+				cmd := command
+				if len(cmd) == 0 {
+					cmd = "shell"
+				}
+				return &CommandError{Result: &Result{Cmd: cmd, ExitStatus: exitErr.ExitStatus()}}
+			}
+			return err
+		case <-terminal.Resized:
+			width, height := terminal.size()
+			_ = session.WindowChange(height, width)
+		case <-ctx.Done():
+			_ = session.Close()
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *pooledClient) shell(ctx context.Context, command string, terminal *Terminal) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	return Shell(ctx, conn, command, terminal)
+}
+
+// shell interactive sessions aren't recorded
+func (c *recordingClient) shell(ctx context.Context, command string, terminal *Terminal) error {
+	return Shell(ctx, c.Client, command, terminal)
+}
@@ -0,0 +1,233 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// SudoPasswordEnv environment variable with the sudo password for nodes without one in their auth
+	SudoPasswordEnv = "K3PI_SUDO_PASSWORD"
+	// sudoPrompt prompt sudo is told to use, the password is written when it shows up on stderr
+	sudoPrompt = "[k3pi-sudo] password: "
+	// sudoStarted marker written to stderr when sudo starts the command
+	sudoStarted = "[k3pi-sudo] started\n"
+)
+
+// DefaultSudoPassword sudo password for nodes without one in their auth, read from SudoPasswordEnv by default
+var DefaultSudoPassword = os.Getenv(SudoPasswordEnv)
+
+// SudoError sudo refused to run a command, e.g. a password is required or the user isn't a sudoer
+type SudoError struct {
+	Result *Result
+	Reason string
+}
+
+func (e *SudoError) Error() string {
+	return fmt.Sprintf("sudo failed for command '%s': %s", e.Result.Cmd, e.Reason)
+}
+
+// Unwrap returns the command error, the exit status is available with ExitStatus
+func (e *SudoError) Unwrap() error {
+	return &CommandError{Result: e.Result}
+}
+
+// CheckSudo checks that commands can be run with sudo on the node, fails with a SudoError if not
+func CheckSudo(ctx context.Context, c Client) error {
+	_, err := c.Cmd("sudo true").Exec(ctx)
+	return err
+}
+
+// ReadSudoPassword prompts for the sudo password on the controlling terminal, also when stdin is piped
+func ReadSudoPassword() (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no terminal to read the sudo password from, set %s", SudoPasswordEnv)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, "Enter sudo password: ")
+	password, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return string(password), err
+}
+
+// sudoPassword the password for sudo: the one in the auth, the login password or DefaultSudoPassword
+func sudoPassword(auth *model.Auth) string {
+	if auth == nil {
+		return DefaultSudoPassword
+	}
+	if len(auth.SudoPassword) > 0 {
+		return auth.SudoPassword
+	}
+	if auth.Type == model.AuthTypeBasicAuth && len(auth.Password) > 0 {
+		return auth.Password
+	}
+	return DefaultSudoPassword
+}
+
+// isSudo returns true if the command is run with sudo
+func isSudo(cmd string) bool {
+	return strings.HasPrefix(strings.TrimSpace(cmd), "sudo ")
+}
+
+// sudo prepares a session for a command starting with sudo. With a password, sudo reads it from stdin
+// when it prompts and the command prints a marker to stderr when it starts, stdin is closed then so a
+// command reading stdin isn't left waiting. Without a password sudo fails instead of waiting for one.
+// Other commands are returned as is. flush writes output held back while looking for the prompt, it's
+// called when the command is done.
+func sudo(session *ssh.Session, cmd, password string) (sudoCmd string, flush func() error, err error) {
+	flush = func() error { return nil }
+	if !isSudo(cmd) {
+		return cmd, flush, nil
+	}
+	args := strings.TrimPrefix(strings.TrimSpace(cmd), "sudo ")
+	if len(password) == 0 {
+		return "sudo -n " + args, flush, nil
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return "", nil, err
+	}
+	w := &promptWriter{w: session.Stderr, stdin: stdin, password: password}
+	session.Stderr = w
+	return fmt.Sprintf("sudo -S -p %s sh -c %s %s %s", Quote(sudoPrompt), Quote(`printf '%s' "$0" >&2; exec "$@"`), Quote(sudoStarted), args), w.flush, nil
+}
+
+// sudoFailure returns why sudo failed given the stderr of the command, empty if it wasn't sudo
+func sudoFailure(stderr []byte) string {
+	s := string(stderr)
+	switch {
+	case strings.Contains(s, "sudo: a password is required"), strings.Contains(s, "sudo: a terminal is required"):
+		return fmt.Sprintf("a password is required, set sudo_password in the node auth or %s", SudoPasswordEnv)
+	case strings.Contains(s, "incorrect password"), strings.Contains(s, "no password was provided"):
+		return "incorrect password"
+	case strings.Contains(s, "is not in the sudoers file"), strings.Contains(s, "is not allowed to"), strings.Contains(s, "may not run sudo"):
+		return "the user is not allowed to use sudo"
+	case strings.Contains(s, "sudo: command not found"), strings.Contains(s, "sudo: not found"):
+		return "sudo is not installed"
+	}
+	return ""
+}
+
+// promptWriter answers the first sudo prompt written to stderr with the password and closes stdin, sudo
+// fails on a second prompt, the password was wrong. Stdin is also closed when the command starts without
+// a prompt. Prompts and the start marker are removed from the output.
+type promptWriter struct {
+	w        io.Writer
+	stdin    io.WriteCloser
+	password string
+	m        sync.Mutex
+	pending  []byte
+	prompted bool
+	started  bool
+}
+
+func (p *promptWriter) Write(b []byte) (int, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.started {
+		return len(b), p.write(b)
+	}
+
+	p.pending = append(p.pending, b...)
+	for {
+		i, token := nextToken(p.pending, sudoPrompt, sudoStarted)
+		if i < 0 {
+			break
+		}
+		if err := p.write(p.pending[:i]); err != nil {
+			return 0, err
+		}
+		p.pending = p.pending[i+len(token):]
+		if token == sudoStarted {
+			p.started = true
+			_ = p.stdin.Close()
+			err := p.write(p.pending)
+			p.pending = nil
+			return len(b), err
+		}
+		if !p.prompted {
+			p.prompted = true
+			_, _ = io.WriteString(p.stdin, p.password+"\n")
+		}
+		_ = p.stdin.Close()
+	}
+
+	// keep what may be the start of a prompt or the marker
+	keep := 0
+	for _, token := range []string{sudoPrompt, sudoStarted} {
+		for n := len(token) - 1; n > keep; n-- {
+			if bytes.HasSuffix(p.pending, []byte(token[:n])) {
+				keep = n
+				break
+			}
+		}
+	}
+	if err := p.write(p.pending[:len(p.pending)-keep]); err != nil {
+		return 0, err
+	}
+	p.pending = append([]byte(nil), p.pending[len(p.pending)-keep:]...)
+	return len(b), nil
+}
+
+// flush writes what is held back, e.g. output ending with the start of a prompt
+func (p *promptWriter) flush() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	err := p.write(p.pending)
+	p.pending = nil
+	return err
+}
+
+func (p *promptWriter) write(b []byte) error {
+	if p.w == nil || len(b) == 0 {
+		return nil
+	}
+	_, err := p.w.Write(b)
+	return err
+}
+
+// nextToken returns the index and the first of the tokens found in b, -1 if none is found
+func nextToken(b []byte, tokens ...string) (int, string) {
+	index, found := -1, ""
+	for _, token := range tokens {
+		if i := bytes.Index(b, []byte(token)); i >= 0 && (index < 0 || i < index) {
+			index, found = i, token
+		}
+	}
+	return index, found
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"bytes"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type fakeStdin struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (f *fakeStdin) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestPromptWriter(t *testing.T) {
+	var stderr bytes.Buffer
+	stdin := &fakeStdin{}
+	w := &promptWriter{w: &stderr, stdin: stdin, password: "secret"}
+
+	// the prompt is split over two writes
+	_, _ = w.Write([]byte("warning\n" + sudoPrompt[:5]))
+	_, _ = w.Write([]byte(sudoPrompt[5:]))
+
+	assert.Equal(t, "warning\n", stderr.String())
+	assert.Equal(t, "secret\n", stdin.String())
+	assert.True(t, stdin.closed)
+
+	_, _ = w.Write([]byte("Sorry, try again.\n" + sudoPrompt))
+
+	assert.Equal(t, "warning\nSorry, try again.\n", stderr.String())
+	assert.Equal(t, "secret\n", stdin.String())
+}
+
+func TestPromptWriter_NoPrompt(t *testing.T) {
+	var stderr bytes.Buffer
+	stdin := &fakeStdin{}
+	w := &promptWriter{w: &stderr, stdin: stdin, password: "secret"}
+
+	_, _ = w.Write([]byte(sudoStarted[:3]))
+	assert.False(t, stdin.closed)
+	_, _ = w.Write([]byte(sudoStarted[3:] + "tar: " + sudoPrompt[:5]))
+
+	assert.True(t, stdin.closed, "stdin should be closed when the command starts")
+	assert.Empty(t, stdin.String())
+	assert.Equal(t, "tar: "+sudoPrompt[:5], stderr.String(), "output after the start is passed on")
+}
+
+func TestPromptWriter_Flush(t *testing.T) {
+	var stderr bytes.Buffer
+	w := &promptWriter{w: &stderr, stdin: &fakeStdin{}, password: "secret"}
+
+	_, _ = w.Write([]byte("sudo: unable to resolve host " + sudoPrompt[:3]))
+	assert.Equal(t, "sudo: unable to resolve host ", stderr.String())
+
+	assert.NoError(t, w.flush())
+	assert.Equal(t, "sudo: unable to resolve host "+sudoPrompt[:3], stderr.String())
+}
+
+func TestSudoFailure(t *testing.T) {
+	assert.Contains(t, sudoFailure([]byte("sudo: a password is required\n")), "a password is required")
+	assert.Equal(t, "incorrect password", sudoFailure([]byte("sudo: 1 incorrect password attempt\n")))
+	assert.Equal(t, "the user is not allowed to use sudo", sudoFailure([]byte("pi is not in the sudoers file.  This incident will be reported.\n")))
+	assert.Equal(t, "sudo is not installed", sudoFailure([]byte("sh: sudo: not found\n")))
+	assert.Empty(t, sudoFailure([]byte("tar: short read\n")))
+}
+
+func TestSudoPassword(t *testing.T) {
+	defaultPassword := DefaultSudoPassword
+	defer func() { DefaultSudoPassword = defaultPassword }()
+	DefaultSudoPassword = "default"
+
+	assert.Equal(t, "sudo", sudoPassword(&model.Auth{Type: model.AuthTypeBasicAuth, Password: "login", SudoPassword: "sudo"}))
+	assert.Equal(t, "login", sudoPassword(&model.Auth{Type: model.AuthTypeBasicAuth, Password: "login"}))
+	assert.Equal(t, "default", sudoPassword(&model.Auth{Type: model.AuthTypeSSHKey}))
+}
+
+func TestSudoError(t *testing.T) {
+	err := &SudoError{Result: &Result{Cmd: "sudo true", ExitStatus: 1}, Reason: "incorrect password"}
+
+	assert.EqualError(t, err, "sudo failed for command 'sudo true': incorrect password")
+	assert.Equal(t, 1, ExitStatus(err))
+	assert.True(t, isSudo("  sudo cp config.yaml /k3os/system/config.yaml"))
+	assert.False(t, isSudo("sudoku"))
+}
@@ -0,0 +1,225 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PartialSuffix suffix of a remote file while it's uploaded, an upload resumes from the partial file
+	PartialSuffix = ".part"
+	// uploadChunkSize bytes sent between bandwidth limit and progress updates
+	uploadChunkSize = 32 << 10
+)
+
+// Bandwidth limits for uploads in bytes per second, zero is no limit. NodeBandwidthLimit limits each
+// upload, TotalBandwidthLimit all uploads together.
+var (
+	NodeBandwidthLimit  int64
+	TotalBandwidthLimit int64
+)
+
+// totalLimiter shared by all uploads
+var totalLimiter = &limiter{}
+
+// Progress progress of an upload, Done includes the bytes resumed from a partial upload
+type Progress struct {
+	RemotePath    string
+	Done, Resumed int64
+	Size          int64
+}
+
+// Percent percent of the file uploaded
+func (p Progress) Percent() int {
+	if p.Size == 0 {
+		return 100
+	}
+	return int(p.Done * 100 / p.Size)
+}
+
+// ProgressFunc is called when an upload starts and as bytes are sent
+type ProgressFunc func(p Progress)
+
+// upload uploads a file to remotePath. The file is written to remotePath + PartialSuffix and moved in
+// place when complete, a partial file left by an earlier upload is appended to if its content matches
+// the start of the file.
+func (c *client) upload(ctx context.Context, filename, remotePath string, progress ProgressFunc) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	part := remotePath + PartialSuffix
+	offset, err := c.resumeOffset(ctx, f, part, size)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := &uploadReader{
+		ctx:      ctx,
+		r:        io.LimitReader(f, size-offset),
+		limiters: []*limiter{totalLimiter, {}},
+		rates:    []int64{TotalBandwidthLimit, NodeBandwidthLimit},
+		progress: progress,
+		p:        Progress{RemotePath: remotePath, Done: offset, Resumed: offset, Size: size},
+	}
+	r.report()
+
+	redirect := ">"
+	if offset > 0 {
+		redirect = ">>"
+	}
+	err = withSession(ctx, c.sshClient, func(session *ssh.Session) error {
+		session.Stdin = r
+		return session.Run(fmt.Sprintf("cat %s %s", redirect, quotePath(part)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload to %s: %v", remotePath, err)
+	}
+
+	uploaded, err := c.remoteSize(ctx, part)
+	if err != nil {
+		return err
+	}
+	if uploaded != size {
+		return fmt.Errorf("failed to upload to %s: uploaded %d of %d bytes", remotePath, uploaded, size)
+	}
+	return c.Cmdf("mv -f %s %s", quotePath(part), quotePath(remotePath)).Run(ctx)
+}
+
+// resumeOffset returns the size of the partial file if it matches the start of f, otherwise 0
+func (c *client) resumeOffset(ctx context.Context, f *os.File, part string, size int64) (int64, error) {
+	partSize, err := c.remoteSize(ctx, part)
+	if err != nil || partSize == 0 || partSize > size {
+		return 0, err
+	}
+
+	h := sha256.New()
+	if _, err = io.CopyN(h, f, partSize); err != nil {
+		return 0, err
+	}
+	out, err := c.Cmdf("head -c %d %s | sha256sum", partSize, quotePath(part)).Output(ctx)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || fields[0] != hex.EncodeToString(h.Sum(nil)) {
+		return 0, nil
+	}
+	return partSize, nil
+}
+
+// remoteSize returns the size of a remote file, 0 if it doesn't exist
+func (c *client) remoteSize(ctx context.Context, remotePath string) (int64, error) {
+	out, err := c.Cmdf("wc -c < %s 2>/dev/null || echo 0", quotePath(remotePath)).Output(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// uploadReader reads the file in chunks within the bandwidth limits and reports progress
+type uploadReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*limiter
+	rates    []int64
+	progress ProgressFunc
+	p        Progress
+}
+
+func (u *uploadReader) Read(b []byte) (int, error) {
+	if len(b) > uploadChunkSize {
+		b = b[:uploadChunkSize]
+	}
+	n, err := u.r.Read(b)
+	for i, l := range u.limiters {
+		if waitErr := l.wait(u.ctx, u.rates[i], n); waitErr != nil {
+			return 0, waitErr
+		}
+	}
+	if n > 0 {
+		u.p.Done += int64(n)
+		u.report()
+	}
+	return n, err
+}
+
+func (u *uploadReader) report() {
+	if u.progress != nil {
+		u.progress(u.p)
+	}
+}
+
+// limiter spaces out sends to stay within a rate in bytes per second
+type limiter struct {
+	m    sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n bytes can be sent, returns at once if rate is zero
+func (l *limiter) wait(ctx context.Context, rate int64, n int) error {
+	if rate <= 0 || n <= 0 {
+		return nil
+	}
+
+	l.m.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	l.m.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestUploadReader(t *testing.T) {
+	var reported []Progress
+	r := &uploadReader{
+		ctx:      context.Background(),
+		r:        bytes.NewReader(make([]byte, 3*uploadChunkSize)),
+		limiters: []*limiter{{}},
+		rates:    []int64{10 * uploadChunkSize},
+		progress: func(p Progress) { reported = append(reported, p) },
+		p:        Progress{RemotePath: "~/k3s", Done: uploadChunkSize, Resumed: uploadChunkSize, Size: 4 * uploadChunkSize},
+	}
+
+	start := time.Now()
+	var sent bytes.Buffer
+	_, err := io.CopyBuffer(struct{ io.Writer }{&sent}, r, make([]byte, 4*uploadChunkSize))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3*uploadChunkSize, sent.Len())
+	// the first chunk is sent at once, the following two wait 100ms each
+	assert.True(t, time.Since(start) >= 180*time.Millisecond, "bandwidth limit not applied")
+	assert.Len(t, reported, 3)
+	assert.Equal(t, 50, reported[0].Percent())
+	assert.Equal(t, 100, reported[2].Percent())
+}
+
+func TestLimiter_Cancelled(t *testing.T) {
+	l := &limiter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.NoError(t, l.wait(ctx, 0, uploadChunkSize))
+	assert.NoError(t, l.wait(ctx, 1, 1))
+	assert.Equal(t, context.Canceled, l.wait(ctx, 1, 1))
+}
@@ -0,0 +1,317 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package cmd handles k3pi use cases
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/install"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultExecConcurrency default number of nodes a command is run on in parallel
+const DefaultExecConcurrency = 10
+
+// Exec output formats, OutputJSON and OutputNDJSON are also supported
+const (
+	// ExecOutputPrefixed output lines are prefixed with the hostname, nodes are written as they finish
+	ExecOutputPrefixed = "prefixed"
+	// ExecOutputGrouped output is written per node under a header, in node order
+	ExecOutputGrouped = "grouped"
+)
+
+// ExecOutputFormats all supported exec output formats
+var ExecOutputFormats = []string{ExecOutputPrefixed, ExecOutputGrouped, OutputJSON, OutputNDJSON}
+
+// ExecArgs arguments for running a command on nodes
+type ExecArgs struct {
+	Nodes    model.Nodes
+	Selector model.Selector
+	// Command shell command run on every node, a single line. Scripts run every line in its own
+	// session, multiple commands are separated with ';' or '&&'.
+	Command string
+	// Sudo runs the command with sudo
+	Sudo bool
+	// Concurrency max number of nodes the command is run on in parallel, defaults to DefaultExecConcurrency
+	Concurrency int
+}
+
+// ExecResult result of running the command on a node, ExitStatus is client.ExitStatusUnknown if the
+// command couldn't be run, e.g. the node couldn't be reached
+type ExecResult struct {
+	Hostname   string        `json:"hostname"`
+	Address    string        `json:"address"`
+	ExitStatus int           `json:"exit_status"`
+	Stdout     string        `json:"stdout"`
+	Stderr     string        `json:"stderr"`
+	Duration   time.Duration `json:"duration"`
+	Error      string        `json:"error,omitempty"`
+
+	index int
+}
+
+// Success returns true if the command exited with status 0
+func (r *ExecResult) Success() bool {
+	return r.ExitStatus == 0 && len(r.Error) == 0
+}
+
+// ExecFunc runs the command on all nodes matching the selector and calls done with the result of each
+// node as it finishes, done is never called concurrently. When ctx is stopped the command isn't
+// started on more nodes and an error is returned.
+func ExecFunc(ctx context.Context, clientFactory *client.Factory, args *ExecArgs, done func(result *ExecResult)) error {
+	var nodes model.Nodes
+	for _, n := range args.Nodes.Select(args.Selector) {
+		if !n.Vanished {
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("no nodes matching selector: %s", args.Selector)
+	}
+
+	command := strings.TrimSpace(args.Command)
+	if strings.Contains(command, "\n") {
+		return fmt.Errorf("multi-line commands are not supported, separate commands with ';' or '&&'")
+	}
+	if args.Sudo {
+		command = fmt.Sprintf("sudo sh -c %s", client.Quote(command))
+	}
+
+	concurrency := args.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultExecConcurrency
+	}
+	if len(nodes) < concurrency {
+		concurrency = len(nodes)
+	}
+
+	indexChan := make(chan int)
+	resultChan := make(chan *ExecResult, len(nodes))
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for index := range indexChan {
+				resultChan <- execNode(ctx, clientFactory, nodes[index], command, index)
+			}
+		}()
+	}
+
+	stopping := misc.Stopping(ctx)
+	started, finished := 0, 0
+schedule:
+	for i := range nodes {
+		if misc.Stopped(ctx) {
+			break
+		}
+		// results are passed on while the command is started on more nodes
+		for scheduled := false; !scheduled; {
+			select {
+			case indexChan <- i:
+				started++
+				scheduled = true
+			case result := <-resultChan:
+				finished++
+				done(result)
+			case <-stopping:
+				break schedule
+			}
+		}
+	}
+	close(indexChan)
+
+	for ; finished < started; finished++ {
+		done(<-resultChan)
+	}
+
+	if started < len(nodes) {
+		return fmt.Errorf("exec interrupted, command started on %d of %d nodes", started, len(nodes))
+	}
+	return nil
+}
+
+// Exec runs the command on all nodes matching the selector, the results are in node order
+func Exec(ctx context.Context, clientFactory *client.Factory, args *ExecArgs) ([]*ExecResult, error) {
+	var results []*ExecResult
+	err := ExecFunc(ctx, clientFactory, args, func(result *ExecResult) {
+		results = append(results, result)
+	})
+	sortExecResults(results)
+	return results, err
+}
+
+func execNode(ctx context.Context, clientFactory *client.Factory, node *model.Node, command string, index int) *ExecResult {
+	result := &ExecResult{Hostname: node.Hostname, Address: node.Address.IP, ExitStatus: client.ExitStatusUnknown, index: index}
+	if len(result.Hostname) == 0 {
+		result.Hostname = node.Address.IP
+	}
+
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	c, err := clientFactory.Create(ctx, &node.Auth, &node.Address)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer c.Close()
+
+	results, err := c.Cmd(command).Exec(ctx)
+	if last := results.Last(); last != nil {
+		result.Stdout, result.Stderr = string(last.Stdout), string(last.Stderr)
+	}
+	switch {
+	case err == nil:
+		result.ExitStatus = 0
+	default:
+		result.ExitStatus = client.ExitStatus(err)
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func sortExecResults(results []*ExecResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].index < results[j].index
+	})
+}
+
+// ExecWriter writes exec results in an output format. Write is called for every node as it finishes
+// and Flush when the command has finished on all nodes.
+type ExecWriter interface {
+	Write(result *ExecResult) error
+	Flush() error
+}
+
+// NewExecWriter creates an exec result writer for the output format
+func NewExecWriter(format string, w io.Writer) (ExecWriter, error) {
+	switch format {
+	case ExecOutputPrefixed:
+		return &prefixedExecWriter{output: install.NewOutput(w, false)}, nil
+	case ExecOutputGrouped:
+		return &bufferedExecWriter{w: w, flush: writeGrouped}, nil
+	case OutputJSON:
+		return &bufferedExecWriter{w: w, flush: writeExecJSON}, nil
+	case OutputNDJSON:
+		return &ndjsonExecWriter{encoder: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %s, supported formats: %v", format, ExecOutputFormats)
+	}
+}
+
+// prefixedExecWriter writes each line prefixed with the hostname
+type prefixedExecWriter struct {
+	output *install.Output
+}
+
+func (p *prefixedExecWriter) Write(result *ExecResult) error {
+	out := p.output.Node(result.Hostname)
+	_, _ = io.WriteString(out, result.Stdout)
+	out.Flush()
+	_, _ = io.WriteString(out, result.Stderr)
+	out.Flush()
+	if !result.Success() {
+		out.Status("Failed: %s", result.Error)
+	}
+	return nil
+}
+
+func (p *prefixedExecWriter) Flush() error {
+	return nil
+}
+
+type ndjsonExecWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonExecWriter) Write(result *ExecResult) error {
+	return n.encoder.Encode(result)
+}
+
+func (n *ndjsonExecWriter) Flush() error {
+	return nil
+}
+
+// bufferedExecWriter keeps all results and writes them in node order on Flush
+type bufferedExecWriter struct {
+	w       io.Writer
+	results []*ExecResult
+	flush   func(w io.Writer, results []*ExecResult) error
+}
+
+func (b *bufferedExecWriter) Write(result *ExecResult) error {
+	b.results = append(b.results, result)
+	return nil
+}
+
+func (b *bufferedExecWriter) Flush() error {
+	sortExecResults(b.results)
+	if b.results == nil {
+		b.results = []*ExecResult{}
+	}
+	return b.flush(b.w, b.results)
+}
+
+func writeExecJSON(w io.Writer, results []*ExecResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// writeGrouped writes the output of each node under a header with the exit status
+func writeGrouped(w io.Writer, results []*ExecResult) error {
+	for i, r := range results {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		header := fmt.Sprintf("=== %s (%s) exit status %d", r.Hostname, r.Address, r.ExitStatus)
+		if r.ExitStatus == client.ExitStatusUnknown {
+			header = fmt.Sprintf("=== %s (%s) failed", r.Hostname, r.Address)
+		}
+		if _, err := fmt.Fprintln(w, header); err != nil {
+			return err
+		}
+		for _, out := range []string{r.Stdout, r.Stderr} {
+			if len(out) == 0 {
+				continue
+			}
+			if _, err := io.WriteString(w, strings.TrimSuffix(out, "\n")+"\n"); err != nil {
+				return err
+			}
+		}
+		if !r.Success() && r.ExitStatus == client.ExitStatusUnknown {
+			if _, err := fmt.Fprintln(w, r.Error); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/TheNatureOfSoftware/k3pi/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestExec(t *testing.T) {
+	// the fake clients share one script, commands are run one node at the time
+	cf, script := client.NewFakeClientFactory()
+	script.Expect("sudo sh -c 'df -h / | tail -n 1'", "/dev/mmcblk0p2   29G  2.1G   26G   8% /")
+	script.Expect("sudo sh -c 'df -h / | tail -n 1'", "/dev/mmcblk0p2   29G  3.4G   25G  12% /")
+	script.FailDial("10.0.0.3", -1)
+	nodes := test.CreateNodes()
+
+	results, err := Exec(context.Background(), cf, &ExecArgs{Nodes: nodes, Command: "df -h / | tail -n 1", Sudo: true, Concurrency: 1})
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, []string{"node1", "node2", "node3"}, []string{results[0].Hostname, results[1].Hostname, results[2].Hostname})
+	assert.True(t, results[0].Success())
+	assert.Equal(t, "/dev/mmcblk0p2   29G  3.4G   25G  12% /", results[1].Stdout)
+	assert.Equal(t, client.ExitStatusUnknown, results[2].ExitStatus)
+	assert.Equal(t, "dial tcp 10.0.0.3:22: connect: connection refused", results[2].Error)
+	assert.False(t, script.HasOutstandingCmds())
+
+	selector, _ := model.ParseSelector("hostname=node2")
+	script.FailCmd(`uptime`, 127, "sh: uptime: not found")
+	results, err = Exec(context.Background(), cf, &ExecArgs{Nodes: nodes, Selector: selector, Command: "uptime"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 127, results[0].ExitStatus)
+	assert.Equal(t, "sh: uptime: not found", results[0].Stderr)
+	assert.False(t, results[0].Success())
+}
+
+func TestExec_MultiLine(t *testing.T) {
+	cf, script := client.NewFakeClientFactory()
+
+	for _, sudo := range []bool{false, true} {
+		results, err := Exec(context.Background(), cf, &ExecArgs{Nodes: test.CreateNodes(), Command: "cd /var/log\nls", Sudo: sudo})
+		assert.EqualError(t, err, "multi-line commands are not supported, separate commands with ';' or '&&'")
+		assert.Empty(t, results)
+	}
+	assert.Empty(t, script.InvokedCmds)
+
+	script.Expect("uptime", "up 3 days")
+	results, err := Exec(context.Background(), cf, &ExecArgs{Nodes: test.CreateNodes()[:1], Command: "uptime\n"})
+	assert.NoError(t, err)
+	assert.Equal(t, "up 3 days", results[0].Stdout)
+}
+
+func TestExecFunc_Streaming(t *testing.T) {
+	cf, script := client.NewFakeClientFactory()
+	nodes := test.CreateNodes()
+	firstDone := make(chan struct{})
+	blocked := false
+	create := cf.Create
+	cf.Create = func(ctx context.Context, auth *model.Auth, address *model.Address) (client.Client, error) {
+		if address.IP == nodes[1].Address.IP {
+			// node1 must be passed on while the command waits to be started on the next nodes
+			select {
+			case <-firstDone:
+			case <-time.After(time.Second):
+				blocked = true
+			}
+		}
+		return create(ctx, auth, address)
+	}
+	script.Expect("uptime", "up 3 days")
+
+	var finished []string
+	err := ExecFunc(context.Background(), cf, &ExecArgs{Nodes: nodes, Command: "uptime", Concurrency: 1}, func(result *ExecResult) {
+		if len(finished) == 0 {
+			close(firstDone)
+		}
+		finished = append(finished, result.Hostname)
+	})
+
+	assert.NoError(t, err)
+	assert.False(t, blocked, "results should be passed on as nodes finish")
+	assert.Equal(t, []string{"node1", "node2", "node3"}, finished)
+}
+
+func TestExec_Interrupted(t *testing.T) {
+	cf, _ := client.NewFakeClientFactory()
+	stop := make(chan struct{})
+	close(stop)
+
+	results, err := Exec(misc.WithStop(context.Background(), stop), cf, &ExecArgs{Nodes: test.CreateNodes(), Command: "uptime"})
+	assert.EqualError(t, err, "exec interrupted, command started on 0 of 3 nodes")
+	assert.Empty(t, results)
+}
+
+func TestExecWriter(t *testing.T) {
+	results := []*ExecResult{
+		{Hostname: "node2", Address: "10.0.0.2", ExitStatus: 1, Stderr: "df: /data: No such file or directory\n", Error: "command 'df -h /data' exited with status 1", index: 1},
+		{Hostname: "node1", Address: "10.0.0.1", Stdout: "/dev/sda1 100G\n", index: 0},
+		{Hostname: "node3", Address: "10.0.0.3", ExitStatus: client.ExitStatusUnknown, Error: "connection refused", index: 2},
+	}
+	write := func(format string) string {
+		var b bytes.Buffer
+		w, err := NewExecWriter(format, &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range results {
+			assert.NoError(t, w.Write(r))
+		}
+		assert.NoError(t, w.Flush())
+		return b.String()
+	}
+
+	assert.Equal(t, `node2 | df: /data: No such file or directory
+node2 | Failed: command 'df -h /data' exited with status 1
+node1 | /dev/sda1 100G
+node3 | Failed: connection refused
+`, write(ExecOutputPrefixed))
+
+	assert.Equal(t, `=== node1 (10.0.0.1) exit status 0
+/dev/sda1 100G
+
+=== node2 (10.0.0.2) exit status 1
+df: /data: No such file or directory
+
+=== node3 (10.0.0.3) failed
+connection refused
+`, write(ExecOutputGrouped))
+
+	assert.Contains(t, write(OutputJSON), `"hostname": "node1"`)
+	assert.Contains(t, write(OutputNDJSON), `{"hostname":"node2","address":"10.0.0.2","exit_status":1,`)
+
+	_, err := NewExecWriter("table", &bytes.Buffer{})
+	assert.Error(t, err)
+}
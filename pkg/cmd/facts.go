@@ -0,0 +1,183 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package cmd handles k3pi use cases
+package cmd
+
+import (
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	urlRegexp        = regexp.MustCompile(`https?://[^\s"']+`)
+	k3sProcessRegexp = regexp.MustCompile(`k3s[ -](server|agent)`)
+	serverArgRegexp  = regexp.MustCompile(`--server[ =](\S+)`)
+)
+
+// factsScript prints one key=value per line, every line is run in the same remote session
+var factsScript = []string{
+	`echo "arch=$(uname -m)"`,
+	`echo "hostname=$(cat /etc/hostname)"`,
+	`echo "kernel=$(uname -r)"`,
+	`echo "os=$(. /etc/os-release 2>/dev/null && echo $PRETTY_NAME)"`,
+	`echo "os_id=$(. /etc/os-release 2>/dev/null && echo $ID)"`,
+	`echo "os_version=$(. /etc/os-release 2>/dev/null && echo $VERSION_ID)"`,
+	`echo "k3os_system=$([ -d /k3os/system ] && echo yes)"`,
+	`echo "k3s_version=$( (k3s --version || /k3os/system/k3s/current/k3s --version) 2>/dev/null | head -n 1)"`,
+	`echo "k3s_process=$( (ps -eo args 2>/dev/null || ps) | grep -E 'k3s[ -](server|agent)' | grep -v grep | head -n 1)"`,
+	`echo "k3s_server_url=$(grep -hsE 'server_url:|K3S_URL=' /k3os/system/config.yaml /var/lib/rancher/k3os/config.yaml /etc/systemd/system/k3s-agent.service.env | head -n 1)"`,
+	`echo "model=$(tr -d '\000' < /proc/device-tree/model 2>/dev/null)"`,
+	`echo "serial=$(tr -d '\000' < /proc/device-tree/serial-number 2>/dev/null || awk '/^Serial/ {print $3}' /proc/cpuinfo)"`,
+	`echo "cpus=$(grep -c ^processor /proc/cpuinfo)"`,
+	`echo "mem=$(awk '/^MemTotal/ {print $2}' /proc/meminfo)"`,
+	`dev=$(basename "$(awk '$2 == "/" {print $1}' /proc/mounts | tail -n 1)")`,
+	`if [ -e /sys/class/block/$dev/partition ]; then dev=$(basename "$(readlink -f /sys/class/block/$dev/..)"); fi`,
+	`echo "disk=$dev"`,
+	`echo "disk_size=$(cat /sys/class/block/$dev/size 2>/dev/null)"`,
+	`echo "disk_path=$(readlink -f /sys/class/block/$dev 2>/dev/null)"`,
+	// only physical NICs, virtual interfaces like cni0, flannel.1 and veth* come and go with pods
+	`for i in /sys/class/net/*; do [ -e "$i/device" ] || continue; echo "mac=${i##*/} $(cat $i/address)"; done`,
+}
+
+// factsCmd gathers all facts needed by the scan in a single remote session
+var factsCmd = strings.Join(factsScript, "; ")
+
+// parseFacts parses the output of factsCmd, returns a node with hostname, arch and facts set
+func parseFacts(output []byte) *model.Node {
+	node := &model.Node{}
+	facts := &model.Facts{}
+	disk := &model.Disk{}
+	k3s := &model.K3s{}
+	var diskPath, osID, osVersion, k3osSystem string
+
+	for _, line := range strings.Split(string(output), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], strings.TrimSpace(kv[1])
+		switch key {
+		case "arch":
+			node.Arch = value
+		case "hostname":
+			node.Hostname = value
+		case "kernel":
+			facts.KernelVersion = value
+		case "os":
+			facts.OSRelease = value
+		case "os_id":
+			osID = value
+		case "os_version":
+			osVersion = value
+		case "k3os_system":
+			k3osSystem = value
+		case "k3s_version":
+			k3s.Version = k3sVersion(value)
+		case "k3s_process":
+			if m := k3sProcessRegexp.FindStringSubmatch(value); m != nil {
+				k3s.Role = m[1]
+			}
+			if m := serverArgRegexp.FindStringSubmatch(value); m != nil {
+				k3s.ServerURL = m[1]
+			}
+		case "k3s_server_url":
+			if url := urlRegexp.FindString(value); len(url) > 0 && len(k3s.ServerURL) == 0 {
+				k3s.ServerURL = url
+			}
+		case "model":
+			facts.Model = value
+		case "serial":
+			facts.SerialNumber = value
+		case "cpus":
+			facts.CPUs, _ = strconv.Atoi(value)
+		case "mem":
+			kb, _ := strconv.ParseUint(value, 10, 64)
+			facts.Memory = kb * 1024
+		case "disk":
+			disk.Name = value
+		case "disk_size":
+			sectors, _ := strconv.ParseUint(value, 10, 64)
+			disk.Size = sectors * 512
+		case "disk_path":
+			diskPath = value
+		case "mac":
+			parts := strings.Fields(value)
+			if len(parts) == 2 {
+				if facts.MACAddresses == nil {
+					facts.MACAddresses = make(map[string]string)
+				}
+				facts.MACAddresses[parts[0]] = parts[1]
+			}
+		}
+	}
+
+	if osID == "k3os" {
+		facts.K3OSVersion = osVersion
+	} else if k3osSystem == "yes" {
+		facts.K3OSVersion = "unknown"
+	}
+
+	if len(k3s.Version) > 0 || len(k3s.Role) > 0 {
+		if len(k3s.Role) == 0 && len(k3s.ServerURL) > 0 {
+			k3s.Role = model.K3sRoleAgent
+		}
+		if k3s.Role == model.K3sRoleServer {
+			k3s.ServerURL = ""
+		}
+		facts.K3s = k3s
+	}
+
+	if disk.Size > 0 {
+		disk.Type = diskType(disk.Name, diskPath)
+		facts.RootDisk = disk
+	}
+	node.Facts = facts
+
+	return node
+}
+
+// k3sVersion parses the version from 'k3s version v1.17.2+k3s1 (cdab19b0)'
+func k3sVersion(s string) string {
+	for _, f := range strings.Fields(s) {
+		if strings.HasPrefix(f, "v") && len(f) > 1 && f[1] >= '0' && f[1] <= '9' {
+			return f
+		}
+	}
+	return ""
+}
+
+// diskType resolves the disk type from the device name and its sysfs path
+func diskType(name, sysfsPath string) string {
+	switch {
+	case strings.HasPrefix(name, "mmcblk"):
+		return model.DiskTypeSD
+	case strings.HasPrefix(name, "nvme"):
+		return model.DiskTypeNVMe
+	case strings.Contains(sysfsPath, "/usb"):
+		return model.DiskTypeUSB
+	default:
+		return model.DiskTypeOther
+	}
+}
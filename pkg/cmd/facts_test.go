@@ -0,0 +1,111 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var factsOutput = `arch=aarch64
+hostname=ubuntu
+kernel=5.3.0-1017-raspi2
+os=Ubuntu 19.10
+model=Raspberry Pi 4 Model B Rev 1.1
+serial=10000000a3b2c1d0
+cpus=4
+mem=3884376
+disk=mmcblk0
+disk_size=62333952
+disk_path=/sys/devices/platform/emmc2bus/fe340000.emmc2/mmc_host/mmc0/mmc0:aaaa/block/mmcblk0
+mac=eth0 dc:a6:32:00:00:01
+mac=wlan0 dc:a6:32:00:00:02
+`
+
+func TestParseFacts(t *testing.T) {
+	node := parseFacts([]byte(factsOutput))
+
+	assert.Equal(t, "aarch64", node.Arch)
+	assert.Equal(t, "ubuntu", node.Hostname)
+	assert.Equal(t, "Raspberry Pi 4 Model B Rev 1.1", node.Facts.Model)
+	assert.Equal(t, "10000000a3b2c1d0", node.Facts.SerialNumber)
+	assert.Equal(t, 4, node.Facts.CPUs)
+	assert.Equal(t, uint64(3884376*1024), node.Facts.Memory)
+	assert.Equal(t, "Ubuntu 19.10", node.Facts.OSRelease)
+	assert.Equal(t, "5.3.0-1017-raspi2", node.Facts.KernelVersion)
+	assert.Equal(t, &model.Disk{Name: "mmcblk0", Size: 62333952 * 512, Type: model.DiskTypeSD}, node.Facts.RootDisk)
+	assert.Equal(t, map[string]string{"eth0": "dc:a6:32:00:00:01", "wlan0": "dc:a6:32:00:00:02"}, node.Facts.MACAddresses)
+}
+
+func TestParseFacts_NoRootDisk(t *testing.T) {
+	node := parseFacts([]byte("arch=armv7l\nhostname=pi\ndisk=overlay\ndisk_size=\n"))
+
+	assert.Equal(t, "armv7l", node.Arch)
+	assert.Nil(t, node.Facts.RootDisk)
+}
+
+func TestDiskType(t *testing.T) {
+	assert.Equal(t, model.DiskTypeSD, diskType("mmcblk0", ""))
+	assert.Equal(t, model.DiskTypeNVMe, diskType("nvme0n1", ""))
+	assert.Equal(t, model.DiskTypeUSB, diskType("sda", "/sys/devices/platform/scb/fd500000.pcie/pci0000:00/0000:01:00.0/usb2/2-1/2-1:1.0/host0/target0:0:0/0:0:0:0/block/sda"))
+	assert.Equal(t, model.DiskTypeOther, diskType("sda", "/sys/devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0/block/sda"))
+}
+
+func TestParseFacts_K3OSAgent(t *testing.T) {
+	output := `arch=aarch64
+hostname=k3s-node2
+os=k3OS v0.9.0
+os_id=k3os
+os_version=v0.9.0
+k3os_system=yes
+k3s_version=k3s version v1.17.2+k3s1 (cdab19b0)
+k3s_process=/usr/local/bin/k3s agent --node-ip 192.168.1.12
+k3s_server_url=  server_url: https://192.168.1.11:6443
+`
+	node := parseFacts([]byte(output))
+
+	assert.True(t, node.IsInstalled())
+	assert.Equal(t, "v0.9.0", node.Facts.K3OSVersion)
+	assert.Equal(t, &model.K3s{Version: "v1.17.2+k3s1", Role: model.K3sRoleAgent, ServerURL: "https://192.168.1.11:6443"}, node.Facts.K3s)
+}
+
+func TestParseFacts_K3sServer(t *testing.T) {
+	output := `arch=armv7l
+os_id=raspbian
+k3s_version=k3s version v1.17.2+k3s1 (cdab19b0)
+k3s_process=k3s server
+k3os_system=
+`
+	node := parseFacts([]byte(output))
+
+	assert.True(t, node.IsInstalled())
+	assert.Empty(t, node.Facts.K3OSVersion)
+	assert.Equal(t, &model.K3s{Version: "v1.17.2+k3s1", Role: model.K3sRoleServer}, node.Facts.K3s)
+}
+
+func TestParseFacts_NotInstalled(t *testing.T) {
+	node := parseFacts([]byte("arch=aarch64\nos_id=ubuntu\nk3os_system=\nk3s_version=\nk3s_process=\n"))
+
+	assert.False(t, node.IsInstalled())
+	assert.Nil(t, node.Facts.K3s)
+}
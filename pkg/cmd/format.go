@@ -0,0 +1,262 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package cmd handles k3pi use cases
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/dustin/go-humanize"
+	"github.com/kubernetes-sigs/yaml"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"unicode"
+)
+
+// Output formats
+const (
+	OutputYAML    = "yaml"
+	OutputJSON    = "json"
+	OutputNDJSON  = "ndjson"
+	OutputTable   = "table"
+	OutputCSV     = "csv"
+	OutputAnsible = "ansible"
+)
+
+// OutputFormats all supported output formats
+var OutputFormats = []string{OutputYAML, OutputJSON, OutputNDJSON, OutputTable, OutputCSV, OutputAnsible}
+
+// NodeWriter writes nodes in an output format. Write is called for every node as it's found
+// and Flush when all nodes are found.
+type NodeWriter interface {
+	Write(node *model.Node) error
+	Flush() error
+}
+
+// NewNodeWriter creates a node writer for the output format
+func NewNodeWriter(format string, w io.Writer) (NodeWriter, error) {
+	switch format {
+	case OutputNDJSON:
+		return &ndjsonWriter{encoder: json.NewEncoder(w)}, nil
+	case OutputYAML:
+		return &bufferedWriter{w: w, flush: writeYAML}, nil
+	case OutputJSON:
+		return &bufferedWriter{w: w, flush: writeJSON}, nil
+	case OutputTable:
+		return &bufferedWriter{w: w, flush: writeTable}, nil
+	case OutputCSV:
+		return &bufferedWriter{w: w, flush: writeCSV}, nil
+	case OutputAnsible:
+		return &bufferedWriter{w: w, flush: writeAnsible}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %s, supported formats: %v", format, OutputFormats)
+	}
+}
+
+// WriteNodes writes nodes in yaml or json format keeping their order
+func WriteNodes(format string, w io.Writer, nodes model.Nodes) error {
+	values := []model.Node{}
+	for _, n := range nodes {
+		values = append(values, *n)
+	}
+	switch format {
+	case OutputYAML:
+		return writeYAML(w, values)
+	case OutputJSON:
+		return writeJSON(w, values)
+	default:
+		return fmt.Errorf("unsupported format for writing nodes: %s", format)
+	}
+}
+
+// ReadNodes reads nodes written in yaml, json or ndjson format. ndjson is decoded one node at
+// the time so nodes can be piped from a running scan.
+func ReadNodes(r io.Reader) (model.Nodes, error) {
+	reader := bufio.NewReader(r)
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if !unicode.IsSpace(rune(b[0])) {
+			break
+		}
+		_, _ = reader.ReadByte()
+	}
+
+	var nodes model.Nodes
+	if b, _ := reader.Peek(1); b[0] == '{' {
+		decoder := json.NewDecoder(reader)
+		for {
+			node := &model.Node{}
+			if err := decoder.Decode(node); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		}
+		return nodes, nil
+	}
+
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(b, &nodes)
+	return nodes, err
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(node *model.Node) error {
+	return n.encoder.Encode(node)
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
+
+// bufferedWriter collects all nodes and writes them sorted by IP-address on flush
+type bufferedWriter struct {
+	w     io.Writer
+	nodes []model.Node
+	flush func(w io.Writer, nodes []model.Node) error
+}
+
+func (b *bufferedWriter) Write(node *model.Node) error {
+	b.nodes = append(b.nodes, *node)
+	return nil
+}
+
+func (b *bufferedWriter) Flush() error {
+	sort.SliceStable(b.nodes, func(i, j int) bool {
+		return compareIP(b.nodes[i].Address.IP, b.nodes[j].Address.IP) < 0
+	})
+	if b.nodes == nil {
+		b.nodes = []model.Node{}
+	}
+	return b.flush(b.w, b.nodes)
+}
+
+func writeYAML(w io.Writer, nodes []model.Node) error {
+	out, err := yaml.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func writeJSON(w io.Writer, nodes []model.Node) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(nodes)
+}
+
+func writeTable(w io.Writer, nodes []model.Node) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "HOSTNAME\tIP\tARCH\tMODEL\tMEMORY\tUSER")
+	for _, n := range nodes {
+		boardModel, memory := "-", "-"
+		if n.Facts != nil {
+			if len(n.Facts.Model) > 0 {
+				boardModel = n.Facts.Model
+			}
+			if n.Facts.Memory > 0 {
+				memory = humanize.IBytes(n.Facts.Memory)
+			}
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", n.Hostname, n.Address.IP, n.Arch, boardModel, memory, n.Auth.User)
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, nodes []model.Node) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"hostname", "ip", "port", "arch", "model", "serial", "cpus", "memory", "auth_type", "user"})
+	for _, n := range nodes {
+		facts := n.Facts
+		if facts == nil {
+			facts = &model.Facts{}
+		}
+		_ = cw.Write([]string{
+			n.Hostname,
+			n.Address.IP,
+			strconv.Itoa(n.Address.Port),
+			n.Arch,
+			facts.Model,
+			facts.SerialNumber,
+			strconv.Itoa(facts.CPUs),
+			strconv.FormatUint(facts.Memory, 10),
+			n.Auth.Type,
+			n.Auth.User,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeAnsible writes an Ansible YAML inventory, hosts are keyed by IP-address since scanned
+// nodes often share the same hostname
+func writeAnsible(w io.Writer, nodes []model.Node) error {
+	hosts := make(map[string]map[string]interface{})
+	for _, n := range nodes {
+		vars := map[string]interface{}{
+			"ansible_host":  n.Address.IP,
+			"ansible_port":  n.Address.Port,
+			"ansible_user":  n.Auth.User,
+			"k3pi_hostname": n.Hostname,
+			"k3pi_arch":     n.Arch,
+		}
+		if n.Auth.Type == model.AuthTypeSSHKey {
+			vars["ansible_ssh_private_key_file"] = n.Auth.SSHKey
+		} else {
+			vars["ansible_password"] = n.Auth.Password
+		}
+		hosts[n.Address.IP] = vars
+	}
+
+	inventory := map[string]interface{}{
+		"all": map[string]interface{}{
+			"hosts": hosts,
+		},
+	}
+	out, err := yaml.Marshal(inventory)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/TheNatureOfSoftware/k3pi/test"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func writeNodes(t *testing.T, format string, nodes model.Nodes) string {
+	var b bytes.Buffer
+	writer, err := NewNodeWriter(format, &b)
+	assert.NoError(t, err)
+	for _, node := range nodes {
+		assert.NoError(t, writer.Write(node))
+	}
+	assert.NoError(t, writer.Flush())
+	return b.String()
+}
+
+func TestNewNodeWriter_UnknownFormat(t *testing.T) {
+	_, err := NewNodeWriter("xml", &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestNodeWriter_ReadBack(t *testing.T) {
+	nodes := test.CreateNodes()
+	for _, format := range []string{OutputYAML, OutputJSON, OutputNDJSON} {
+		out := writeNodes(t, format, nodes)
+		actual, err := ReadNodes(strings.NewReader(out))
+		assert.NoError(t, err, format)
+		assert.Equal(t, nodes, actual, format)
+	}
+}
+
+func TestNodeWriter_SortedByIP(t *testing.T) {
+	nodes := test.CreateNodes()
+	nodes[0].Address.IP = "10.0.0.10"
+
+	out := writeNodes(t, OutputJSON, nodes)
+	actual, err := ReadNodes(strings.NewReader(out))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.3", "10.0.0.10"}, actual.IPAddresses())
+}
+
+func TestNodeWriter_NDJSON(t *testing.T) {
+	out := writeNodes(t, OutputNDJSON, test.CreateNodes())
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], `{"hostname":"node1"`))
+}
+
+func TestNodeWriter_Table(t *testing.T) {
+	nodes := test.CreateNodes()
+	nodes[0].Facts = &model.Facts{Model: "Raspberry Pi 4 Model B Rev 1.1", Memory: 4 << 30}
+
+	lines := strings.Split(strings.TrimSpace(writeNodes(t, OutputTable, nodes)), "\n")
+
+	assert.Len(t, lines, 4)
+	assert.Equal(t, []string{"HOSTNAME", "IP", "ARCH", "MODEL", "MEMORY", "USER"}, strings.Fields(lines[0]))
+	assert.Contains(t, lines[1], "Raspberry Pi 4 Model B Rev 1.1")
+	assert.Contains(t, lines[1], "4.0 GiB")
+}
+
+func TestNodeWriter_CSV(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(writeNodes(t, OutputCSV, test.CreateNodes())), "\n")
+
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "node1,10.0.0.1,22,aarch64,,,0,0,ssh-key,test", lines[1])
+}
+
+func TestNodeWriter_Ansible(t *testing.T) {
+	out := writeNodes(t, OutputAnsible, test.CreateNodes())
+
+	assert.Contains(t, out, "all:\n  hosts:\n    10.0.0.1:\n")
+	assert.Contains(t, out, "ansible_ssh_private_key_file: ~/.ssh/id_rsa")
+	assert.Contains(t, out, "k3pi_hostname: node1")
+}
+
+func TestReadNodes_Empty(t *testing.T) {
+	nodes, err := ReadNodes(strings.NewReader(" \n"))
+	assert.NoError(t, err)
+	assert.Len(t, nodes, 0)
+}
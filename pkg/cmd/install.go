@@ -24,6 +24,7 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/install"
@@ -44,19 +45,70 @@ const (
 type InstallArgs struct {
 	model.Nodes
 	model.SSHKeys
-	Token, ServerID string
+	// TrustedUserCAKeys CA public keys, nodes accept ssh user certificates signed by these CAs
+	TrustedUserCAKeys []string
+	Token, ServerID   string
 	*install.HostnameSpec
-	DryRun, Confirmed bool
-	Templates         *install.ConfigTemplates
-	K3OSVersion       string
+	DryRun, Confirmed, Force bool
+	// Quiet only shows the last lines of command output for failed nodes
+	Quiet         bool
+	Templates     *install.ConfigTemplates
+	K3OSVersion   string
+	Selector      model.Selector
+	Architectures []string
+	// ResourceDir directory with the k3OS images, reused and kept if set, else a temp directory is
+	// created and removed by Install
+	ResourceDir string
+	// Save saves the nodes when they are installed, e.g. to keep cleared host keys, not called if nil
+	Save func(nodes model.Nodes) error
+	// NodeAuth ssh key and certificate for logging in to installed nodes as rancher, the
+	// default key is used if nil
+	NodeAuth *model.Auth
 }
 
-// Install installs k3os on all nodes.
-func Install(args *InstallArgs) error {
+// nodeAuth auth for logging in to the installed node, keeps the node's jump host
+func (args *InstallArgs) nodeAuth(node *model.Node) model.Auth {
+	auth := model.Auth{Type: model.AuthTypeSSHKey, SSHKey: "~/.ssh/id_rsa"}
+	if args.NodeAuth != nil {
+		auth = *args.NodeAuth
+	}
+	auth.User = "rancher"
+	auth.ProxyJump = node.Auth.ProxyJump
+	return auth
+}
+
+// Install installs k3os on all nodes. No new nodes are installed when ctx is stopped, see misc.Stopping.
+func Install(ctx context.Context, args *InstallArgs) error {
+
+	var nodes model.Nodes
+	for _, n := range args.Nodes.Select(args.Selector) {
+		if n.Vanished {
+			misc.Info(fmt.Sprintf("Skipping vanished node:\t%s (%s)", n.Hostname, n.Address))
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("no nodes matching selector: %s", args.Selector)
+	}
+
+	architectures := args.Architectures
+	if len(architectures) == 0 {
+		architectures = model.DefaultArchitectures
+	}
+	for _, n := range nodes {
+		if !n.HasArch(architectures) {
+			return fmt.Errorf("architecture %s of node %s (%s) is not one of %v", n.Arch, n.Hostname, n.Address.IP, architectures)
+		}
+	}
 
-	generateHostname(args.Nodes, args.HostnameSpec)
+	if installed := installedNodes(nodes); len(installed) > 0 && !args.Force {
+		return fmt.Errorf("k3OS or k3s is already installed on: %s, use --force to overwrite", strings.Join(installed, ", "))
+	}
 
-	serverNode, agentNodes, err := SelectServerAndAgents(args.Nodes, args.ServerID)
+	generateHostname(nodes, args.HostnameSpec)
+
+	serverNode, agentNodes, err := SelectServerAndAgents(nodes, args.ServerID)
 	misc.PanicOnError(err, "failed to resolve server and agents")
 
 	if serverNode != nil {
@@ -76,6 +128,13 @@ func Install(args *InstallArgs) error {
 		return fmt.Sprintf("%s (%s)", n.Hostname, n.Address)
 	})))
 
+	clientFactory := client.NewPooledClientFactory()
+	defer clientFactory.Close()
+
+	if err = install.CheckSudo(ctx, clientFactory, nodes); err != nil {
+		return err
+	}
+
 	if !args.Confirmed {
 		if misc.DataPipedIn() {
 			return fmt.Errorf("install needs to be confirmed (--yes|-y)")
@@ -90,9 +149,11 @@ func Install(args *InstallArgs) error {
 
 	var serverTarget *model.K3OSNode
 	agentTargets := model.NewK3OSNodes(agentNodes, args.SSHKeys, token)
+	agentTargets.SetTrustedUserCAKeys(args.TrustedUserCAKeys)
 
 	if serverNode != nil {
 		serverTarget = model.NewK3OSNode(serverNode, args.SSHKeys, token)
+		serverTarget.TrustedUserCAKeys = args.TrustedUserCAKeys
 		agentTargets.SetServerIP(serverNode.Address.IP)
 	} else {
 		serverIP := net.ParseIP(args.ServerID)
@@ -108,15 +169,22 @@ func Install(args *InstallArgs) error {
 				DryRun: args.DryRun,
 			},
 			Version:       args.K3OSVersion,
-			ClientFactory: client.NewClientFactory(),
+			ClientFactory: clientFactory,
 		},
 		Server:    serverTarget,
 		Agents:    agentTargets,
 		Templates: args.Templates,
 	}
 
-	resourceDir := install.MakeResourceDir(installTask)
-	defer os.RemoveAll(resourceDir)
+	resourceDir := args.ResourceDir
+	if len(resourceDir) == 0 {
+		if resourceDir, err = install.MakeResourceDir(installTask); err != nil {
+			return err
+		}
+		defer os.RemoveAll(resourceDir)
+	} else if err = install.FetchResources(resourceDir, installTask); err != nil {
+		return err
+	}
 
 	factory := installerFactories.GetFactory(installTask)
 	if factory == nil {
@@ -125,39 +193,50 @@ func Install(args *InstallArgs) error {
 
 	installers := factory.MakeInstallers(installTask, resourceDir)
 
-	err = install.Run(installers)
+	err = install.Run(ctx, installers, install.NewOutput(os.Stdout, args.Quiet))
 	if err != nil {
 		return err
 	}
 
+	if !args.DryRun {
+		// k3OS generates new host keys on first boot, the old keys are forgotten in case the nodes
+		// are not back before the program exits
+		for _, n := range nodes {
+			n.Address.HostKey = ""
+			client.DefaultHostKeyVerifier.ExpectChange(n.Address.IP)
+			if err = client.DefaultHostKeyVerifier.Forget(&n.Address); err != nil {
+				return fmt.Errorf("failed to forget host key for %s: %v", n.Address, err)
+			}
+		}
+		if args.Save != nil {
+			if err = args.Save(args.Nodes); err != nil {
+				return fmt.Errorf("failed to save nodes: %v", err)
+			}
+		}
+	}
+
 	if serverNode != nil && !args.DryRun {
 
-		serverNode.Auth = model.Auth{
-			Type:   model.AuthTypeSSHKey,
-			User:   "rancher",
-			SSHKey: "~/.ssh/id_rsa",
-		}
+		serverNode.Auth = args.nodeAuth(serverNode)
 
-		if err = install.WaitForNode(client.NewClientFactory(), serverNode, time.Second*120); err == nil {
+		if err = install.WaitForNode(ctx, clientFactory, serverNode, time.Second*120); err == nil {
 
-			var waitForNodeErr error
 			fmt.Printf("Waiting for kubeconfig ... ")
 			fn := misc.CreateTempFilename(".", "k3s-*.yaml")
 
-			for i := 0; i < 12; i++ {
-				waitForNodeErr = misc.CopyKubeconfig(fn, serverNode)
-				if waitForNodeErr != nil {
-					time.Sleep(time.Second * 15)
-				} else {
-					fmt.Printf(" OK\n")
-					fmt.Printf(" Saved to: %s\n", fn)
-					break
-				}
+			if err = install.FetchKubeconfig(ctx, clientFactory, fn, serverNode); err != nil {
+				fmt.Printf(" Failed\n")
+				return err
 			}
-			if waitForNodeErr != nil {
+			fmt.Printf(" OK\n")
+			fmt.Printf(" Saved to: %s\n", fn)
+
+			fmt.Printf("Waiting for k3s API ... ")
+			if err = install.WaitForAPI(ctx, serverNode, time.Second*120); err != nil {
 				fmt.Printf(" Failed\n")
-				return waitForNodeErr
+				return err
 			}
+			fmt.Printf(" OK\n")
 		} else {
 			return err
 		}
@@ -166,6 +245,25 @@ func Install(args *InstallArgs) error {
 	return nil
 }
 
+// installedNodes describes all nodes where the scan found k3OS or k3s
+func installedNodes(nodes model.Nodes) []string {
+	var installed []string
+	for _, n := range nodes {
+		if !n.IsInstalled() {
+			continue
+		}
+		var found []string
+		if v := n.Facts.K3OSVersion; len(v) > 0 {
+			found = append(found, fmt.Sprintf("k3OS %s", v))
+		}
+		if k3s := n.Facts.K3s; k3s != nil {
+			found = append(found, strings.TrimSpace(fmt.Sprintf("k3s %s %s", k3s.Version, k3s.Role)))
+		}
+		installed = append(installed, fmt.Sprintf("%s (%s, %s)", n.Hostname, n.Address.IP, strings.Join(found, ", ")))
+	}
+	return installed
+}
+
 func generateHostname(nodes model.Nodes, spec *install.HostnameSpec) {
 	for i, n := range nodes {
 		n.Hostname = spec.GetHostname(i + 1)
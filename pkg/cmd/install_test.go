@@ -22,8 +22,11 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/TheNatureOfSoftware/k3pi/test"
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
@@ -101,3 +104,29 @@ func TestSelectServerAndAgents_Match_Address(t *testing.T) {
 		t.Errorf("expected %d agents, actual: %d", expectedAgentCount, actual)
 	}
 }
+
+func TestInstall_RefuseInstalledNodes(t *testing.T) {
+	nodes := test.CreateNodes()
+	nodes[1].Facts = &model.Facts{K3OSVersion: "v0.9.0", K3s: &model.K3s{Version: "v1.17.2+k3s1", Role: model.K3sRoleAgent}}
+
+	err := Install(context.Background(), &InstallArgs{Nodes: nodes, ServerID: nodes[0].Address.IP})
+
+	assert.EqualError(t, err, "k3OS or k3s is already installed on: node2 (10.0.0.2, k3OS v0.9.0, k3s v1.17.2+k3s1 agent), use --force to overwrite")
+}
+
+func TestInstall_NoNodesMatchingSelector(t *testing.T) {
+	selector, _ := model.ParseSelector("arch=amd64")
+
+	err := Install(context.Background(), &InstallArgs{Nodes: test.CreateNodes(), Selector: selector})
+
+	assert.EqualError(t, err, "no nodes matching selector: arch=amd64")
+}
+
+func TestInstall_UnsupportedArch(t *testing.T) {
+	nodes := test.CreateNodes()
+	nodes[2].Arch = "x86_64"
+
+	err := Install(context.Background(), &InstallArgs{Nodes: nodes, Architectures: []string{"arm64"}})
+
+	assert.EqualError(t, err, "architecture x86_64 of node node3 (10.0.0.3) is not one of [arm64]")
+}
@@ -0,0 +1,225 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package cmd handles k3pi use cases
+package cmd
+
+import (
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"io"
+	"reflect"
+)
+
+// Node change kinds
+const (
+	NodeAdded     = "+"
+	NodeUpdated   = "~"
+	NodeVanished  = "-"
+	NodeUnchanged = "="
+)
+
+// NodeChange a change to a node in the inventory
+type NodeChange struct {
+	Kind    string
+	Node    *model.Node
+	Details []string
+}
+
+// MergeResult the merged inventory and all changes made to it
+type MergeResult struct {
+	Nodes   model.Nodes
+	Changes []*NodeChange
+}
+
+// HasChanges returns true if the merge added, updated or flagged any node
+func (r *MergeResult) HasChanges() bool {
+	for _, c := range r.Changes {
+		if c.Kind != NodeUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintDiff prints all changes
+func (r *MergeResult) PrintDiff(w io.Writer) {
+	for _, c := range r.Changes {
+		if c.Kind == NodeUnchanged {
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s %s (%s)\n", c.Kind, c.Node.Hostname, c.Node.Address)
+		for _, d := range c.Details {
+			_, _ = fmt.Fprintf(w, "    %s\n", d)
+		}
+	}
+}
+
+// MergeNodes merges scanned nodes into an inventory. Nodes are matched on serial number, MAC-address and
+// last on IP-address. Matched nodes get address, credentials and facts updated while labels are kept,
+// new nodes are appended and inventory nodes covered by the scan request but not found are flagged as
+// vanished. A nil scan request covers all nodes.
+func MergeNodes(inventory model.Nodes, scanned model.Nodes, scanRequest *ScanRequest) *MergeResult {
+	result := &MergeResult{}
+	// scanned node -> inventory node and the reverse
+	matched := make(map[*model.Node]*model.Node)
+	matchedBy := make(map[*model.Node]*model.Node)
+
+	for _, strict := range []bool{true, false} {
+		for _, s := range scanned {
+			if _, ok := matched[s]; ok {
+				continue
+			}
+			for _, n := range inventory {
+				if _, ok := matchedBy[n]; ok {
+					continue
+				}
+				if sameNode(n, s, strict) {
+					matched[s] = n
+					matchedBy[n] = s
+					break
+				}
+			}
+		}
+	}
+
+	for _, n := range inventory {
+		merged := *n
+		var change *NodeChange
+		if s, ok := matchedBy[n]; ok {
+			change = updateNode(&merged, s)
+		} else if scanRequest != nil && !scanRequest.Covers(n) {
+			// e.g. outside the scanned range or filtered out by the selector
+			change = &NodeChange{Kind: NodeUnchanged}
+		} else {
+			change = &NodeChange{Kind: NodeUnchanged}
+			if !merged.Vanished {
+				change = &NodeChange{Kind: NodeVanished, Details: []string{"not found by scan"}}
+			}
+			merged.Vanished = true
+		}
+		change.Node = &merged
+		result.Nodes = append(result.Nodes, &merged)
+		result.Changes = append(result.Changes, change)
+	}
+
+	for _, s := range scanned {
+		if _, ok := matched[s]; !ok {
+			added := *s
+			result.Nodes = append(result.Nodes, &added)
+			result.Changes = append(result.Changes, &NodeChange{Kind: NodeAdded, Node: &added})
+		}
+	}
+
+	return result
+}
+
+// sameNode returns true if both nodes are the same board. In strict mode only serial numbers and
+// MAC-addresses are compared, otherwise the IP-address is used when neither is known.
+func sameNode(a, b *model.Node, strict bool) bool {
+	serialA, serialB := serialNumber(a), serialNumber(b)
+	if len(serialA) > 0 && len(serialB) > 0 {
+		return serialA == serialB
+	}
+
+	macsA, macsB := macAddresses(a), macAddresses(b)
+	if len(macsA) > 0 && len(macsB) > 0 {
+		for mac := range macsA {
+			if _, ok := macsB[mac]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return !strict && a.Address.IP == b.Address.IP
+}
+
+func serialNumber(n *model.Node) string {
+	if n.Facts == nil {
+		return ""
+	}
+	return n.Facts.SerialNumber
+}
+
+func macAddresses(n *model.Node) map[string]bool {
+	macs := make(map[string]bool)
+	if n.Facts != nil {
+		for _, mac := range n.Facts.MACAddresses {
+			macs[mac] = true
+		}
+	}
+	return macs
+}
+
+// updateNode updates an inventory node with what was found by the scan
+func updateNode(node *model.Node, scanned *model.Node) *NodeChange {
+	var details []string
+
+	address := scanned.Address
+	if len(address.HostKey) == 0 {
+		address.HostKey = node.Address.HostKey
+	}
+	if node.Address.String() != address.String() {
+		details = append(details, fmt.Sprintf("address: %s -> %s", node.Address, address))
+	}
+	if node.Address.HostKey != address.HostKey {
+		if len(node.Address.HostKey) == 0 {
+			details = append(details, "host key pinned")
+		} else {
+			details = append(details, "host key changed")
+		}
+	}
+	node.Address = address
+	auth := scanned.Auth
+	if len(auth.ProxyJump) == 0 {
+		auth.ProxyJump = node.Auth.ProxyJump
+	}
+	if len(auth.SudoPassword) == 0 {
+		auth.SudoPassword = node.Auth.SudoPassword
+	}
+	if !reflect.DeepEqual(node.Auth, auth) {
+		details = append(details, fmt.Sprintf("auth: %s (%s) -> %s (%s)", node.Auth.User, node.Auth.Type, auth.User, auth.Type))
+		node.Auth = auth
+	}
+	if node.Hostname != scanned.Hostname {
+		details = append(details, fmt.Sprintf("hostname: %s -> %s", node.Hostname, scanned.Hostname))
+		node.Hostname = scanned.Hostname
+	}
+	if node.Arch != scanned.Arch {
+		details = append(details, fmt.Sprintf("arch: %s -> %s", node.Arch, scanned.Arch))
+		node.Arch = scanned.Arch
+	}
+	if scanned.Facts != nil && !reflect.DeepEqual(node.Facts, scanned.Facts) {
+		details = append(details, "facts updated")
+		node.Facts = scanned.Facts
+	}
+	if node.Vanished {
+		details = append(details, "found again")
+		node.Vanished = false
+	}
+
+	if len(details) == 0 {
+		return &NodeChange{Kind: NodeUnchanged}
+	}
+	return &NodeChange{Kind: NodeUpdated, Details: details}
+}
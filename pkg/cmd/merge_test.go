@@ -0,0 +1,156 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/TheNatureOfSoftware/k3pi/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func withFacts(node *model.Node, serial string, macs ...string) *model.Node {
+	node.Facts = &model.Facts{SerialNumber: serial, MACAddresses: make(map[string]string)}
+	for i, mac := range macs {
+		node.Facts.MACAddresses[string(rune('a'+i))] = mac
+	}
+	return node
+}
+
+func TestMergeNodes_MatchOnSerial(t *testing.T) {
+	inventory := test.CreateNodes()
+	inventory[0].Labels = map[string]string{"zone": "shelf-1"}
+	withFacts(inventory[0], "serial-1")
+
+	scanned := test.CreateNodes()[:1]
+	withFacts(scanned[0], "serial-1")
+	scanned[0].Address = model.ParseAddress("10.0.0.10:22")
+	scanned[0].Auth = model.Auth{Type: model.AuthTypeSSHKey, User: "rancher", SSHKey: "~/.ssh/id_rsa"}
+
+	result := MergeNodes(inventory, scanned, nil)
+
+	assert.Len(t, result.Nodes, 3)
+	assert.Equal(t, NodeUpdated, result.Changes[0].Kind)
+	assert.Equal(t, "10.0.0.10", result.Nodes[0].Address.IP)
+	assert.Equal(t, "rancher", result.Nodes[0].Auth.User)
+	assert.Equal(t, "shelf-1", result.Nodes[0].Labels["zone"])
+	assert.Len(t, result.Changes[0].Details, 2)
+	assert.Equal(t, NodeVanished, result.Changes[1].Kind)
+	assert.True(t, result.Nodes[1].Vanished)
+	assert.False(t, inventory[1].Vanished)
+}
+
+func TestMergeNodes_MatchOnMAC(t *testing.T) {
+	inventory := test.CreateNodes()[:1]
+	withFacts(inventory[0], "", "dc:a6:32:00:00:01")
+
+	scanned := test.CreateNodes()[1:2]
+	withFacts(scanned[0], "", "dc:a6:32:00:00:02", "dc:a6:32:00:00:01")
+
+	result := MergeNodes(inventory, scanned, nil)
+
+	assert.Len(t, result.Nodes, 1)
+	assert.Equal(t, NodeUpdated, result.Changes[0].Kind)
+	assert.Equal(t, scanned[0].Address, result.Nodes[0].Address)
+}
+
+func TestMergeNodes_IPReusedByOtherBoard(t *testing.T) {
+	inventory := test.CreateNodes()[:1]
+	withFacts(inventory[0], "serial-1")
+
+	scanned := test.CreateNodes()[:1]
+	withFacts(scanned[0], "serial-2")
+
+	result := MergeNodes(inventory, scanned, nil)
+
+	assert.Len(t, result.Nodes, 2)
+	assert.Equal(t, NodeVanished, result.Changes[0].Kind)
+	assert.Equal(t, NodeAdded, result.Changes[1].Kind)
+}
+
+func TestMergeNodes_Unchanged(t *testing.T) {
+	inventory := test.CreateNodes()
+	inventory[2].Vanished = true
+
+	result := MergeNodes(inventory, test.CreateNodes()[:2], nil)
+
+	assert.False(t, result.HasChanges())
+
+	var b bytes.Buffer
+	result.PrintDiff(&b)
+	assert.Empty(t, b.String())
+}
+
+func TestMergeNodes_FoundAgain(t *testing.T) {
+	inventory := test.CreateNodes()
+	inventory[2].Vanished = true
+
+	result := MergeNodes(inventory, test.CreateNodes(), nil)
+
+	assert.True(t, result.HasChanges())
+	assert.Equal(t, NodeUpdated, result.Changes[2].Kind)
+	assert.False(t, result.Nodes[2].Vanished)
+
+	var b bytes.Buffer
+	result.PrintDiff(&b)
+	assert.Equal(t, "~ node3 (10.0.0.3:22)\n    found again\n", b.String())
+}
+
+func TestMergeNodes_HostKey(t *testing.T) {
+	inventory := test.CreateNodes()
+	inventory[1].Address.HostKey = "ssh-ed25519 AAAA1"
+
+	scanned := test.CreateNodes()
+	scanned[0].Address.HostKey = "ssh-ed25519 AAAA0"
+	scanned[1].Address.HostKey = "ssh-ed25519 AAAA2"
+
+	result := MergeNodes(inventory, scanned, nil)
+
+	assert.Equal(t, []string{"host key pinned"}, result.Changes[0].Details)
+	assert.Equal(t, []string{"host key changed"}, result.Changes[1].Details)
+	assert.Equal(t, "ssh-ed25519 AAAA2", result.Nodes[1].Address.HostKey)
+	assert.Equal(t, NodeUnchanged, result.Changes[2].Kind)
+}
+
+func TestMergeNodes_NotCovered(t *testing.T) {
+	inventory := test.CreateNodes()
+	inventory[1].Labels = map[string]string{"zone": "shelf-2"}
+	inventory[2].Address = model.NewAddress("10.0.1.3", 22)
+	scanned := test.CreateNodes()[:1]
+
+	selector, _ := model.ParseSelector("zone!=shelf-2")
+	request := &ScanRequest{Cidr: "10.0.0.0/24", Port: 22, SSHAuth: &model.Auth{User: "test"}, Selector: selector}
+	result := MergeNodes(inventory, scanned, request)
+	assert.False(t, result.HasChanges(), "nodes outside the range or filtered out are not vanished")
+
+	request.Selector = nil
+	result = MergeNodes(inventory, scanned, request)
+	assert.Equal(t, NodeVanished, result.Changes[1].Kind)
+	assert.Equal(t, NodeUnchanged, result.Changes[2].Kind)
+
+	result = MergeNodes(inventory, scanned, &ScanRequest{Cidr: "10.0.0.0/16", Port: 22, SSHAuth: &model.Auth{User: "root"}})
+	assert.False(t, result.HasChanges(), "nodes with another user are not vanished")
+
+	result = MergeNodes(inventory, scanned, &ScanRequest{Cidr: "10.0.0.0/16", Port: 22, HostnameSubString: "node1", SSHAuth: &model.Auth{User: "test"}})
+	assert.False(t, result.HasChanges(), "nodes with other hostnames are not vanished")
+}
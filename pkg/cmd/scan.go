@@ -24,18 +24,24 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	client2 "github.com/TheNatureOfSoftware/k3pi/pkg/client"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"net"
+	"sort"
 	"strings"
+	"time"
 )
 
-// SupportedArch supported architectures
-var SupportedArch = map[string]bool{
-	"armv6l":  true,
-	"armv7l":  true,
-	"aarch64": true,
-}
+const (
+	// DefaultScanConcurrency default number of hosts probed in parallel
+	DefaultScanConcurrency = 20
+	// DefaultScanHostTimeout default time allowed for probing a single host
+	DefaultScanHostTimeout = time.Second * 15
+)
 
 // ScanRequest parameter type for scanning for nodes
 type ScanRequest struct {
@@ -43,6 +49,10 @@ type ScanRequest struct {
 	Port                    int
 	SSHAuth                 *model.Auth
 	UserCredentials         map[string]string
+	Concurrency             int
+	HostTimeout             time.Duration
+	Selector                model.Selector
+	Architectures           []string
 }
 
 // GetAuths returns all authentications for this scan request
@@ -59,67 +69,208 @@ func (request *ScanRequest) GetAuths() model.Auths {
 	return auths
 }
 
-func checkArch(clientFactory *client2.Factory, address *model.Address, auth *model.Auth) (bool, string) {
-	client, err := clientFactory.Create(auth, address)
-	if err != nil {
-		return false, ""
+// Covers returns true if the scan would find the node when it's up, that is the node's address is in
+// the scanned range and the node matches the filters of the scan
+func (request *ScanRequest) Covers(node *model.Node) bool {
+	return request.coversAddress(node.Address) &&
+		request.coversUser(node.Auth.User) &&
+		node.HasArch(request.architectures()) &&
+		strings.Contains(node.Hostname, request.HostnameSubString) &&
+		request.Selector.Matches(node)
+}
+
+func (request *ScanRequest) coversAddress(address model.Address) bool {
+	if request.Port > 0 && address.Port != request.Port {
+		return false
+	}
+	if _, ipNet, err := net.ParseCIDR(request.Cidr); err == nil {
+		ip := net.ParseIP(address.IP)
+		return ip != nil && ipNet.Contains(ip)
 	}
+	return address.IP == request.Cidr
+}
 
-	result, err := client.Cmd("uname -m").Output()
-	if err != nil {
-		return false, ""
+func (request *ScanRequest) coversUser(user string) bool {
+	if request.SSHAuth == nil && len(request.UserCredentials) == 0 {
+		return true
 	}
+	if request.SSHAuth != nil && request.SSHAuth.User == user {
+		return true
+	}
+	_, ok := request.UserCredentials[user]
+	return ok
+}
 
-	arch := strings.TrimSpace(string(result))
-	if _, supported := SupportedArch[arch]; supported {
-		return supported, arch
+func (request *ScanRequest) architectures() []string {
+	if len(request.Architectures) > 0 {
+		return request.Architectures
 	}
+	return model.DefaultArchitectures
+}
 
-	return false, ""
+func (request *ScanRequest) concurrency() int {
+	if request.Concurrency > 0 {
+		return request.Concurrency
+	}
+	return DefaultScanConcurrency
 }
 
-func checkIfHostnameMatch(clientFactory *client2.Factory, hostnameSubStr string, address *model.Address, auth *model.Auth) (string, bool) {
+func (request *ScanRequest) hostTimeout() time.Duration {
+	if request.HostTimeout > 0 {
+		return request.HostTimeout
+	}
+	return DefaultScanHostTimeout
+}
 
-	client, err := clientFactory.Create(auth, address)
+// probe connects to the host with the given auth and gathers its facts. Returns false if
+// the host couldn't be reached or the command failed.
+func probe(ctx context.Context, clientFactory *client2.Factory, address *model.Address, auth *model.Auth) (*model.Node, bool) {
+	client, err := clientFactory.Create(ctx, auth, address)
 	if err != nil {
-		return "", false
+		return nil, false
 	}
+	defer client.Close()
 
-	result, err := client.Cmd("cat /etc/hostname").Output()
+	result, err := client.Cmd(factsCmd).Output(ctx)
 	if err != nil {
-		return "", false
+		return nil, false
+	}
+
+	node := parseFacts(result)
+	node.Address = *address
+	node.Address.HostKey = client.HostKey()
+	node.Auth = *auth
+
+	return node, true
+}
+
+// probeHost tries all auths until one succeeds, returns nil if no node is found within the timeout
+func probeHost(ctx context.Context, clientFactory *client2.Factory, scanRequest *ScanRequest, auths model.Auths, address model.Address) *model.Node {
+
+	ctx, cancel := context.WithTimeout(ctx, scanRequest.hostTimeout())
+	defer cancel()
+
+	var node *model.Node
+	for _, auth := range auths {
+		if n, ok := probe(ctx, clientFactory, &address, auth); ok {
+			node = n
+			break
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	if node == nil {
+		return nil
+	}
+
+	if !node.HasArch(scanRequest.architectures()) {
+		return nil
+	}
+
+	if !strings.Contains(node.Hostname, scanRequest.HostnameSubString) {
+		return nil
 	}
 
-	hostname := strings.TrimSpace(string(result))
-	return hostname, strings.Contains(hostname, hostnameSubStr)
+	if !scanRequest.Selector.Matches(node) {
+		return nil
+	}
+
+	return node
 }
 
-// ScanForNodes scans for nodes matching the scan request
-func ScanForNodes(clientFactory *client2.Factory, scanRequest *ScanRequest, hostScanner misc.HostScanner) (*[]model.Node, error) {
+// ScanForNodesFunc scans for nodes matching the scan request and calls found for each node as soon
+// as it's discovered. Hosts are probed concurrently, found is never called concurrently. When ctx is
+// stopped no more hosts are probed, nodes found so far are passed to found and an error is returned.
+func ScanForNodesFunc(ctx context.Context, clientFactory *client2.Factory, scanRequest *ScanRequest, hostScanner misc.HostScanner, found func(node *model.Node)) error {
 
-	alive, err := hostScanner.ScanForAliveHosts(scanRequest.Cidr)
+	alive, err := hostScanner.ScanForAliveHosts(ctx, scanRequest.Cidr)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var raspberries []model.Node
+	hostCount := len(*alive)
+	concurrency := scanRequest.concurrency()
+	if hostCount < concurrency {
+		concurrency = hostCount
+	}
+
+	auths := scanRequest.GetAuths()
+	addressChan := make(chan model.Address)
+	nodeChan := make(chan *model.Node, hostCount)
 
-	for i := range *alive {
-		address := model.NewAddress((*alive)[i], scanRequest.Port)
-		for _, auth := range scanRequest.GetAuths() {
-			if b, arch := checkArch(clientFactory, &address, auth); b {
-				if hn, ok := checkIfHostnameMatch(clientFactory, scanRequest.HostnameSubString, &address, auth); ok {
-					raspberries = append(raspberries, model.Node{
-						Hostname: hn,
-						Address:  address,
-						Arch:     arch,
-						Auth:     *auth,
-					})
-					break
-				}
+	for i := 0; i < concurrency; i++ {
+		go func(addressChan <-chan model.Address) {
+			for address := range addressChan {
+				nodeChan <- probeHost(ctx, clientFactory, scanRequest, auths, address)
 			}
+		}(addressChan)
+	}
+
+	stopping := misc.Stopping(ctx)
+	probed, received := 0, 0
+	receive := func(node *model.Node) {
+		received++
+		if node != nil {
+			found(node)
 		}
 	}
+schedule:
+	for _, ip := range *alive {
+		if misc.Stopped(ctx) {
+			break
+		}
+		address := model.NewAddress(ip, scanRequest.Port)
+		// found nodes are passed on while hosts are still scheduled
+		for scheduled := false; !scheduled; {
+			select {
+			case addressChan <- address:
+				probed++
+				scheduled = true
+			case node := <-nodeChan:
+				receive(node)
+			case <-stopping:
+				break schedule
+			}
+		}
+	}
+	close(addressChan)
+
+	for received < probed {
+		receive(<-nodeChan)
+	}
+
+	if probed < hostCount {
+		return fmt.Errorf("scan interrupted, %d of %d hosts probed", probed, hostCount)
+	}
+
+	return nil
+}
+
+// ScanForNodes scans for nodes matching the scan request, the nodes are sorted by IP-address
+func ScanForNodes(ctx context.Context, clientFactory *client2.Factory, scanRequest *ScanRequest, hostScanner misc.HostScanner) (*[]model.Node, error) {
+
+	var raspberries []model.Node
+
+	err := ScanForNodesFunc(ctx, clientFactory, scanRequest, hostScanner, func(node *model.Node) {
+		raspberries = append(raspberries, *node)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(raspberries, func(i, j int) bool {
+		return compareIP(raspberries[i].Address.IP, raspberries[j].Address.IP) < 0
+	})
 
 	return &raspberries, nil
 }
+
+func compareIP(a, b string) int {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return strings.Compare(a, b)
+	}
+	return bytes.Compare(ipA.To16(), ipB.To16())
+}
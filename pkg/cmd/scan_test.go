@@ -22,11 +22,14 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 const (
@@ -41,13 +44,17 @@ type mockHostScanner struct {
 	returnError bool
 }
 
-func (s mockHostScanner) ScanForAliveHosts(cidr string) (*[]string, error) {
+func (s mockHostScanner) ScanForAliveHosts(ctx context.Context, cidr string) (*[]string, error) {
 	if s.returnError {
 		return nil, fmt.Errorf("failed to scan for hosts with CIDR: %s", cidr)
 	}
 	return &hostScannerResult, nil
 }
 
+func facts(arch, hostname string) string {
+	return fmt.Sprintf("arch=%s\nhostname=%s", arch, hostname)
+}
+
 func createScanRequest() *ScanRequest {
 	scanRequest := &ScanRequest{
 		Cidr:              "127.0.0.1/32",
@@ -59,20 +66,19 @@ func createScanRequest() *ScanRequest {
 		},
 		Port:            22,
 		UserCredentials: make(map[string]string),
+		Concurrency:     1,
 	}
 	return scanRequest
 }
 
 func TestScanForNodes(t *testing.T) {
 	clientFactory, _ := client.NewFakeClientFactory(func(script *client.FakeScript) {
-		script.Expect("uname -m", "aarch64")
-		script.Expect("cat /etc/hostname", "host1")
-		script.Expect("uname -m", "armv7l")
-		script.Expect("cat /etc/hostname", "host2")
+		script.Expect(factsCmd, facts("aarch64", "host1"))
+		script.Expect(factsCmd, facts("armv7l", "host2"))
 	})
 
 	request := createScanRequest()
-	nodes, err := ScanForNodes(clientFactory, request, &mockHostScanner{})
+	nodes, err := ScanForNodes(context.Background(), clientFactory, request, &mockHostScanner{})
 
 	assert.NoError(t, err)
 	assert.Len(t, *nodes, 2)
@@ -82,18 +88,80 @@ func TestScanForNodes(t *testing.T) {
 
 func TestScanForNodes_FilterOnHostname(t *testing.T) {
 	clientFactory, _ := client.NewFakeClientFactory(func(script *client.FakeScript) {
-		script.Expect("uname -m", "aarch64")
-		script.Expect("cat /etc/hostname", "host1")
-		script.Expect("uname -m", "armv7l")
-		script.Expect("cat /etc/hostname", "host2")
+		script.Expect(factsCmd, facts("aarch64", "host1"))
+		script.Expect(factsCmd, facts("armv7l", "host2"))
 	})
 	request := createScanRequest()
 	request.HostnameSubString = "2"
-	nodes, err := ScanForNodes(clientFactory, request, &mockHostScanner{})
+	nodes, err := ScanForNodes(context.Background(), clientFactory, request, &mockHostScanner{})
+
+	assert.NoError(t, err)
+	assert.Len(t, *nodes, 1)
+	assert.Equal(t, "host2", (*nodes)[0].Hostname)
+}
+
+func TestScanForNodes_Architectures(t *testing.T) {
+	clientFactory, _ := client.NewFakeClientFactory(func(script *client.FakeScript) {
+		script.Expect(factsCmd, facts("x86_64", "host1"))
+		script.Expect(factsCmd, facts("armv7l", "host2"))
+	})
+	request := createScanRequest()
+
+	nodes, err := ScanForNodes(context.Background(), clientFactory, request, &mockHostScanner{})
+	assert.NoError(t, err)
+	assert.Len(t, *nodes, 2)
+	assert.Equal(t, "amd64", (*nodes)[0].GetArch())
+
+	request.Architectures = []string{"armv7l"}
+	nodes, err = ScanForNodes(context.Background(), clientFactory, request, &mockHostScanner{})
+	assert.NoError(t, err)
+	assert.Len(t, *nodes, 1)
+	assert.Equal(t, "host2", (*nodes)[0].Hostname)
+}
+
+func TestScanForNodes_Selector(t *testing.T) {
+	clientFactory, _ := client.NewFakeClientFactory(func(script *client.FakeScript) {
+		script.Expect(factsCmd, facts("aarch64", "host1"))
+		script.Expect(factsCmd, facts("armv7l", "host2"))
+	})
+	request := createScanRequest()
+	request.Selector, _ = model.ParseSelector("arch=arm")
+	nodes, err := ScanForNodes(context.Background(), clientFactory, request, &mockHostScanner{})
+
+	assert.NoError(t, err)
+	assert.Len(t, *nodes, 1)
+	assert.Equal(t, "host2", (*nodes)[0].Hostname)
+}
+
+func TestScanForNodes_Concurrent(t *testing.T) {
+	clientFactory := &client.Factory{Create: func(ctx context.Context, auth *model.Auth, address *model.Address) (client.Client, error) {
+		c, _ := client.NewFakeClient(ctx, auth, address)
+		c.(*client.FakeClient).FakeScript.Expect(factsCmd, facts("aarch64", "node-"+address.IP))
+		return c, nil
+	}}
+	request := createScanRequest()
+	request.Concurrency = 2
+
+	nodes, err := ScanForNodes(context.Background(), clientFactory, request, &mockHostScanner{})
+
+	assert.NoError(t, err)
+	assert.Len(t, *nodes, 2)
+	assert.Equal(t, "node-"+host1, (*nodes)[0].Hostname)
+	assert.Equal(t, "node-"+host2, (*nodes)[1].Hostname)
+}
+
+func TestScanForNodes_UnsupportedArch(t *testing.T) {
+	clientFactory, _ := client.NewFakeClientFactory(func(script *client.FakeScript) {
+		script.Expect(factsCmd, facts("mips", "host1"))
+		script.Expect(factsCmd, facts("armv7l", "host2"))
+	})
+	request := createScanRequest()
+	nodes, err := ScanForNodes(context.Background(), clientFactory, request, &mockHostScanner{})
 
 	assert.NoError(t, err)
 	assert.Len(t, *nodes, 1)
 	assert.Equal(t, "host2", (*nodes)[0].Hostname)
+	assert.Equal(t, host2, (*nodes)[0].Address.IP)
 }
 
 func TestScanRequest_GetAuths(t *testing.T) {
@@ -123,3 +191,61 @@ func TestScanRequest_GetAuths(t *testing.T) {
 	assert.Equal(t, auths[1].Password, password)
 }
 
+
+func TestScanForNodesFunc_Interrupted(t *testing.T) {
+	clientFactory, _ := client.NewFakeClientFactory(func(script *client.FakeScript) {
+		script.Expect(factsCmd, facts("aarch64", "host1"))
+	})
+
+	stop := make(chan struct{})
+	close(stop)
+	ctx := misc.WithStop(context.Background(), stop)
+
+	var found []*model.Node
+	err := ScanForNodesFunc(ctx, clientFactory, createScanRequest(), &mockHostScanner{}, func(node *model.Node) {
+		found = append(found, node)
+	})
+
+	assert.EqualError(t, err, "scan interrupted, 0 of 2 hosts probed")
+	assert.Empty(t, found)
+}
+
+// staticHostScanner finds the hosts
+type staticHostScanner []string
+
+func (s staticHostScanner) ScanForAliveHosts(ctx context.Context, cidr string) (*[]string, error) {
+	hosts := []string(s)
+	return &hosts, nil
+}
+
+func TestScanForNodesFunc_Streaming(t *testing.T) {
+	firstFound := make(chan struct{})
+	blocked := false
+	clientFactory := &client.Factory{Create: func(ctx context.Context, auth *model.Auth, address *model.Address) (client.Client, error) {
+		if address.IP == host2 {
+			// host1 must be passed on while the next hosts are waiting to be probed
+			select {
+			case <-firstFound:
+			case <-time.After(time.Second):
+				blocked = true
+			}
+		}
+		c, _ := client.NewFakeClient(ctx, auth, address)
+		c.(*client.FakeClient).FakeScript.Expect(factsCmd, facts("aarch64", "node-"+address.IP))
+		return c, nil
+	}}
+	request := createScanRequest()
+	request.Concurrency = 1
+
+	var found []string
+	err := ScanForNodesFunc(context.Background(), clientFactory, request, staticHostScanner{host1, host2, "10.0.0.3"}, func(node *model.Node) {
+		if len(found) == 0 {
+			close(firstFound)
+		}
+		found = append(found, node.Address.IP)
+	})
+
+	assert.NoError(t, err)
+	assert.False(t, blocked, "found nodes should be passed on as they are found")
+	assert.ElementsMatch(t, []string{host1, host2, "10.0.0.3"}, found)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+)
+
+// FindNode returns the node with the hostname or ip, fails if there is none or the hostname is used by
+// more than one node
+func FindNode(nodes model.Nodes, hostnameOrIP string) (*model.Node, error) {
+	var found model.Nodes
+	for _, node := range nodes {
+		if node.Address.IP == hostnameOrIP {
+			return node, nil
+		}
+		if node.Hostname == hostnameOrIP {
+			found = append(found, node)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no node with hostname or ip %s", hostnameOrIP)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("%d nodes have hostname %s, use the ip", len(found), hostnameOrIP)
+	}
+}
+
+// Shell runs an interactive session on the node using the node's auth, a login shell or the command if
+// not empty
+func Shell(ctx context.Context, clientFactory *client.Factory, node *model.Node, command string, terminal *client.Terminal) error {
+	c, err := clientFactory.Create(ctx, &node.Auth, &node.Address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s (%s): %v", node.Hostname, node.Address.IP, err)
+	}
+	defer c.Close()
+
+	return client.Shell(ctx, c, command, terminal)
+}
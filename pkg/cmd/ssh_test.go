@@ -0,0 +1,49 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFindNode(t *testing.T) {
+	node1 := &model.Node{Hostname: "k3s-node1", Address: model.Address{IP: "192.168.1.10"}}
+	node2 := &model.Node{Hostname: "k3s-node2", Address: model.Address{IP: "192.168.1.11"}}
+	dup := &model.Node{Hostname: "k3s-node2", Address: model.Address{IP: "192.168.1.12"}}
+	nodes := model.Nodes{node1, node2, dup}
+
+	found, err := FindNode(nodes, "k3s-node1")
+	assert.NoError(t, err)
+	assert.Equal(t, node1, found)
+
+	found, err = FindNode(nodes, "192.168.1.12")
+	assert.NoError(t, err)
+	assert.Equal(t, dup, found)
+
+	_, err = FindNode(nodes, "k3s-node2")
+	assert.EqualError(t, err, "2 nodes have hostname k3s-node2, use the ip")
+
+	_, err = FindNode(nodes, "k3s-node3")
+	assert.EqualError(t, err, "no node with hostname or ip k3s-node3")
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package cmd handles k3pi use cases
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/install"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// K3sNodeTokenFile file on the server node holding the cluster token
+	K3sNodeTokenFile = "/var/lib/rancher/k3s/server/node-token"
+	// DefaultWatchInterval default time between scans when watching for new nodes
+	DefaultWatchInterval = time.Minute
+	// DefaultMaxPerInterval default max number of nodes installed per interval
+	DefaultMaxPerInterval = 1
+)
+
+// Watcher periodically scans for new nodes and installs them as agents joining a server
+type Watcher struct {
+	// ClientFactory used for scanning, installs use their own pooled factory
+	ClientFactory  *client.Factory
+	HostScanner    misc.HostScanner
+	ScanRequest    *ScanRequest
+	Inventory      model.Nodes
+	MaxPerInterval int
+	// InstallArgs used for all installs, nodes, server and token are set by the watcher
+	InstallArgs *InstallArgs
+	// Install installs nodes, defaults to Install
+	Install func(ctx context.Context, args *InstallArgs) error
+	// Save is called with the inventory after new nodes are installed, a failed save is retried on
+	// the next poll
+	Save func(inventory model.Nodes) error
+
+	failed  map[string]bool
+	unsaved bool
+}
+
+// Run polls for new nodes every interval until ctx is stopped, see misc.Stopping. A failed poll, e.g. a
+// failed scan, is logged and polling continues on the next interval.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) error {
+	log.Printf("Watching %s every %s for new nodes matching '%s', joining %s", w.ScanRequest.Cidr, interval, w.ScanRequest.Selector, w.InstallArgs.ServerID)
+	stopping := misc.Stopping(ctx)
+	for {
+		if _, err := w.Poll(ctx); err != nil {
+			if misc.Stopped(ctx) {
+				return nil
+			}
+			log.Printf("Poll failed, retrying in %s: %v", interval, err)
+		}
+		select {
+		case <-stopping:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Poll scans once and installs at most MaxPerInterval new nodes, returns the installed nodes
+func (w *Watcher) Poll(ctx context.Context) (model.Nodes, error) {
+	if w.failed == nil {
+		w.failed = make(map[string]bool)
+	}
+	if err := w.save(); err != nil {
+		return nil, err
+	}
+
+	scanned, err := ScanForNodes(ctx, w.ClientFactory, w.ScanRequest, w.HostScanner)
+	if err != nil {
+		return nil, err
+	}
+
+	var found model.Nodes
+	for i := range *scanned {
+		found = append(found, &(*scanned)[i])
+	}
+
+	var candidates model.Nodes
+	for _, change := range MergeNodes(w.Inventory, found, w.ScanRequest).Changes {
+		if change.Kind != NodeAdded {
+			continue
+		}
+		node := change.Node
+		switch {
+		case w.failed[node.Address.IP]:
+			log.Printf("Skipping %s (%s), install failed before", node.Hostname, node.Address.IP)
+		case node.IsInstalled():
+			log.Printf("Skipping %s (%s), k3OS or k3s is already installed", node.Hostname, node.Address.IP)
+		default:
+			candidates = append(candidates, node)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	max := w.MaxPerInterval
+	if max <= 0 {
+		max = DefaultMaxPerInterval
+	}
+	if len(candidates) > max {
+		log.Printf("Found %d new nodes, installing %d this interval", len(candidates), max)
+		candidates = candidates[:max]
+	}
+
+	var installed model.Nodes
+	for i, node := range candidates {
+		if misc.Stopped(ctx) {
+			break
+		}
+		log.Printf("Installing %s (%s) as agent", node.Hostname, node.Address.IP)
+		if err := w.installNode(ctx, node, len(w.Inventory)+i); err != nil {
+			log.Printf("Install failed for %s (%s): %v", node.Hostname, node.Address.IP, err)
+			w.failed[node.Address.IP] = true
+			continue
+		}
+		log.Printf("Installed %s (%s)", node.Hostname, node.Address.IP)
+		installed = append(installed, node)
+	}
+
+	if len(installed) == 0 || w.InstallArgs.DryRun {
+		return installed, nil
+	}
+
+	w.Inventory = append(w.Inventory, installed...)
+	w.unsaved = true
+	return installed, w.save()
+}
+
+// save saves the inventory if there are installed nodes not saved yet
+func (w *Watcher) save() error {
+	if !w.unsaved || w.Save == nil {
+		return nil
+	}
+	if err := w.Save(w.Inventory); err != nil {
+		return fmt.Errorf("failed to save inventory: %v", err)
+	}
+	w.unsaved = false
+	return nil
+}
+
+func (w *Watcher) installNode(ctx context.Context, node *model.Node, offset int) error {
+	args := *w.InstallArgs
+	args.Nodes = model.Nodes{node}
+	args.Confirmed = true
+	args.Selector = nil
+	if args.HostnameSpec != nil {
+		spec := *args.HostnameSpec
+		spec.Offset = offset
+		args.HostnameSpec = &spec
+	} else {
+		args.HostnameSpec = &install.HostnameSpec{Pattern: "%s%d", Prefix: "k3s-node", Offset: offset}
+	}
+
+	installFunc := w.Install
+	if installFunc == nil {
+		installFunc = Install
+	}
+	if err := installFunc(ctx, &args); err != nil {
+		return err
+	}
+
+	node.Auth = args.nodeAuth(node)
+	node.Facts = nil
+	return nil
+}
+
+// FetchToken reads the cluster token from a server node
+func FetchToken(ctx context.Context, clientFactory *client.Factory, server *model.Node) (string, error) {
+	c, err := clientFactory.Create(ctx, &server.Auth, &server.Address)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	b, err := c.FS().Sudo().ReadFile(ctx, K3sNodeTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token from %s: %v", server.Address.IP, err)
+	}
+
+	token := strings.TrimSpace(string(b))
+	if len(token) == 0 {
+		return "", fmt.Errorf("no token found on %s", server.Address.IP)
+	}
+	return token, nil
+}
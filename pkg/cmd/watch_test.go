@@ -0,0 +1,201 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func createWatcher(inventory model.Nodes, install func(ctx context.Context, args *InstallArgs) error) (*Watcher, *model.Nodes) {
+	clientFactory := &client.Factory{Create: func(ctx context.Context, auth *model.Auth, address *model.Address) (client.Client, error) {
+		c, _ := client.NewFakeClient(ctx, auth, address)
+		c.(*client.FakeClient).FakeScript.Expect(factsCmd, facts("aarch64", "host-"+address.IP))
+		return c, nil
+	}}
+
+	saved := &model.Nodes{}
+	return &Watcher{
+		ClientFactory: clientFactory,
+		HostScanner:   &mockHostScanner{},
+		ScanRequest:   createScanRequest(),
+		Inventory:     inventory,
+		InstallArgs:   &InstallArgs{ServerID: "10.0.0.100", Token: "secret"},
+		Install:       install,
+		Save: func(inventory model.Nodes) error {
+			*saved = inventory
+			return nil
+		},
+	}, saved
+}
+
+func TestWatcher_Poll(t *testing.T) {
+	inventory := model.Nodes{{Hostname: "k3s-node1", Address: model.Address{IP: host1, Port: 22}}}
+	var installs []*InstallArgs
+	watcher, saved := createWatcher(inventory, func(ctx context.Context, args *InstallArgs) error {
+		installs = append(installs, args)
+		return nil
+	})
+
+	installed, err := watcher.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, installed, 1)
+	assert.Equal(t, host2, installed[0].Address.IP)
+
+	assert.Len(t, installs, 1)
+	assert.True(t, installs[0].Confirmed)
+	assert.Equal(t, "10.0.0.100", installs[0].ServerID)
+	assert.Equal(t, "secret", installs[0].Token)
+	assert.Equal(t, 1, installs[0].HostnameSpec.Offset)
+
+	assert.Len(t, *saved, 2)
+	assert.Equal(t, "rancher", (*saved)[1].Auth.User)
+
+	installed, err = watcher.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, installed, 0)
+	assert.Len(t, installs, 1)
+}
+
+func TestWatcher_Poll_NodeAuth(t *testing.T) {
+	watcher, saved := createWatcher(model.Nodes{{Hostname: "k3s-node1", Address: model.Address{IP: host1, Port: 22}}}, func(ctx context.Context, args *InstallArgs) error {
+		return nil
+	})
+	watcher.InstallArgs.NodeAuth = &model.Auth{Type: model.AuthTypeSSHKey, User: "pi", SSHKey: "~/.ssh/id_ed25519", SSHCert: "~/.ssh/id_ed25519-cert.pub"}
+
+	_, err := watcher.Poll(context.Background())
+	assert.NoError(t, err)
+
+	assert.Len(t, *saved, 2)
+	auth := (*saved)[1].Auth
+	assert.Equal(t, "rancher", auth.User)
+	assert.Equal(t, "~/.ssh/id_ed25519", auth.SSHKey)
+	assert.Equal(t, "~/.ssh/id_ed25519-cert.pub", auth.SSHCert)
+}
+
+func TestWatcher_Poll_MaxPerInterval(t *testing.T) {
+	var offsets []int
+	watcher, saved := createWatcher(nil, func(ctx context.Context, args *InstallArgs) error {
+		offsets = append(offsets, args.HostnameSpec.Offset)
+		return nil
+	})
+	watcher.MaxPerInterval = 1
+
+	installed, err := watcher.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, installed, 1)
+	assert.Equal(t, host1, installed[0].Address.IP)
+
+	installed, err = watcher.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, installed, 1)
+	assert.Equal(t, host2, installed[0].Address.IP)
+
+	assert.Equal(t, []int{0, 1}, offsets)
+	assert.Len(t, *saved, 2)
+}
+
+func TestWatcher_Poll_InstallFailed(t *testing.T) {
+	installs := 0
+	watcher, saved := createWatcher(nil, func(ctx context.Context, args *InstallArgs) error {
+		installs++
+		return fmt.Errorf("install failed")
+	})
+	watcher.MaxPerInterval = 2
+
+	installed, err := watcher.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, installed, 0)
+	assert.Equal(t, 2, installs)
+	assert.Len(t, *saved, 0)
+
+	installed, err = watcher.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, installed, 0)
+	assert.Equal(t, 2, installs, "failed nodes should not be retried")
+}
+
+// failingHostScanner fails the first scans
+type failingHostScanner struct {
+	failures int
+}
+
+func (s *failingHostScanner) ScanForAliveHosts(ctx context.Context, cidr string) (*[]string, error) {
+	if s.failures > 0 {
+		s.failures--
+		return nil, fmt.Errorf("failed to scan for hosts with CIDR: %s", cidr)
+	}
+	return &hostScannerResult, nil
+}
+
+func TestWatcher_Run_PollFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, saved := createWatcher(nil, func(ctx context.Context, args *InstallArgs) error {
+		cancel()
+		return nil
+	})
+	watcher.HostScanner = &failingHostScanner{failures: 2}
+
+	err := watcher.Run(ctx, time.Millisecond)
+	assert.NoError(t, err)
+	assert.Len(t, *saved, 1, "watching should continue after failed scans")
+}
+
+func TestWatcher_Poll_SaveFailed(t *testing.T) {
+	watcher, saved := createWatcher(nil, func(ctx context.Context, args *InstallArgs) error {
+		return nil
+	})
+	watcher.MaxPerInterval = 1
+	save := watcher.Save
+	watcher.Save = func(inventory model.Nodes) error {
+		return fmt.Errorf("read-only file system")
+	}
+
+	installed, err := watcher.Poll(context.Background())
+	assert.EqualError(t, err, "failed to save inventory: read-only file system")
+	assert.Len(t, installed, 1)
+
+	watcher.HostScanner = &mockHostScanner{returnError: true}
+	watcher.Save = save
+	_, err = watcher.Poll(context.Background())
+	assert.Error(t, err)
+	assert.Len(t, *saved, 1, "the inventory should be saved on the next poll")
+}
+
+func TestFetchToken(t *testing.T) {
+	clientFactory, script := client.NewFakeClientFactory()
+	script.FS.Files[K3sNodeTokenFile] = &client.FakeFile{Data: []byte("K10abc::server:secret\n"), Mode: 0600, Owner: "root"}
+
+	token, err := FetchToken(context.Background(), clientFactory, &model.Node{Address: model.Address{IP: host1}})
+	assert.NoError(t, err)
+	assert.Equal(t, "K10abc::server:secret", token)
+
+	delete(script.FS.Files, K3sNodeTokenFile)
+	_, err = FetchToken(context.Background(), clientFactory, &model.Node{Address: model.Address{IP: host1}})
+	assert.EqualError(t, err, "failed to read token from 10.0.0.1: read /var/lib/rancher/k3s/server/node-token: file does not exist")
+}
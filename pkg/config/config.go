@@ -31,6 +31,7 @@ import (
 	"github.com/kubernetes-sigs/yaml"
 	"io/ioutil"
 	"log"
+	"strings"
 	"text/template"
 )
 
@@ -40,12 +41,27 @@ ssh_authorized_keys:
 {{- range .SSHAuthorizedKeys}}
 - "{{.}}"
 {{- end}}
+{{- if .TrustedUserCAKeys}}
+write_files:
+- path: /etc/ssh/trusted_user_ca_keys
+  permissions: "0644"
+  content: |
+{{- range .TrustedUserCAKeys}}
+    {{.}}
+{{- end}}
+boot_cmd:
+- "grep -q '^TrustedUserCAKeys' /etc/ssh/sshd_config || echo 'TrustedUserCAKeys /etc/ssh/trusted_user_ca_keys' >> /etc/ssh/sshd_config"
+{{- end}}
 k3os:
   k3s_args:
   - server
   - "--bind-address"
   - "{{.Node.Address.IP}}"
   token: {{.Token}}
+{{- if .Node.Labels}}
+  labels:
+{{.Node.Labels | yaml | indent 4}}
+{{- end}}
   password: rancher
   dns_nameservers:
   - 8.8.8.8
@@ -60,6 +76,17 @@ ssh_authorized_keys:
 {{- range .SSHAuthorizedKeys}}
 - "{{.}}"
 {{- end}}
+{{- if .TrustedUserCAKeys}}
+write_files:
+- path: /etc/ssh/trusted_user_ca_keys
+  permissions: "0644"
+  content: |
+{{- range .TrustedUserCAKeys}}
+    {{.}}
+{{- end}}
+boot_cmd:
+- "grep -q '^TrustedUserCAKeys' /etc/ssh/sshd_config || echo 'TrustedUserCAKeys /etc/ssh/trusted_user_ca_keys' >> /etc/ssh/sshd_config"
+{{- end}}
 k3os:
   k3s_args:
   - agent
@@ -67,6 +94,10 @@ k3os:
   - "{{.Node.Address.IP}}"
   server_url: https://{{.ServerIP}}:6443
   token: {{.Token}}
+{{- if .Node.Labels}}
+  labels:
+{{.Node.Labels | yaml | indent 4}}
+{{- end}}
   password: rancher
   dns_nameservers:
   - 8.8.8.8
@@ -79,13 +110,23 @@ k3os:
 type CloudConfig struct {
 	Hostname          string   `json:"hostname"`
 	SSHAuthorizedKeys []string `json:"ssh_authorized_keys,omitempty"`
+	WriteFiles        []File   `json:"write_files,omitempty"`
+	BootCmd           []string `json:"boot_cmd,omitempty"`
 	K3os              K3os     `json:"k3os"`
 }
 
+// File file written on boot
+type File struct {
+	Path        string `json:"path"`
+	Content     string `json:"content"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
 // K3os k3OS specific config
 type K3os struct {
 	K3sArgs     []string          `json:"k3s_args,omitempty"`
 	Environment map[string]string `json:"environment,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
 }
 
 // LoadFromFile loads config from file
@@ -121,9 +162,22 @@ func NewAgentConfig(configTmpl string, target *model.K3OSNode) (*[]byte, error)
 	return generateConfig(tmpl, target)
 }
 
+// templateFuncs functions available in config templates, yaml marshals a value so that keys and values
+// are quoted when needed and indent indents every line of a string
+var templateFuncs = template.FuncMap{
+	"yaml": func(v interface{}) (string, error) {
+		b, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(b), "\n"), err
+	},
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+	},
+}
+
 func generateConfig(configTmpl string, target *model.K3OSNode) (*[]byte, error) {
 
-	tmpl, err := template.New("cloud-config").Parse(configTmpl)
+	tmpl, err := template.New("cloud-config").Funcs(templateFuncs).Parse(configTmpl)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse cloud-config template: %d", err)
 	}
@@ -26,6 +26,8 @@ import (
 	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
 	"github.com/kubernetes-sigs/yaml"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -151,6 +153,54 @@ auth:
 	fmt.Println(string(*configAsBytes))
 }
 
+func TestNewAgentConfig_Labels(t *testing.T) {
+	node := &model.Node{
+		Hostname: "k3s-agent",
+		Address:  model.ParseAddress("10.0.0.2:22"),
+		Labels: map[string]string{
+			"zone":                  "shelf-1",
+			"disk":                  "ssd",
+			"example.com/note":      `say "hi": #1`,
+			"example.com/multiline": "a\nb",
+		},
+	}
+	configAsBytes, err := NewAgentConfig("", &model.K3OSNode{
+		Node:     *node,
+		ServerIP: "10.0.0.1",
+	})
+	misc.PanicOnError(err, "failed to create agent config")
+
+	actual := CloudConfig{}
+	actual.LoadFromBytes(*configAsBytes)
+
+	if !reflect.DeepEqual(node.Labels, actual.K3os.Labels) {
+		t.Errorf("expected: %v, actual: %v", node.Labels, actual.K3os.Labels)
+	}
+}
+
+func TestNewServerConfig_TrustedUserCAKeys(t *testing.T) {
+	caKeys := []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAICA fleet-ca", "ssh-rsa AAAAB3NzaC1yc2EAAAADAQAB old-ca"}
+	configAsBytes, err := NewServerConfig("", &model.K3OSNode{
+		Node:              model.Node{Hostname: "k3s-server", Address: model.ParseAddress("10.0.0.1:22")},
+		TrustedUserCAKeys: caKeys,
+	})
+	misc.PanicOnError(err, "failed to create server config")
+
+	actual := CloudConfig{}
+	actual.LoadFromBytes(*configAsBytes)
+
+	expected := []File{{Path: "/etc/ssh/trusted_user_ca_keys", Permissions: "0644", Content: caKeys[0] + "\n" + caKeys[1] + "\n"}}
+	if !reflect.DeepEqual(expected, actual.WriteFiles) {
+		t.Errorf("expected: %v, actual: %v", expected, actual.WriteFiles)
+	}
+	if len(actual.BootCmd) != 1 || !strings.Contains(actual.BootCmd[0], "TrustedUserCAKeys /etc/ssh/trusted_user_ca_keys") {
+		t.Errorf("sshd not configured: %v", actual.BootCmd)
+	}
+	if len(actual.SSHAuthorizedKeys) != 0 {
+		t.Errorf("expected no authorized keys: %v", actual.SSHAuthorizedKeys)
+	}
+}
+
 func marshalToString(o interface{}) string {
 	bytes, _ := yaml.Marshal(o)
 	return string(bytes)
@@ -1,72 +1,143 @@
 package install
 
 import (
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
+	"io"
 	"io/ioutil"
 	"os"
+	"path"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	// PathSeparatorStr os path separator as string (for string concat)
 	PathSeparatorStr = string(os.PathSeparator)
+	// KubeconfigFile kubeconfig on the server node
+	KubeconfigFile = "/etc/rancher/k3s/k3s.yaml"
+)
+
+// UploadAttempts attempts to upload a file before giving up
+var UploadAttempts = 3
+
+// UploadRetryDelay time to wait before retrying a failed upload
+var UploadRetryDelay = 5 * time.Second
+
+// WaitForNodeInterval time between attempts to connect to a node that is coming online
+var WaitForNodeInterval = 2 * time.Second
+
+// APIPort port of the k3s API on server nodes
+var APIPort = 6443
+
+// WaitForAPIInterval time between attempts to connect to the k3s API of a server that is starting
+var WaitForAPIInterval = 2 * time.Second
+
+// KubeconfigAttempts attempts to copy kubeconfig from a server that is starting before giving up
+var KubeconfigAttempts = 12
+
+// KubeconfigRetryDelay time to wait before retrying to copy kubeconfig
+var KubeconfigRetryDelay = 15 * time.Second
+
+// Node states reported when not all nodes are installed
+const (
+	StateInstalled  = "installed"
+	StateFailed     = "failed"
+	StateAborted    = "aborted, may be partially installed"
+	StateNotStarted = "not started"
 )
 
 type installResult struct {
-	installer model.Installer
-	err       error
+	index int
+	out   *NodeOutput
+	state string
+	err   error
 }
 
-// Run runs all installers in parallel
-func Run(installers model.Installers) error {
-
+// Run runs all installers in parallel, command output is written to output. No new installs are started
+// when ctx is stopped (see misc.Stopping), running installs are aborted when ctx is done.
+func Run(ctx context.Context, installers model.Installers, output *Output) error {
 	concurrentInstallers := 5
 	installerCount := len(installers)
 	if installerCount < concurrentInstallers {
 		concurrentInstallers = installerCount
 	}
-	installChan := make(chan model.Installer, concurrentInstallers)
+
+	installChan := make(chan int)
 	doneChan := make(chan installResult, installerCount)
 
 	fmt.Printf("Running install with %d concurrent installers\n", concurrentInstallers)
 
 	for i := 0; i < concurrentInstallers; i++ {
-		go func(installChan <-chan model.Installer, num int) {
-			for installer := range installChan {
-				fmt.Printf("Installer %d running ...\n", num)
-				err := installer.Install()
-				if err != nil {
-					fmt.Printf("Installer %d running ... Failed\n", num)
-				} else {
-					fmt.Printf("Installer %d running ... OK\n", num)
-				}
-				doneChan <- installResult{
-					installer: installer,
-					err:       err,
-				}
+		go func(installChan <-chan int) {
+			for index := range installChan {
+				doneChan <- runInstaller(ctx, index, installers[index], output)
 			}
-		}(installChan, i)
+		}(installChan)
 	}
 
-	for _, installer := range installers {
-		installChan <- installer
+	stopping := misc.Stopping(ctx)
+	scheduled := 0
+schedule:
+	for index := range installers {
+		if misc.Stopped(ctx) {
+			break
+		}
+		select {
+		case installChan <- index:
+			scheduled++
+		case <-stopping:
+			break schedule
+		}
+	}
+	close(installChan)
+
+	states := make([]string, installerCount)
+	for i := range states {
+		states[i] = StateNotStarted
 	}
 
 	var installErrors []error
-	for i := 0; i < installerCount; i++ {
+	for i := 0; i < scheduled; i++ {
 		result := <-doneChan
-		if result.err != nil {
-			installErrors = append(installErrors, errors.Wrap(result.err, fmt.Sprintf("install failed for install: %v", result.installer)))
+		states[result.index] = result.state
+		if result.state == StateFailed {
+			hostname := installers[result.index].Hostname()
+			if output.Quiet {
+				for _, line := range result.out.Tail() {
+					output.println(hostname, line)
+				}
+			}
+			installErrors = append(installErrors, errors.Wrap(result.err, fmt.Sprintf("install failed for %s", hostname)))
+		}
+	}
+
+	installed := 0
+	for _, state := range states {
+		if state == StateInstalled {
+			installed++
+		}
+	}
+
+	if installed < installerCount {
+		fmt.Println("Node states:")
+		for i, state := range states {
+			fmt.Printf("  %s:\t%s\n", installers[i].Hostname(), state)
 		}
 	}
 
+	if misc.Stopped(ctx) && installed < installerCount {
+		fmt.Println("Install interrupted")
+		return fmt.Errorf("install interrupted, %d of %d nodes installed", installed, installerCount)
+	}
+
 	if len(installErrors) > 0 {
-		//fmt.Printf("\r%s", strings.Repeat(" ", 35))
 		fmt.Println("Install failed with errors")
 		return fmt.Errorf("install errors: %s", installErrors)
 	}
@@ -75,38 +146,201 @@ func Run(installers model.Installers) error {
 	return nil
 }
 
-// MakeResourceDir creates resource directory with all resources needed for install
-func MakeResourceDir(assetOwner model.RemoteAssetOwner) string {
-	home, err := homedir.Dir()
-	misc.PanicOnError(err, "failed to resolve home directory")
+// runInstaller runs an installer unless ctx is stopped, the install is aborted if ctx is done while running
+func runInstaller(ctx context.Context, index int, installer model.Installer, output *Output) installResult {
+	out := output.Node(installer.Hostname())
+	if misc.Stopped(ctx) {
+		return installResult{index: index, out: out, state: StateNotStarted}
+	}
+
+	out.Status("Installing ...")
+	err := installer.Install(ctx, out)
+	out.Flush()
+
+	switch {
+	case err == nil:
+		out.Status("Install OK")
+		return installResult{index: index, out: out, state: StateInstalled}
+	case ctx.Err() != nil:
+		out.Status("Install aborted: %v", err)
+		return installResult{index: index, out: out, state: StateAborted, err: err}
+	default:
+		out.Status("Install failed: %v", err)
+		return installResult{index: index, out: out, state: StateFailed, err: err}
+	}
+}
+
+// MakeResourceDir creates resource directory with all resources needed for install, the directory is
+// removed if a resource can't be fetched
+func MakeResourceDir(assetOwner model.RemoteAssetOwner) (string, error) {
+	resourceDir, err := NewResourceDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create resource directory")
+	}
+
+	if err = FetchResources(resourceDir, assetOwner); err != nil {
+		_ = os.RemoveAll(resourceDir)
+		return "", errors.Wrap(err, "failed to create resource directory")
+	}
 
-	resourceDir, err := ioutil.TempDir(home, ".k3pi-")
-	misc.PanicOnError(err, "failed to create resource directory")
+	return resourceDir, nil
+}
+
+// NewResourceDir creates an empty resource directory in the home directory
+func NewResourceDir() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve home directory")
+	}
+	return ioutil.TempDir(home, ".k3pi-")
+}
 
+// FetchResources downloads the resources needed for install that are not already in the resource
+// directory, a resource directory can be reused for several installs
+func FetchResources(resourceDir string, assetOwner model.RemoteAssetOwner) error {
 	for _, remoteAsset := range assetOwner.GetRemoteAssets() {
 		_, err := os.Stat(resourceDir + PathSeparatorStr + remoteAsset.Filename)
 		if os.IsNotExist(err) {
-			err := misc.DownloadAndVerify(resourceDir, remoteAsset)
-			misc.PanicOnError(err, "failed to create resource directory")
+			if err = misc.DownloadAndVerify(resourceDir, remoteAsset); err != nil {
+				return err
+			}
 		}
 	}
-
-	return resourceDir
+	return nil
 }
 
 // WaitForNode wait for a node to come online
-func WaitForNode(clientFactory *client.Factory, node *model.Node, timeout time.Duration) error {
+func WaitForNode(ctx context.Context, clientFactory *client.Factory, node *model.Node, timeout time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	timeToStop := time.Now().Add(timeout)
 	for {
-		_, err := clientFactory.Create(&node.Auth, &node.Address)
+		c, err := clientFactory.Create(ctx, &node.Auth, &node.Address)
 		if err == nil {
+			_ = c.Close()
 			break
-		} else if time.Now().After(timeToStop) {
+		}
+		select {
+		case <-ctx.Done():
 			return fmt.Errorf("timeout waiting for node: %s", node.Address)
+		case <-time.After(WaitForNodeInterval):
+		}
+	}
+
+	return nil
+}
+
+// WaitForAPI waits until the k3s API port of the server node accepts connections, the port is dialed
+// the way the node is reached, through its jump hosts if it has any
+func WaitForAPI(ctx context.Context, node *model.Node, timeout time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	address := model.NewAddress(node.Address.IP, APIPort)
+	for {
+		conn, err := client.DialPort(ctx, &node.Auth, &address)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for k3s API: %s: %v", address, err)
+		case <-time.After(WaitForAPIInterval):
+		}
+	}
+}
+
+// Upload uploads a file to a node with progress written to out. A failed upload is retried, resuming
+// from what was uploaded, the pooled client reconnects if the connection was lost.
+func Upload(ctx context.Context, c client.Client, filename, remotePath string, out io.Writer) error {
+	var err error
+	for attempt := 1; attempt <= UploadAttempts; attempt++ {
+		if err = c.Copy(ctx, filename, remotePath, uploadProgress(out, path.Base(filename))); err == nil || ctx.Err() != nil {
+			return err
+		}
+		if attempt < UploadAttempts {
+			status(out, "upload of %s failed, retrying (%d/%d): %v", path.Base(filename), attempt+1, UploadAttempts, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(UploadRetryDelay):
+			}
 		}
-		time.Sleep(time.Second * 2)
 	}
+	return err
+}
+
+// CopyKubeconfig copies kubeconfig from server node
+func CopyKubeconfig(ctx context.Context, clientFactory *client.Factory, kubeconfigFile string, node *model.Node) error {
+	c, err := clientFactory.Create(ctx, &node.Auth, &node.Address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	f, err := os.OpenFile(kubeconfigFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = c.Download(ctx, KubeconfigFile, f); err != nil {
+		return errors.Wrap(err, "failed to copy kubeconfig")
+	}
+
+	return nil
+}
+
+// FetchKubeconfig copies kubeconfig from a server node that is starting, retries until k3s has
+// written it or KubeconfigAttempts is reached
+func FetchKubeconfig(ctx context.Context, clientFactory *client.Factory, kubeconfigFile string, node *model.Node) error {
+	var err error
+	for attempt := 1; attempt <= KubeconfigAttempts; attempt++ {
+		if err = CopyKubeconfig(ctx, clientFactory, kubeconfigFile, node); err == nil {
+			return nil
+		}
+		if attempt < KubeconfigAttempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(KubeconfigRetryDelay):
+			}
+		}
+	}
+	return err
+}
 
+// CheckSudo checks that commands can be run with sudo on all nodes before installing, the nodes are
+// checked concurrently. The error lists every node where sudo isn't possible.
+func CheckSudo(ctx context.Context, clientFactory *client.Factory, nodes model.Nodes) error {
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *model.Node) {
+			defer wg.Done()
+			c, err := clientFactory.Create(ctx, &node.Auth, &node.Address)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer c.Close()
+			errs[i] = client.CheckSudo(ctx, c)
+		}(i, node)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("\t%s (%s): %v", nodes[i].Hostname, nodes[i].Address.IP, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("sudo is not possible on %d of %d nodes:\n%s", len(failed), len(nodes), strings.Join(failed, "\n"))
+	}
 	return nil
 }
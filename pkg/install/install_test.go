@@ -1,9 +1,16 @@
 package install
 
 import (
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -43,8 +50,246 @@ func TestWaitForNode(t *testing.T) {
 		Address: model.NewAddress("192.168.1.111", 22),
 	}
 	cf, _ := client.NewFakeClientFactory()
-	err := WaitForNode(cf, node, time.Second*10)
+	err := WaitForNode(context.Background(), cf, node, time.Second*10)
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+func TestWaitForAPI(t *testing.T) {
+	port, interval := APIPort, WaitForAPIInterval
+	defer func() { APIPort, WaitForAPIInterval = port, interval }()
+	WaitForAPIInterval = time.Millisecond
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	APIPort = l.Addr().(*net.TCPAddr).Port
+	node := &model.Node{Auth: model.Auth{ProxyJump: client.ProxyJumpNone}, Address: model.NewAddress("127.0.0.1", 22)}
+
+	if err = WaitForAPI(context.Background(), node, time.Second); err != nil {
+		t.Error(err)
+	}
+
+	l.Close()
+	if err = WaitForAPI(context.Background(), node, 50*time.Millisecond); err == nil {
+		t.Error("expected timeout when the API port is closed")
+	}
+}
+
+func TestCopyKubeconfig(t *testing.T) {
+	node := &model.Node{
+		Auth:    model.Auth{Type: model.AuthTypeSSHKey, User: "rancher", SSHKey: "~/.ssh/id_rsa"},
+		Address: model.NewAddress("192.168.1.111", 22),
+	}
+	cf, _ := client.NewFakeClientFactory(func(script *client.FakeScript) {
+		script.ExpectDownload(KubeconfigFile, "apiVersion: v1\n")
+	})
+
+	fn := misc.CreateTempFilename(os.TempDir(), "k3s-*.yaml")
+	defer os.Remove(fn)
+
+	if err := CopyKubeconfig(context.Background(), cf, fn, node); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "apiVersion: v1\n" {
+		t.Errorf("unexpected kubeconfig: %s", b)
+	}
+}
+
+func TestCheckSudo(t *testing.T) {
+	nodes := model.Nodes{
+		{Hostname: "node1", Address: model.NewAddress("10.0.0.1", 22)},
+		{Hostname: "node2", Address: model.NewAddress("10.0.0.2", 22)},
+	}
+	cf := &client.Factory{Create: func(ctx context.Context, auth *model.Auth, address *model.Address) (client.Client, error) {
+		c, _ := client.NewFakeClient(ctx, auth, address)
+		if address.IP == "10.0.0.2" {
+			c.(*client.FakeClient).FakeScript.Error = &client.SudoError{Result: &client.Result{Cmd: "sudo true", ExitStatus: 1}, Reason: "incorrect password"}
+		}
+		return c, nil
+	}}
+
+	err := CheckSudo(context.Background(), cf, nodes)
+
+	expected := "sudo is not possible on 1 of 2 nodes:\n\tnode2 (10.0.0.2): sudo failed for command 'sudo true': incorrect password"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got: %v", expected, err)
+	}
+}
+
+func TestK3sUpgradeTask_GetRemoteAssets(t *testing.T) {
+	nodes := model.Nodes{{Arch: "aarch64"}, {Arch: "armv7l"}, {Arch: "x86_64"}}
+	task := &K3sUpgradeTask{Version: "v1.17.2+k3s1", Nodes: nodes}
+
+	var filenames []string
+	for _, asset := range task.GetRemoteAssets() {
+		filenames = append(filenames, asset.Filename, asset.CheckSumFilename)
+	}
+
+	want := []string{"k3s-arm64", "sha256sum-arm64.txt", "k3s-armhf", "sha256sum-arm.txt", "k3s", "sha256sum-amd64.txt"}
+	if !reflect.DeepEqual(want, filenames) {
+		t.Errorf("expected: %v, actual: %v", want, filenames)
+	}
+	for i, node := range nodes {
+		if actual := k3sBinFilename(node); actual != want[i*2] {
+			t.Errorf("expected: %s, actual: %s", want[i*2], actual)
+		}
+	}
+}
+
+func TestK3sInstaller_Install(t *testing.T) {
+	node := &model.Node{Hostname: "k3s-node1", Arch: "aarch64", Address: model.NewAddress("10.0.0.1", 22)}
+	cf, script := client.NewFakeClientFactory()
+	task := &K3sUpgradeTask{Version: "v1.17.2+k3s1", Nodes: model.Nodes{node}, ClientFactory: cf}
+
+	installer := makeK3sUpgradeInstaller(task, os.TempDir(), node)
+	if err := installer.Install(context.Background(), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if dir, ok := script.FS.Files["/k3os/system/k3s/v1.17.2+k3s1"]; !ok || !dir.Mode.IsDir() {
+		t.Error("version directory not created")
+	}
+	if link := script.FS.Files["/k3os/system/k3s/current"]; link == nil || link.Link != "/k3os/system/k3s/v1.17.2+k3s1" {
+		t.Errorf("current should link to the new version, was: %v", link)
+	}
+}
+
+func TestWaitForNode_Faults(t *testing.T) {
+	interval := WaitForNodeInterval
+	defer func() { WaitForNodeInterval = interval }()
+	WaitForNodeInterval = time.Millisecond
+
+	node := &model.Node{Address: model.NewAddress("10.0.0.1", 22)}
+	cf, script := client.NewFakeClientFactory()
+	script.FailDial("10.0.0.1", 3)
+	if err := WaitForNode(context.Background(), cf, node, time.Second); err != nil {
+		t.Error(err)
+	}
+
+	script.FailAfterReboot("10.0.0.1", -1)
+	c, _ := cf.Create(context.Background(), &node.Auth, &node.Address)
+	if err := c.Cmd("sudo sh -c 'reboot -d 1 &'").Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	err := WaitForNode(context.Background(), cf, node, 50*time.Millisecond)
+	if err == nil || err.Error() != "timeout waiting for node: 10.0.0.1:22" {
+		t.Errorf("expected timeout, was: %v", err)
+	}
+}
+
+func TestFetchKubeconfig_Retry(t *testing.T) {
+	delay := KubeconfigRetryDelay
+	defer func() { KubeconfigRetryDelay = delay }()
+	KubeconfigRetryDelay = 0
+
+	node := &model.Node{Address: model.NewAddress("10.0.0.1", 22)}
+	cf, script := client.NewFakeClientFactory()
+	script.ExpectDownload(KubeconfigFile, "apiVersion: v1\n")
+	script.FailDial("10.0.0.1", 2)
+	script.Disconnect(KubeconfigFile, 5, 1)
+
+	fn := misc.CreateTempFilename(os.TempDir(), "k3s-*.yaml")
+	defer os.Remove(fn)
+
+	if err := FetchKubeconfig(context.Background(), cf, fn, node); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(fn); string(b) != "apiVersion: v1\n" {
+		t.Errorf("unexpected kubeconfig: %s", b)
+	}
+
+	script.Disconnect(KubeconfigFile, 5, -1)
+	err := FetchKubeconfig(context.Background(), cf, fn, node)
+	if err == nil || err.Error() != "failed to copy kubeconfig: failed to copy from /etc/rancher/k3s/k3s.yaml: connection lost" {
+		t.Errorf("expected copy to fail, was: %v", err)
+	}
+}
+
+func TestRun_PartialFailure(t *testing.T) {
+	delay := UploadRetryDelay
+	defer func() { UploadRetryDelay = delay }()
+	UploadRetryDelay = 0
+
+	nodes := model.Nodes{
+		{Hostname: "k3s-node1", Arch: "aarch64", Address: model.NewAddress("10.0.0.1", 22)},
+		{Hostname: "k3s-node2", Arch: "aarch64", Address: model.NewAddress("10.0.0.2", 22)},
+	}
+	cf, script := client.NewFakeClientFactory()
+	script.FailDial("10.0.0.2", -1)
+	script.Disconnect("~/k3s", 0, 1)
+	task := &K3sUpgradeTask{Version: "v1.17.2+k3s1", Nodes: nodes, ClientFactory: cf}
+
+	err := Run(context.Background(), (&K3sInstallerFactory{}).MakeInstallers(task, os.TempDir()), NewOutput(ioutil.Discard, true))
+	if err == nil {
+		t.Fatal("expected install of k3s-node2 to fail")
+	}
+	if !strings.Contains(err.Error(), "install failed for k3s-node2") || strings.Contains(err.Error(), "k3s-node1") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if link := script.FS.Files["/k3os/system/k3s/current"]; link == nil || link.Link != "/k3os/system/k3s/v1.17.2+k3s1" {
+		t.Errorf("k3s-node1 should be upgraded after a retried upload, current was: %v", link)
+	}
+}
+
+func TestRun_PartialFailure_OSInstall(t *testing.T) {
+	delay := UploadRetryDelay
+	defer func() { UploadRetryDelay = delay }()
+	UploadRetryDelay = 0
+
+	k3osNode := func(hostname, arch, ip string) *model.K3OSNode {
+		return &model.K3OSNode{Node: model.Node{Hostname: hostname, Arch: arch, Address: model.NewAddress(ip, 22)}}
+	}
+	cf, script := client.NewFakeClientFactory()
+	script.Disconnect("~/k3os-rootfs-arm64.tar.gz", 0, 1)
+	script.FailDial("10.0.0.2", -1)
+	script.Disconnect("~/k3os-rootfs-arm.tar.gz", 0, -1)
+	task := &OSInstallTask{
+		OSImageTask: OSImageTask{Version: model.DefaultK3OSVersion, ClientFactory: cf},
+		Server:      k3osNode("k3s-node1", "aarch64", "10.0.0.1"),
+		Agents: model.K3OSNodes{
+			k3osNode("k3s-node2", "aarch64", "10.0.0.2"),
+			k3osNode("k3s-node3", "armv7l", "10.0.0.3"),
+			k3osNode("k3s-node4", "aarch64", "10.0.0.4"),
+		},
+		Templates: &ConfigTemplates{},
+	}
+
+	var b strings.Builder
+	err := Run(context.Background(), (&OSInstallerFactory{}).MakeInstallers(task, os.TempDir()), NewOutput(&b, false))
+	if err == nil {
+		t.Fatal("expected install of k3s-node2 and k3s-node3 to fail")
+	}
+	for _, expected := range []string{
+		"k3s-node1 | Install OK",
+		"k3s-node2 | Install failed: failed to create SSH client",
+		"k3s-node3 | Install failed: failed to copy image file",
+		"k3s-node4 | Install OK",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected output to contain '%s', was:\n%s", expected, b.String())
+		}
+	}
+	if !strings.Contains(err.Error(), "install failed for k3s-node2") || !strings.Contains(err.Error(), "install failed for k3s-node3") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cf, script = client.NewFakeClientFactory()
+	script.Latency = time.Second
+	task.ClientFactory = cf
+	task.Agents = nil
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	b.Reset()
+	err = Run(ctx, (&OSInstallerFactory{}).MakeInstallers(task, os.TempDir()), NewOutput(&b, false))
+	if err == nil || !strings.Contains(b.String(), "k3s-node1 | Install aborted") {
+		t.Errorf("expected install of k3s-node1 to be aborted, error: %v output:\n%s", err, b.String())
+	}
+}
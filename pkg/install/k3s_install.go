@@ -1,13 +1,14 @@
 package install
 
 import (
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
 	"github.com/pkg/errors"
+	"io"
 	"net/url"
-	"strings"
 )
 
 const (
@@ -33,7 +34,7 @@ func (task *K3sUpgradeTask) GetRemoteAssets() model.RemoteAssets {
 	var remoteAssets model.RemoteAssets
 
 	for _, node := range task.Nodes {
-		fn := fmt.Sprintf(K3sBinFilenameTmpl, node.GetArch("arm64:-arm64", "arm:-armhf", "amd64:"))
+		fn := k3sBinFilename(node)
 		csfn := fmt.Sprintf(K3sBinCheckSumFilenameTmpl, node.GetArch())
 		remoteAssets = append(remoteAssets, &model.RemoteAsset{
 			Filename:         fn,
@@ -46,6 +47,11 @@ func (task *K3sUpgradeTask) GetRemoteAssets() model.RemoteAssets {
 	return remoteAssets
 }
 
+// k3sBinFilename k3s release binary filename for the node architecture: k3s, k3s-arm64 or k3s-armhf
+func k3sBinFilename(node *model.Node) string {
+	return fmt.Sprintf(K3sBinFilenameTmpl, node.GetArch("arm64:-arm64", "arm:-armhf", "amd64:"))
+}
+
 // K3sInstallerFactory factory for creating k3s upgrade installers
 type K3sInstallerFactory struct{}
 
@@ -87,38 +93,52 @@ type k3sInstaller struct {
 	resourceDir string
 }
 
-func (ins *k3sInstaller) Install() error {
+func (ins *k3sInstaller) Install(ctx context.Context, out io.Writer) error {
 	node := ins.node
 
-	nodeClient, err := ins.task.ClientFactory.Create(&node.Auth, &node.Address)
+	nodeClient, err := ins.task.ClientFactory.Create(ctx, &node.Auth, &node.Address)
 	if err != nil {
 		return err
 	}
+	defer nodeClient.Close()
 
 	// copy file
-	k3sBinFilename := fmt.Sprintf(K3sBinFilenameTmpl, node.GetArch("arm:armhf"))
-	k3sBinFilenamePath := ins.resourceDir + PathSeparatorStr + k3sBinFilename
-	nodeClient.Copy(k3sBinFilenamePath, "~/k3s")
-
-	script := nodeClient.Cmd("sudo mount -o remount rw /k3os/system")
-	script = script.Cmdf("sudo mkdir -p /k3os/system/k3s/%s", ins.task.Version)
-	script = script.Cmdf("sudo cp ~/k3s /k3os/system/k3s/%s/", ins.task.Version)
-	script = script.Cmdf("sudo chmod a+x /k3os/system/k3s/%s/k3s", ins.task.Version)
-	script = script.Cmd("sudo /etc/init.d/k3s-service stop")
-	script = script.Cmdf("sudo ln -sfn /k3os/system/k3s/%s /k3os/system/k3s/current", ins.task.Version)
-	script = script.Cmd("sudo sync")
-	script = script.Cmd("sudo reboot -d 1 &")
+	k3sBinFilenamePath := ins.resourceDir + PathSeparatorStr + k3sBinFilename(node)
+	if err = Upload(ctx, nodeClient, k3sBinFilenamePath, "~/k3s", out); err != nil {
+		return err
+	}
 
 	if ins.task.DryRun {
 		return nil
 	}
 
-	out, err := script.Output()
-	if err != nil {
-		stdErr := strings.TrimSpace(string(out))
-		fmt.Println(stdErr)
-		return errors.Wrap(err, stdErr)
+	versionDir := fmt.Sprintf("/k3os/system/k3s/%s", ins.task.Version)
+	fs := nodeClient.FS().Sudo()
+
+	if err = nodeClient.Cmd("sudo mount -o remount rw /k3os/system").Stream(ctx, out, out); err != nil {
+		return errors.Wrap(err, "install script failed")
+	}
+	if err = fs.MkdirAll(ctx, versionDir, 0755); err != nil {
+		return err
 	}
 
-	return nil
+	script := nodeClient.Cmdf("sudo cp ~/k3s %s/", versionDir)
+	script = script.Cmdf("sudo chmod a+x %s/k3s", versionDir)
+	script = script.Cmd("sudo /etc/init.d/k3s-service stop")
+	if err = script.Stream(ctx, out, out); err != nil {
+		return errors.Wrap(err, "install script failed")
+	}
+	if err = fs.Symlink(ctx, versionDir, "/k3os/system/k3s/current"); err != nil {
+		return err
+	}
+
+	return errors.Wrap(nodeClient.Cmd("sudo sync").Cmd("sudo sh -c 'reboot -d 1 &'").Stream(ctx, out, out), "install script failed")
+}
+
+// Hostname returns the hostname of the installed node, or its address if the hostname is unknown
+func (ins *k3sInstaller) Hostname() string {
+	if len(ins.node.Hostname) > 0 {
+		return ins.node.Hostname
+	}
+	return ins.node.Address.IP
 }
@@ -1,13 +1,14 @@
 package install
 
 import (
+	"context"
 	"fmt"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/config"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
 	"github.com/pkg/errors"
-	"strings"
+	"io"
 )
 
 const (
@@ -24,14 +25,16 @@ type ConfigTemplates struct {
 	ServerTmpl, AgentTmpl string
 }
 
-// HostnameSpec spec for generating hostnames (for nodes)
+// HostnameSpec spec for generating hostnames (for nodes), offset is added to the index when
+// adding nodes to an existing cluster
 type HostnameSpec struct {
 	Pattern, Prefix string
+	Offset          int
 }
 
 // GetHostname gets a generated hostname given the node list index
 func (h *HostnameSpec) GetHostname(index int) string {
-	return fmt.Sprintf(h.Pattern, h.Prefix, index)
+	return fmt.Sprintf(h.Pattern, h.Prefix, index+h.Offset)
 }
 
 // OSImageTask task for install or upgrade
@@ -172,33 +175,39 @@ type installer struct {
 }
 
 // Install installs k3OS
-func (ins *installer) Install() error {
+func (ins *installer) Install(ctx context.Context, out io.Writer) error {
 
-	sshClient, err := ins.task.ClientFactory.Create(&ins.target.Auth, &ins.target.Address)
-	misc.PanicOnError(err, "failed to create SSH client")
-
-	err = sshClient.Copy(ins.task.GetImageFilePath(ins.resourceDir, ins.target.GetArch()), fmt.Sprintf("~/%s", ins.task.GetImageFilename(ins.target.GetArch())))
-	misc.PanicOnError(err, "failed to copy image file")
-
-	err = sshClient.CopyBytes(ins.config, fmt.Sprintf("~/%s", "config.yaml"))
-	misc.PanicOnError(err, "failed to copy config file")
+	sshClient, err := ins.task.ClientFactory.Create(ctx, &ins.target.Auth, &ins.target.Address)
+	if err != nil {
+		return errors.Wrap(err, "failed to create SSH client")
+	}
+	defer sshClient.Close()
 
-	fn := ins.task.GetImageFilename(ins.target.GetArch())
-	script := sshClient.Cmdf("sudo tar zxvf %s --strip-components=1 -C /", fn)
-	script = script.Cmd("sudo cp config.yaml /k3os/system/config.yaml")
-	script = script.Cmd("sudo sync")
-	script = script.Cmd("sudo reboot -d 1 &")
+	err = Upload(ctx, sshClient, ins.task.GetImageFilePath(ins.resourceDir, ins.target.GetArch()), fmt.Sprintf("~/%s", ins.task.GetImageFilename(ins.target.GetArch())), out)
+	if err != nil {
+		return errors.Wrap(err, "failed to copy image file")
+	}
 
 	if ins.task.DryRun {
 		return nil
 	}
 
-	out, err := script.Output()
-	if err != nil {
-		stdErr := strings.TrimSpace(string(out))
-		fmt.Println(stdErr)
-		return errors.Wrap(err, stdErr)
+	fn := ins.task.GetImageFilename(ins.target.GetArch())
+	if err = sshClient.Cmdf("sudo tar zxvf %s --strip-components=1 -C /", fn).Stream(ctx, out, out); err != nil {
+		return errors.Wrap(err, "install script failed")
+	}
+
+	if err = sshClient.FS().Sudo().WriteFile(ctx, "/k3os/system/config.yaml", *ins.config, 0600, ""); err != nil {
+		return errors.Wrap(err, "failed to write config file")
 	}
 
-	return nil
+	return errors.Wrap(sshClient.Cmd("sudo sync").Cmd("sudo sh -c 'reboot -d 1 &'").Stream(ctx, out, out), "install script failed")
+}
+
+// Hostname returns the hostname of the installed node, or its address if the hostname is unknown
+func (ins *installer) Hostname() string {
+	if len(ins.target.Hostname) > 0 {
+		return ins.target.Hostname
+	}
+	return ins.target.Address.IP
 }
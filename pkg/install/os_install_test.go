@@ -1,6 +1,7 @@
 package install
 
 import (
+	"context"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
 	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
 	"github.com/TheNatureOfSoftware/k3pi/test"
@@ -49,7 +50,10 @@ func TestOSInstallerFactory_MakeInstallers(t *testing.T) {
 		Templates: &ConfigTemplates{},
 	}
 
-	resourceDir := MakeResourceDir(task)
+	resourceDir, err := MakeResourceDir(task)
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer os.RemoveAll(resourceDir)
 
 	installers := OSInstallerFactory{}.MakeInstallers(task, resourceDir)
@@ -72,7 +76,7 @@ func TestOSInstaller_Install(t *testing.T) {
 		Node:     *node,
 	}
 
-	cf, _ := client.NewFakeClientFactory()
+	cf, script := client.NewFakeClientFactory()
 	task := &OSInstallTask{
 		OSImageTask: OSImageTask{
 			Task:          model.Task{},
@@ -84,10 +88,21 @@ func TestOSInstaller_Install(t *testing.T) {
 		Templates: &ConfigTemplates{},
 	}
 
-	resourceDir := MakeResourceDir(task)
+	resourceDir, err := MakeResourceDir(task)
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer os.RemoveAll(resourceDir)
 
 	installer := makeInstaller(task, &server, resourceDir, false)
 
-	_ = installer.Install()
+	_ = installer.Install(context.Background(), os.Stdout)
+
+	config, ok := script.FS.Files["/k3os/system/config.yaml"]
+	if !ok {
+		t.Fatal("config.yaml not written")
+	}
+	if config.Mode != 0600 || config.Owner != "root" {
+		t.Errorf("config.yaml should only be readable by root, mode: %v owner: %s", config.Mode, config.Owner)
+	}
 }
@@ -0,0 +1,122 @@
+package install
+
+import (
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/dustin/go-humanize"
+	"io"
+	"strings"
+	"sync"
+)
+
+// DefaultTailLines lines kept per node for the failure report in quiet mode
+const DefaultTailLines = 20
+
+// Output output from installers running in parallel, lines are prefixed with the node hostname.
+// In quiet mode command output is not shown, only the last lines are kept for the failure report.
+type Output struct {
+	W         io.Writer
+	Quiet     bool
+	TailLines int
+	m         sync.Mutex
+}
+
+// NewOutput creates output writing to w
+func NewOutput(w io.Writer, quiet bool) *Output {
+	return &Output{W: w, Quiet: quiet, TailLines: DefaultTailLines}
+}
+
+// Node creates a writer for the output of a node
+func (o *Output) Node(hostname string) *NodeOutput {
+	return &NodeOutput{output: o, hostname: hostname}
+}
+
+func (o *Output) println(hostname, line string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	_, _ = fmt.Fprintf(o.W, "%s | %s\n", hostname, line)
+}
+
+// NodeOutput line buffered command output for one node
+type NodeOutput struct {
+	output   *Output
+	hostname string
+	partial  []byte
+	tail     []string
+	m        sync.Mutex
+}
+
+// Write writes complete lines, a partial line is kept until it's completed or flushed
+func (n *NodeOutput) Write(p []byte) (int, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	n.partial = append(n.partial, p...)
+	for {
+		i := strings.IndexByte(string(n.partial), '\n')
+		if i < 0 {
+			break
+		}
+		n.line(strings.TrimRight(string(n.partial[:i]), "\r"))
+		n.partial = n.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes a remaining partial line
+func (n *NodeOutput) Flush() {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if len(n.partial) > 0 {
+		n.line(string(n.partial))
+		n.partial = nil
+	}
+}
+
+// Status writes a status line, shown also in quiet mode
+func (n *NodeOutput) Status(format string, a ...interface{}) {
+	n.output.println(n.hostname, fmt.Sprintf(format, a...))
+}
+
+// Tail returns the last lines of output
+func (n *NodeOutput) Tail() []string {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return append([]string{}, n.tail...)
+}
+
+func (n *NodeOutput) line(line string) {
+	n.tail = append(n.tail, line)
+	if max := n.output.TailLines; max > 0 && len(n.tail) > max {
+		n.tail = n.tail[len(n.tail)-max:]
+	}
+	if !n.output.Quiet {
+		n.output.println(n.hostname, line)
+	}
+}
+
+// status writes a status line to a node output, shown also in quiet mode, or to any other writer
+func status(out io.Writer, format string, a ...interface{}) {
+	if n, ok := out.(*NodeOutput); ok {
+		n.Status(format, a...)
+		return
+	}
+	_, _ = fmt.Fprintf(out, format+"\n", a...)
+}
+
+// uploadProgress writes upload progress for every 10 percent
+func uploadProgress(out io.Writer, name string) client.ProgressFunc {
+	last := -1
+	return func(p client.Progress) {
+		percent := p.Percent()
+		if last == -1 && p.Resumed > 0 {
+			status(out, "resuming upload of %s at %s", name, humanize.IBytes(uint64(p.Resumed)))
+		}
+		if last != -1 && percent/10 == last/10 {
+			return
+		}
+		last = percent
+		status(out, "upload %s %3d%% %s/%s", name, percent, humanize.IBytes(uint64(p.Done)), humanize.IBytes(uint64(p.Size)))
+	}
+}
@@ -0,0 +1,117 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/mocks"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/misc"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestNodeOutput_Write(t *testing.T) {
+	var b bytes.Buffer
+	out := NewOutput(&b, false).Node("k3s-node1")
+
+	_, _ = out.Write([]byte("usr/bin/"))
+	_, _ = out.Write([]byte("k3s\r\nusr/lib/\n"))
+	assert.Equal(t, "k3s-node1 | usr/bin/k3s\nk3s-node1 | usr/lib/\n", b.String())
+
+	_, _ = out.Write([]byte("partial"))
+	out.Flush()
+	assert.True(t, strings.HasSuffix(b.String(), "k3s-node1 | partial\n"))
+}
+
+func TestNodeOutput_Quiet(t *testing.T) {
+	var b bytes.Buffer
+	output := NewOutput(&b, true)
+	output.TailLines = 2
+	out := output.Node("k3s-node1")
+
+	_, _ = out.Write([]byte("one\ntwo\nthree\n"))
+	out.Status("Install OK")
+
+	assert.Equal(t, "k3s-node1 | Install OK\n", b.String())
+	assert.Equal(t, []string{"two", "three"}, out.Tail())
+}
+
+func TestRun_Quiet(t *testing.T) {
+	ok := &mocks.Installer{}
+	ok.On("Hostname").Return("k3s-node1")
+	ok.On("Install", mock.Anything, mock.Anything).Return(func(ctx context.Context, out io.Writer) error {
+		_, _ = fmt.Fprintln(out, "extracting ok")
+		return nil
+	})
+	failing := &mocks.Installer{}
+	failing.On("Hostname").Return("k3s-node2")
+	failing.On("Install", mock.Anything, mock.Anything).Return(func(ctx context.Context, out io.Writer) error {
+		_, _ = fmt.Fprintln(out, "tar: no space left on device")
+		return fmt.Errorf("exit status 2")
+	})
+
+	var b bytes.Buffer
+	err := Run(context.Background(), model.Installers{ok, failing}, NewOutput(&b, true))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "install failed for k3s-node2")
+	assert.NotContains(t, b.String(), "extracting ok")
+	assert.Contains(t, b.String(), "k3s-node2 | tar: no space left on device")
+}
+
+func TestRun_Interrupted(t *testing.T) {
+	notStarted := &mocks.Installer{}
+	notStarted.On("Hostname").Return("k3s-node1")
+
+	stop := make(chan struct{})
+	close(stop)
+	err := Run(misc.WithStop(context.Background(), stop), model.Installers{notStarted}, NewOutput(ioutil.Discard, false))
+	assert.EqualError(t, err, "install interrupted, 0 of 1 nodes installed")
+	notStarted.AssertNotCalled(t, "Install", mock.Anything, mock.Anything)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	aborted := &mocks.Installer{}
+	aborted.On("Hostname").Return("k3s-node2")
+	aborted.On("Install", mock.Anything, mock.Anything).Return(func(ctx context.Context, out io.Writer) error {
+		cancel()
+		return ctx.Err()
+	})
+
+	var b bytes.Buffer
+	err = Run(ctx, model.Installers{aborted}, NewOutput(&b, false))
+	assert.EqualError(t, err, "install interrupted, 0 of 1 nodes installed")
+	assert.Contains(t, b.String(), "k3s-node2 | Install aborted")
+}
+
+func TestUpload_Retry(t *testing.T) {
+	delay := UploadRetryDelay
+	defer func() { UploadRetryDelay = delay }()
+	UploadRetryDelay = 0
+
+	progress := func(ctx context.Context, filename, remotePath string, progress client.ProgressFunc) error {
+		progress(client.Progress{RemotePath: remotePath, Done: 4 << 20, Resumed: 4 << 20, Size: 10 << 20})
+		progress(client.Progress{RemotePath: remotePath, Done: 9 << 19, Resumed: 4 << 20, Size: 10 << 20})
+		progress(client.Progress{RemotePath: remotePath, Done: 10 << 20, Resumed: 4 << 20, Size: 10 << 20})
+		return nil
+	}
+	c := &mocks.Client{}
+	c.On("Copy", mock.Anything, "/tmp/k3s-arm64", "~/k3s", mock.Anything).Return(fmt.Errorf("connection lost")).Once()
+	c.On("Copy", mock.Anything, "/tmp/k3s-arm64", "~/k3s", mock.Anything).Return(progress).Once()
+
+	var b bytes.Buffer
+	err := Upload(context.Background(), c, "/tmp/k3s-arm64", "~/k3s", NewOutput(&b, true).Node("k3s-node1"))
+
+	assert.NoError(t, err)
+	c.AssertExpectations(t)
+	assert.Equal(t, `k3s-node1 | upload of k3s-arm64 failed, retrying (2/3): connection lost
+k3s-node1 | resuming upload of k3s-arm64 at 4.0 MiB
+k3s-node1 | upload k3s-arm64  40% 4.0 MiB/10 MiB
+k3s-node1 | upload k3s-arm64 100% 10 MiB/10 MiB
+`, b.String())
+}
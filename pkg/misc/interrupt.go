@@ -0,0 +1,134 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package misc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type stopKey struct{}
+
+// WithInterrupt returns a context that stops on the first SIGINT or SIGTERM, no new work should be
+// started but running work is completed, see Stopping. It's cancelled on the second signal or when
+// the timeout is exceeded, zero timeout is no limit.
+func WithInterrupt(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	if timeout > 0 {
+		var cancelCtx context.CancelFunc
+		ctx, cancelCtx = context.WithTimeout(ctx, timeout)
+		cancelParent := cancel
+		cancel = func() {
+			cancelCtx()
+			cancelParent()
+		}
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case <-signals:
+			fmt.Fprintln(os.Stderr, "Interrupted, waiting for running nodes to finish, interrupt again to abort")
+			close(stop)
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-signals:
+			fmt.Fprintln(os.Stderr, "Aborting")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return WithStop(ctx, stop), cancel
+}
+
+// stopper the stop channel of a context and the stopping channels handed out for it, one per done
+// channel of the contexts derived from it
+type stopper struct {
+	stop     <-chan struct{}
+	m        sync.Mutex
+	stopping map[<-chan struct{}]chan struct{}
+}
+
+// WithStop returns a context where Stopping returns stop
+func WithStop(parent context.Context, stop <-chan struct{}) context.Context {
+	return context.WithValue(parent, stopKey{}, &stopper{stop: stop, stopping: make(map[<-chan struct{}]chan struct{})})
+}
+
+// Stopping returns a channel that's closed when no new work should be started, that is when the
+// context is stopped or done. Calls with the same context share the channel.
+func Stopping(ctx context.Context) <-chan struct{} {
+	s, ok := ctx.Value(stopKey{}).(*stopper)
+	if !ok {
+		return ctx.Done()
+	}
+
+	done := ctx.Done()
+	s.m.Lock()
+	defer s.m.Unlock()
+	if stopping, ok := s.stopping[done]; ok {
+		return stopping
+	}
+	stopping := make(chan struct{})
+	s.stopping[done] = stopping
+	go func() {
+		select {
+		case <-s.stop:
+		case <-done:
+		}
+		close(stopping)
+		// later calls make a new channel, it's closed right away
+		s.m.Lock()
+		delete(s.stopping, done)
+		s.m.Unlock()
+	}()
+	return stopping
+}
+
+// Stopped returns true if no new work should be started
+func Stopped(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	s, ok := ctx.Value(stopKey{}).(*stopper)
+	if !ok {
+		return false
+	}
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
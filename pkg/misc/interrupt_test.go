@@ -0,0 +1,106 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package misc
+
+import (
+	"context"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if Stopped(ctx) {
+		t.Error("context not stopped")
+	}
+	cancel()
+	if !Stopped(ctx) {
+		t.Error("done context is stopped")
+	}
+
+	stop := make(chan struct{})
+	ctx = WithStop(context.Background(), stop)
+	stopping := Stopping(ctx)
+	close(stop)
+	select {
+	case <-stopping:
+	case <-time.After(time.Second):
+		t.Fatal("stopping not closed")
+	}
+	if !Stopped(ctx) || ctx.Err() != nil {
+		t.Error("stopped context should not be done")
+	}
+}
+
+func TestStopping_Shared(t *testing.T) {
+	stop := make(chan struct{})
+	ctx := WithStop(context.Background(), stop)
+	stopping := Stopping(ctx)
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		if Stopping(ctx) != stopping {
+			t.Fatal("calls with the same context should share the channel")
+		}
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines started", n-goroutines)
+	}
+
+	close(stop)
+	select {
+	case <-Stopping(ctx):
+	case <-time.After(time.Second):
+		t.Fatal("stopping not closed")
+	}
+}
+
+func TestWithInterrupt(t *testing.T) {
+	ctx, cancel := WithInterrupt(context.Background(), 0)
+	defer cancel()
+
+	_ = syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	select {
+	case <-Stopping(ctx):
+	case <-time.After(time.Second):
+		t.Fatal("not stopped on first interrupt")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("cancelled on first interrupt")
+	}
+
+	_ = syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not cancelled on second interrupt")
+	}
+
+	ctx, cancel = WithInterrupt(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded: %v", ctx.Err())
+	}
+}
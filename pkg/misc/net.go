@@ -24,9 +24,7 @@ THE SOFTWARE.
 package misc
 
 import (
-	"fmt"
-	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
-	"github.com/pkg/errors"
+	"context"
 	"net"
 	"os/exec"
 )
@@ -64,9 +62,9 @@ type pong struct {
 	Alive bool
 }
 
-func ping(pingChan <-chan string, pongChan chan<- pong) {
+func ping(ctx context.Context, pingChan <-chan string, pongChan chan<- pong) {
 	for ip := range pingChan {
-		_, err := exec.Command("ping", "-c1", "-t1", ip).Output()
+		_, err := exec.CommandContext(ctx, "ping", "-c1", "-t1", ip).Output()
 		var alive bool
 		if err != nil {
 			alive = false
@@ -91,7 +89,7 @@ func receivePong(pongNum int, pongChan <-chan pong, doneChan chan<- []pong) {
 
 // HostScanner scans for hosts
 type HostScanner interface {
-	ScanForAliveHosts(cidr string) (*[]string, error)
+	ScanForAliveHosts(ctx context.Context, cidr string) (*[]string, error)
 }
 
 // NewHostScanner factory method for a host scanner
@@ -101,8 +99,8 @@ func NewHostScanner() HostScanner {
 
 type hostScanner struct{}
 
-// ScanForAliveHosts scans for all hosts that are alive
-func (h *hostScanner) ScanForAliveHosts(cidr string) (*[]string, error) {
+// ScanForAliveHosts scans for all hosts that are alive, stops pinging when ctx is done
+func (h *hostScanner) ScanForAliveHosts(ctx context.Context, cidr string) (*[]string, error) {
 	hosts, _ := hosts(cidr)
 	concurrentMax := 50
 	pingChan := make(chan string, concurrentMax)
@@ -110,7 +108,7 @@ func (h *hostScanner) ScanForAliveHosts(cidr string) (*[]string, error) {
 	doneChan := make(chan []pong)
 
 	for i := 0; i < concurrentMax; i++ {
-		go ping(pingChan, pongChan)
+		go ping(ctx, pingChan, pongChan)
 	}
 
 	go receivePong(len(hosts), pongChan, doneChan)
@@ -118,30 +116,29 @@ func (h *hostScanner) ScanForAliveHosts(cidr string) (*[]string, error) {
 	for _, ip := range hosts {
 		pingChan <- ip
 	}
+	close(pingChan)
 
 	var aliveHosts []string
 	for _, h := range <-doneChan {
 		aliveHosts = append(aliveHosts, h.IP)
 	}
 
-	return &aliveHosts, nil
+	return &aliveHosts, ctx.Err()
 }
 
-// CopyKubeconfig copies kubeconfig from server node
-func CopyKubeconfig(kubeconfigFile string, node *model.Node) error {
+// NewCIDRHostScanner factory method for a host scanner that returns all hosts in the CIDR without
+// pinging them, for hosts only reachable through a jump host
+func NewCIDRHostScanner() HostScanner {
+	return &cidrHostScanner{}
+}
 
-	out, err := exec.Command(
-		"scp",
-		"-o",
-		"StrictHostKeyChecking=no",
-		"-P",
-		fmt.Sprintf("%d", node.Address.Port),
-		fmt.Sprintf("rancher@%s:/etc/rancher/k3s/k3s.yaml", node.Address.IP),
-		kubeconfigFile).CombinedOutput()
+type cidrHostScanner struct{}
 
+// ScanForAliveHosts returns all hosts in the CIDR
+func (h *cidrHostScanner) ScanForAliveHosts(ctx context.Context, cidr string) (*[]string, error) {
+	hosts, err := hosts(cidr)
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("failed to copy kubeconfig, %s", out))
+		return nil, err
 	}
-
-	return nil
+	return &hosts, nil
 }
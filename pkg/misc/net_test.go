@@ -24,15 +24,14 @@ THE SOFTWARE.
 package misc
 
 import (
-	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
-	"os"
+	"context"
 	"testing"
 )
 
 func TestHostScanner_ScanForAliveHosts_Localhost(t *testing.T) {
 	scanner := NewHostScanner()
 
-	alive, err := scanner.ScanForAliveHosts("127.0.0.1/32")
+	alive, err := scanner.ScanForAliveHosts(context.Background(), "127.0.0.1/32")
 	if err != nil {
 		t.Error(err)
 	}
@@ -43,7 +42,7 @@ func TestHostScanner_ScanForAliveHosts_Localhost(t *testing.T) {
 func TestHostScanner_ScanForAliveHosts_Invalid_Cidr(t *testing.T) {
 	scanner := NewHostScanner()
 
-	alive, err := scanner.ScanForAliveHosts("I'm not a CIDR expr")
+	alive, err := scanner.ScanForAliveHosts(context.Background(), "I'm not a CIDR expr")
 	if err != nil {
 		t.Error(err)
 	}
@@ -57,19 +56,13 @@ func verifyNumOfHosts(want int, found int, t *testing.T) {
 	}
 }
 
-func TestCopyKubeconfig(t *testing.T) {
-	t.Skip("manual test")
-
-	node := &model.Node{
-		Address: model.ParseAddress("192.168.1.128:22"),
-	}
-
-	fn := CreateTempFilename(os.TempDir(), "k3s-*.yaml")
-	defer os.RemoveAll(fn)
-
-	err := CopyKubeconfig(fn, node)
+func TestCIDRHostScanner_ScanForAliveHosts(t *testing.T) {
+	scanner := NewCIDRHostScanner()
 
+	alive, err := scanner.ScanForAliveHosts(context.Background(), "10.0.0.0/29")
 	if err != nil {
 		t.Error(err)
 	}
+
+	verifyNumOfHosts(6, len(*alive), t)
 }
@@ -0,0 +1,58 @@
+/*
+Copyright © 2019 The Nature of Software Nordic AB <lars@thenatureofsoftware.se>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package misc
+
+import (
+	"context"
+	"golang.org/x/term"
+	"time"
+)
+
+// ResizePollInterval how often the terminal size is checked where there is no resize signal
+var ResizePollInterval = 250 * time.Millisecond
+
+// TerminalResized returns a channel receiving when the size of the terminal changes, until ctx is done.
+// Windows has no SIGWINCH, the size is polled.
+func TerminalResized(ctx context.Context, fd int) <-chan struct{} {
+	resized := make(chan struct{}, 1)
+	go func() {
+		width, height, _ := term.GetSize(fd)
+		for {
+			select {
+			case <-time.After(ResizePollInterval):
+			case <-ctx.Done():
+				return
+			}
+			w, h, err := term.GetSize(fd)
+			if err != nil || (w == width && h == height) {
+				continue
+			}
+			width, height = w, h
+			select {
+			case resized <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return resized
+}
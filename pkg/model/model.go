@@ -1,7 +1,9 @@
 package model
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -13,8 +15,23 @@ const (
 	AuthTypeSSHKey = "ssh-key"
 	// AuthTypeBasicAuth username / password authentication
 	AuthTypeBasicAuth = "basic-auth"
+	// DiskTypeSD SD card or eMMC
+	DiskTypeSD = "sd"
+	// DiskTypeUSB disk attached over USB
+	DiskTypeUSB = "usb"
+	// DiskTypeNVMe NVMe disk
+	DiskTypeNVMe = "nvme"
+	// DiskTypeOther any other disk
+	DiskTypeOther = "other"
+	// K3sRoleServer node runs k3s as server
+	K3sRoleServer = "server"
+	// K3sRoleAgent node runs k3s as agent
+	K3sRoleAgent = "agent"
 )
 
+// DefaultArchitectures architectures supported by default, as returned by Node.GetArch
+var DefaultArchitectures = []string{"arm", "arm64", "amd64"}
+
 // SSHKeys set of SSH keys
 type SSHKeys []string
 
@@ -42,7 +59,10 @@ type InstallationTarget struct {
 
 // Installer an installer that installs
 type Installer interface {
-	Install() error
+	// Install installs, command output is streamed to out. Should stop when ctx is done.
+	Install(ctx context.Context, out io.Writer) error
+	// Hostname of the node that is installed
+	Hostname() string
 }
 
 // Installers a set of installers
@@ -77,6 +97,8 @@ func (infs *InstallerFactoriesT) GetFactory(task interface{}) InstallerFactory {
 type Address struct {
 	IP   string `json:"ip"`
 	Port int    `json:"port"`
+	// HostKey pinned ssh host key, "<type> <base64 key>"
+	HostKey string `json:"host_key,omitempty"`
 }
 
 // String address as string <ip>:<port>
@@ -117,6 +139,14 @@ type Auth struct {
 	User     string `json:"user"`
 	Password string `json:"password,omitempty"`
 	SSHKey   string `json:"ssh_key,omitempty"`
+	// SSHCert OpenSSH certificate for SSHKey, defaults to "<ssh key>-cert.pub" if it exists
+	SSHCert string `json:"ssh_cert,omitempty"`
+	// SudoPassword password for sudo, defaults to Password for basic auth
+	SudoPassword string `json:"sudo_password,omitempty"`
+	// SSHKeys more ssh keys tried after SSHKey
+	SSHKeys []string `json:"ssh_keys,omitempty"`
+	// ProxyJump jump hosts used to reach the node, "[user@]host[:port],..." or "none"
+	ProxyJump string `json:"proxy_jump,omitempty"`
 }
 
 // Auths authentications
@@ -124,10 +154,46 @@ type Auths []*Auth
 
 // Node represents a machine witn an IP and authentication for SSH access
 type Node struct {
-	Hostname string  `json:"hostname"`
-	Address  Address `json:"address"`
-	Auth     Auth    `json:"auth"`
-	Arch     string  `json:"arch"`
+	Hostname string            `json:"hostname"`
+	Address  Address           `json:"address"`
+	Auth     Auth              `json:"auth"`
+	Arch     string            `json:"arch"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Facts    *Facts            `json:"facts,omitempty"`
+	Vanished bool              `json:"vanished,omitempty"`
+}
+
+// Facts hardware and OS facts gathered from a node during scan
+type Facts struct {
+	Model         string            `json:"model,omitempty"`
+	SerialNumber  string            `json:"serial_number,omitempty"`
+	CPUs          int               `json:"cpus,omitempty"`
+	Memory        uint64            `json:"memory,omitempty"`
+	RootDisk      *Disk             `json:"root_disk,omitempty"`
+	OSRelease     string            `json:"os_release,omitempty"`
+	KernelVersion string            `json:"kernel_version,omitempty"`
+	MACAddresses  map[string]string `json:"mac_addresses,omitempty"`
+	K3OSVersion   string            `json:"k3os_version,omitempty"`
+	K3s           *K3s              `json:"k3s,omitempty"`
+}
+
+// K3s k3s found on a node, role is server or agent and server URL is the server an agent joins
+type K3s struct {
+	Version   string `json:"version,omitempty"`
+	Role      string `json:"role,omitempty"`
+	ServerURL string `json:"server_url,omitempty"`
+}
+
+// Disk a block device, size in bytes
+type Disk struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+	Type string `json:"type"`
+}
+
+// IsInstalled returns true if the scan found k3OS or k3s on the node
+func (n *Node) IsInstalled() bool {
+	return n.Facts != nil && (len(n.Facts.K3OSVersion) > 0 || n.Facts.K3s != nil)
 }
 
 // GetArch returns the architecture for the given node. Alternative architecture identifiers can be supplied
@@ -155,6 +221,17 @@ func (n *Node) GetArch(alternatives ...string) string {
 	return arch
 }
 
+// HasArch returns true if the node architecture is one of the given architectures, either as returned
+// by GetArch or as reported by uname -m
+func (n *Node) HasArch(architectures []string) bool {
+	for _, arch := range architectures {
+		if arch == n.Arch || arch == n.GetArch() {
+			return true
+		}
+	}
+	return false
+}
+
 // Nodes slice of nodes
 type Nodes []*Node
 
@@ -181,6 +258,7 @@ type K3OSNode struct {
 	Node
 	ServerIP, Token   string
 	SSHAuthorizedKeys []string
+	TrustedUserCAKeys []string
 }
 
 // K3OSNodes k3OS nodes
@@ -193,6 +271,13 @@ func (targets *K3OSNodes) SetServerIP(serverIP string) {
 	}
 }
 
+// SetTrustedUserCAKeys sets the CA keys trusted for ssh user certificates on all nodes
+func (targets *K3OSNodes) SetTrustedUserCAKeys(caKeys []string) {
+	for _, target := range *targets {
+		target.TrustedUserCAKeys = caKeys
+	}
+}
+
 // NewK3OSNode factory method for creating a new k3OS node
 func NewK3OSNode(node *Node, sshAuthorizedKeys SSHKeys, token string) *K3OSNode {
 	return &K3OSNode{
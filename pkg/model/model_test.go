@@ -106,3 +106,23 @@ func TestTargets_SetServerIP(t *testing.T) {
 		}
 	}
 }
+
+func TestNode_HasArch(t *testing.T) {
+	tests := []struct {
+		arch          string
+		architectures []string
+		want          bool
+	}{
+		{"x86_64", DefaultArchitectures, true},
+		{"x86_64", []string{"arm", "arm64"}, false},
+		{"armv6l", DefaultArchitectures, true},
+		{"aarch64", []string{"aarch64"}, true},
+		{"mips", DefaultArchitectures, false},
+	}
+	for _, tt := range tests {
+		n := &Node{Arch: tt.arch}
+		if actual := n.HasArch(tt.architectures); actual != tt.want {
+			t.Errorf(msg, tt.want, actual)
+		}
+	}
+}
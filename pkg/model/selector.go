@@ -0,0 +1,334 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Selector operators
+const (
+	OpEquals       = "="
+	OpDoubleEquals = "=="
+	OpNotEquals    = "!="
+	OpMatches      = "~="
+	OpGreaterEqual = ">="
+	OpLessEqual    = "<="
+	OpGreater      = ">"
+	OpLess         = "<"
+	OpExists       = "exists"
+	OpNotExists    = "!exists"
+)
+
+// operators ordered so that two character operators are tried first
+var operators = []string{OpDoubleEquals, OpNotEquals, OpMatches, OpGreaterEqual, OpLessEqual, OpEquals, OpGreater, OpLess}
+
+var quantitySuffixes = map[string]float64{
+	"":   1,
+	"K":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+}
+
+const memoryRoundUp = 512 << 20
+
+// Requirement a single selector requirement, <key><operator><value>
+type Requirement struct {
+	Key, Operator, Value string
+}
+
+// Selector selects nodes matching all requirements, an empty selector matches all nodes.
+//
+// Keys are resolved from the node and its facts:
+//
+//	hostname, ip, user, arch (arm, arm64, amd64 or uname -m), model, serial, cpus, os, kernel,
+//	disk_type, disk (root disk size), mem (total memory rounded up to 512Mi since the kernel
+//	and firmware reserve some of it), k3os and k3s (installed versions) and k3s_role (server or agent).
+//
+// Any other key is resolved from the node labels.
+//
+// Operators: = (or ==), !=, ~= (regular expression), >=, <=, >, < (numbers with optional
+// K, M, G, T, Ki, Mi, Gi or Ti suffix), <key> (exists) and !<key> (not exists).
+//
+// Example: arch=arm64,model~="Pi 4",mem>=4Gi
+type Selector []*Requirement
+
+// ParseSelector parses a selector expression
+func ParseSelector(expr string) (Selector, error) {
+	var selector Selector
+	for _, term := range splitTerms(expr) {
+		term = strings.TrimSpace(term)
+		if len(term) == 0 {
+			continue
+		}
+		requirement, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, requirement)
+	}
+	return selector, nil
+}
+
+// splitTerms splits on commas not within double quotes
+func splitTerms(expr string) []string {
+	var terms []string
+	var quoted bool
+	start := 0
+	for i, c := range expr {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			terms = append(terms, expr[start:i])
+			start = i + 1
+		}
+	}
+	return append(terms, expr[start:])
+}
+
+func parseRequirement(term string) (*Requirement, error) {
+	idx := strings.IndexAny(term, "=!~<>")
+	if idx == -1 {
+		return &Requirement{Key: term, Operator: OpExists}, nil
+	}
+
+	if idx == 0 && term[0] == '!' && !strings.ContainsAny(term[1:], "=!~<>") {
+		return &Requirement{Key: strings.TrimSpace(term[1:]), Operator: OpNotExists}, nil
+	}
+
+	key := strings.TrimSpace(term[:idx])
+	if len(key) == 0 {
+		return nil, fmt.Errorf("selector requirement '%s' is missing a key", term)
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(term[idx:], op) {
+			value := strings.TrimSpace(term[idx+len(op):])
+			if strings.HasPrefix(value, `"`) {
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return nil, fmt.Errorf("selector requirement '%s' has an invalid quoted value", term)
+				}
+				value = unquoted
+			}
+			if op == OpDoubleEquals {
+				op = OpEquals
+			}
+			requirement := &Requirement{Key: key, Operator: op, Value: value}
+			if err := requirement.validate(); err != nil {
+				return nil, err
+			}
+			return requirement, nil
+		}
+	}
+
+	return nil, fmt.Errorf("selector requirement '%s' has an unknown operator", term)
+}
+
+func (r *Requirement) validate() error {
+	switch r.Operator {
+	case OpMatches:
+		if _, err := regexp.Compile(r.Value); err != nil {
+			return fmt.Errorf("selector requirement '%s' has an invalid regular expression: %v", r, err)
+		}
+	case OpGreaterEqual, OpLessEqual, OpGreater, OpLess:
+		if _, err := ParseQuantity(r.Value); err != nil {
+			return fmt.Errorf("selector requirement '%s' has an invalid number: %v", r, err)
+		}
+	}
+	return nil
+}
+
+// String requirement as string
+func (r *Requirement) String() string {
+	switch r.Operator {
+	case OpExists:
+		return r.Key
+	case OpNotExists:
+		return "!" + r.Key
+	default:
+		return r.Key + r.Operator + r.Value
+	}
+}
+
+// Matches returns true if the node fulfills the requirement
+func (r *Requirement) Matches(node *Node) bool {
+	values, ok := node.selectorValues(r.Key)
+
+	switch r.Operator {
+	case OpExists:
+		return ok
+	case OpNotExists:
+		return !ok
+	}
+
+	if !ok {
+		return r.Operator == OpNotEquals
+	}
+
+	switch r.Operator {
+	case OpEquals:
+		return contains(values, r.Value)
+	case OpNotEquals:
+		return !contains(values, r.Value)
+	case OpMatches:
+		re := regexp.MustCompile(r.Value)
+		for _, v := range values {
+			if re.MatchString(v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	actual, err := ParseQuantity(values[0])
+	if err != nil {
+		return false
+	}
+	wanted, _ := ParseQuantity(r.Value)
+
+	switch r.Operator {
+	case OpGreaterEqual:
+		return actual >= wanted
+	case OpLessEqual:
+		return actual <= wanted
+	case OpGreater:
+		return actual > wanted
+	case OpLess:
+		return actual < wanted
+	}
+
+	return false
+}
+
+// String selector as string
+func (s Selector) String() string {
+	var terms []string
+	for _, r := range s {
+		terms = append(terms, r.String())
+	}
+	return strings.Join(terms, ",")
+}
+
+// Matches returns true if the node fulfills all requirements
+func (s Selector) Matches(node *Node) bool {
+	for _, r := range s {
+		if !r.Matches(node) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns all nodes matching the selector
+func (nodes *Nodes) Select(selector Selector) Nodes {
+	var selected Nodes
+	for _, node := range *nodes {
+		if selector.Matches(node) {
+			selected = append(selected, node)
+		}
+	}
+	return selected
+}
+
+// ParseQuantity parses a number with an optional K, M, G, T, Ki, Mi, Gi or Ti suffix
+func ParseQuantity(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-'
+	})
+	number, suffix := s, ""
+	if i != -1 {
+		number, suffix = s[:i], s[i:]
+	}
+	multiplier, ok := quantitySuffixes[suffix]
+	if !ok {
+		return 0, fmt.Errorf("unknown suffix '%s' in '%s'", suffix, s)
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, err
+	}
+	return f * multiplier, nil
+}
+
+// selectorValues resolves the value(s) of a selector key, returns false if the node has no such value
+func (n *Node) selectorValues(key string) ([]string, bool) {
+	var values []string
+	facts := n.Facts
+	if facts == nil {
+		facts = &Facts{}
+	}
+
+	switch key {
+	case "hostname":
+		values = []string{n.Hostname}
+	case "ip":
+		values = []string{n.Address.IP}
+	case "user":
+		values = []string{n.Auth.User}
+	case "arch":
+		values = []string{n.GetArch(), n.Arch}
+	case "model":
+		values = []string{facts.Model}
+	case "serial":
+		values = []string{facts.SerialNumber}
+	case "os":
+		values = []string{facts.OSRelease}
+	case "kernel":
+		values = []string{facts.KernelVersion}
+	case "cpus":
+		if facts.CPUs > 0 {
+			values = []string{strconv.Itoa(facts.CPUs)}
+		}
+	case "mem":
+		if facts.Memory > 0 {
+			rounded := (facts.Memory + memoryRoundUp - 1) / memoryRoundUp * memoryRoundUp
+			values = []string{strconv.FormatUint(rounded, 10)}
+		}
+	case "disk":
+		if facts.RootDisk != nil {
+			values = []string{strconv.FormatUint(facts.RootDisk.Size, 10)}
+		}
+	case "disk_type":
+		if facts.RootDisk != nil {
+			values = []string{facts.RootDisk.Type}
+		}
+	case "k3os":
+		values = []string{facts.K3OSVersion}
+	case "k3s":
+		if facts.K3s != nil {
+			values = []string{facts.K3s.Version}
+		}
+	case "k3s_role":
+		if facts.K3s != nil {
+			values = []string{facts.K3s.Role}
+		}
+	default:
+		if v, ok := n.Labels[key]; ok {
+			return []string{v}, true
+		}
+		return nil, false
+	}
+
+	if len(values) == 0 || len(values[0]) == 0 {
+		return nil, false
+	}
+	return values, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"testing"
+)
+
+var selectorNode = &Node{
+	Hostname: "pi-1",
+	Address:  NewAddress("192.168.1.10", 22),
+	Auth:     Auth{User: "ubuntu"},
+	Arch:     "aarch64",
+	Labels:   map[string]string{"zone": "shelf-1", "role": "storage"},
+	Facts: &Facts{
+		Model:    "Raspberry Pi 4 Model B Rev 1.1",
+		CPUs:     4,
+		Memory:   3884376 * 1024,
+		RootDisk: &Disk{Name: "sda", Size: 256e9, Type: DiskTypeUSB},
+	},
+}
+
+func TestSelector_Matches(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"", true},
+		{"arch=arm64", true},
+		{"arch=aarch64", true},
+		{"arch==arm", false},
+		{"arch!=amd64", true},
+		{`model~="Pi 4"`, true},
+		{`model~="Pi 3"`, false},
+		{"arch=arm64,model~=\"Pi 4\",mem>=4Gi", true},
+		{"mem>4Gi", false},
+		{"cpus<=4", true},
+		{"cpus>4", false},
+		{"disk>=128G", true},
+		{"disk_type=usb", true},
+		{"zone=shelf-1", true},
+		{"zone=shelf-2", false},
+		{"role", true},
+		{"!role", false},
+		{"!gpu", true},
+		{"gpu!=true", true},
+		{"user=ubuntu,hostname=pi-1,ip=192.168.1.10", true},
+		{"serial=123", false},
+		{"!k3os", true},
+		{"!k3s,k3s_role!=server", true},
+	}
+
+	for _, tt := range tests {
+		selector, err := ParseSelector(tt.expr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.expr, err)
+			continue
+		}
+		if actual := selector.Matches(selectorNode); actual != tt.want {
+			t.Errorf("%s: expected: %v, actual: %v", tt.expr, tt.want, actual)
+		}
+	}
+}
+
+func TestParseSelector_Invalid(t *testing.T) {
+	for _, expr := range []string{"=foo", "mem>=lots", `model~="[Pi"`, `model="Pi`} {
+		if _, err := ParseSelector(expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestSelector_String(t *testing.T) {
+	expr := `arch=arm64,model~=Pi 4,mem>=4Gi,gpu,!storage`
+	selector, err := ParseSelector(`arch=arm64, model~="Pi 4", mem>=4Gi, gpu, !storage`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := selector.String(); actual != expr {
+		t.Errorf("expected: %s, actual: %s", expr, actual)
+	}
+}
+
+func TestNodes_Select(t *testing.T) {
+	nodes := Nodes{selectorNode, {Arch: "x86_64"}, {Arch: "armv7l"}}
+	selector, _ := ParseSelector("arch!=amd64")
+	if actual := len(nodes.Select(selector)); actual != 2 {
+		t.Errorf("expected: %d, actual: %d", 2, actual)
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	tests := map[string]float64{
+		"4":    4,
+		"4Gi":  4 << 30,
+		"1.5K": 1500,
+		"2Ti":  2 << 40,
+	}
+	for s, want := range tests {
+		actual, err := ParseQuantity(s)
+		if err != nil || actual != want {
+			t.Errorf("%s: expected: %v, actual: %v (%v)", s, want, actual, err)
+		}
+	}
+}
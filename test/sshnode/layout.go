@@ -0,0 +1,146 @@
+package sshnode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shims commands emulated on the node, put first in PATH
+var shims = map[string]string{
+	"uname": `case "$1" in
+-m) echo "$SSHNODE_ARCH" ;;
+-r) echo "5.4.0-sshnode" ;;
+-n) cat "$SSHNODE_DIR/root/etc/hostname" ;;
+*) echo Linux ;;
+esac`,
+	// sudo: -n fails if a password is needed, -S reads the password from stdin
+	"sudo": `noninteractive=; prompt="[sudo] password for $USER: "
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-n) noninteractive=yes; shift ;;
+	-S|-k) shift ;;
+	-p) prompt="$2"; shift 2 ;;
+	--) shift; break ;;
+	-*) echo "sudo: invalid option -- '$1'" >&2; exit 1 ;;
+	*) break ;;
+	esac
+done
+if [ -n "$SSHNODE_SUDO_PASSWORD" ]; then
+	if [ -n "$noninteractive" ]; then
+		echo "sudo: a password is required" >&2; exit 1
+	fi
+	for i in 1 2 3; do
+		printf '%s' "$prompt" >&2
+		if ! read -r password; then
+			echo "sudo: no password was provided" >&2; exit 1
+		fi
+		[ "$password" = "$SSHNODE_SUDO_PASSWORD" ] && break
+		[ $i = 3 ] && { echo "sudo: 3 incorrect password attempts" >&2; exit 1; }
+		echo "Sorry, try again." >&2
+	done
+fi
+if [ "$SSHNODE_NO_SUDO" = true ]; then
+	echo "$USER is not in the sudoers file.  This incident will be reported." >&2; exit 1
+fi
+exec "$@"`,
+	// reboot: the server closes all connections when the marker shows up
+	"reboot": `exec >/dev/null 2>&1 </dev/null
+[ "$1" = -d ] && sleep "$2"
+touch "$SSHNODE_DIR/reboot"`,
+	"ps":    `exit 0`,
+	"mount": `exit 0`,
+	"chown": `exit 0`,
+	"sync":  `exit 0`,
+}
+
+// layout creates the node root and the shims
+func (n *Node) layout() error {
+	files := map[string]string{
+		"/etc/hostname":                   n.Hostname + "\n",
+		"/proc/cpuinfo":                   cpuinfo,
+		"/proc/meminfo":                   "MemTotal:        3884328 kB\nMemFree:         3110300 kB\n",
+		"/proc/device-tree/model":         "Raspberry Pi 4 Model B Rev 1.2\x00",
+		"/proc/device-tree/serial-number": "10000000a1b2c3d4\x00",
+		"/sys/class/net/lo/address":       "00:00:00:00:00:00\n",
+		"/sys/class/net/eth0/address":     "dc:a6:32:00:00:01\n",
+		"/home/" + n.User + "/.profile":   "",
+		"/tmp/.keep":                      "",
+		"/var/lib/rancher/.keep":          "",
+		// eth0 has a device, the virtual interfaces of a k3s node have none
+		"/sys/devices/platform/scb/fd580000.ethernet/uevent": "DRIVER=bcmgenet\n",
+		"/sys/class/net/cni0/address":                        "9a:3e:4f:00:00:01\n",
+		"/sys/class/net/flannel.1/address":                   "9a:3e:4f:00:00:02\n",
+		"/sys/class/net/veth3f2a1b0c/address":                "9a:3e:4f:00:00:03\n",
+	}
+	links := map[string]string{
+		"/sys/class/net/eth0/device": "../../../devices/platform/scb/fd580000.ethernet",
+	}
+
+	var disk string
+	if n.Layout == LayoutK3OS {
+		files["/etc/os-release"] = "NAME=\"k3OS\"\nVERSION=\"v0.9.1\"\nID=k3os\nVERSION_ID=\"v0.9.1\"\nPRETTY_NAME=\"k3OS v0.9.1\"\n"
+		files["/etc/init.d/k3s-service"] = "#!/bin/sh\nexit 0\n"
+		files["/k3os/system/k3s/v1.17.2+k3s1/k3s"] = "#!/bin/sh\necho 'k3s version v1.17.2+k3s1 (cdab19b0)'\n"
+		links["/k3os/system/k3s/current"] = "v1.17.2+k3s1"
+		disk = "/sys/devices/platform/emmc2bus/fe340000.emmc2/mmc_host/mmc0/mmc0:0007/block/mmcblk0"
+		files["/proc/mounts"] = "/dev/mmcblk0p2 / ext4 rw,relatime 0 0\nproc /proc proc rw 0 0\n"
+		files[disk+"/size"] = "62333952\n"
+		files[disk+"/mmcblk0p2/partition"] = "2\n"
+		links["/sys/class/block/mmcblk0"] = "../../devices/platform/emmc2bus/fe340000.emmc2/mmc_host/mmc0/mmc0:0007/block/mmcblk0"
+		links["/sys/class/block/mmcblk0p2"] = "../../devices/platform/emmc2bus/fe340000.emmc2/mmc_host/mmc0/mmc0:0007/block/mmcblk0/mmcblk0p2"
+	} else {
+		files["/etc/os-release"] = "NAME=\"Ubuntu\"\nVERSION=\"20.04 LTS (Focal Fossa)\"\nID=ubuntu\nVERSION_ID=\"20.04\"\nPRETTY_NAME=\"Ubuntu 20.04 LTS\"\n"
+		disk = "/sys/devices/platform/scb/fd500000.pcie/pci0000:00/0000:01:00.0/usb2/2-2/2-2:1.0/host0/target0:0:0/0:0:0:0/block/sda"
+		files["/proc/mounts"] = "/dev/sda2 / ext4 rw,relatime 0 0\nproc /proc proc rw 0 0\n"
+		files[disk+"/size"] = "234441648\n"
+		files[disk+"/sda2/partition"] = "2\n"
+		links["/sys/class/block/sda"] = "../.." + disk[len("/sys"):]
+		links["/sys/class/block/sda2"] = "../.." + disk[len("/sys"):] + "/sda2"
+	}
+
+	for name, content := range files {
+		mode := os.FileMode(0644)
+		if strings.HasPrefix(content, "#!") {
+			mode = 0755
+		}
+		if err := writeFile(n.localPath(name), content, mode); err != nil {
+			return err
+		}
+	}
+	for name, target := range links {
+		if err := os.MkdirAll(filepath.Dir(n.localPath(name)), 0755); err != nil {
+			return err
+		}
+		if err := os.Symlink(target, n.localPath(name)); err != nil {
+			return err
+		}
+	}
+	for name, script := range shims {
+		if err := writeFile(filepath.Join(n.bin, name), fmt.Sprintf("#!/bin/sh\n%s\n", script), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(name, content string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, []byte(content), mode)
+}
+
+const cpuinfo = `processor	: 0
+BogoMIPS	: 108.00
+processor	: 1
+BogoMIPS	: 108.00
+processor	: 2
+BogoMIPS	: 108.00
+processor	: 3
+BogoMIPS	: 108.00
+Hardware	: BCM2835
+Serial		: 10000000a1b2c3d4
+`
@@ -0,0 +1,76 @@
+package sshnode
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// localPath maps a path on the node to the local file system, ~ is the user's home directory and
+// relative paths are relative to it
+func (n *Node) localPath(name string) string {
+	home := filepath.Join(n.Root, "home", n.User)
+	switch {
+	case name == "~":
+		return home
+	case strings.HasPrefix(name, "~/"):
+		return filepath.Join(home, name[2:])
+	case filepath.IsAbs(name):
+		return filepath.Join(n.Root, name)
+	default:
+		return filepath.Join(home, name)
+	}
+}
+
+// rewrite maps absolute paths in a shell command into the node root. Words starting with / are
+// rewritten, single quoted strings as nested commands, e.g. sh -c '...', unless they contain { or ^
+// as awk programs and regular expressions do. /dev/null is left as is.
+func (n *Node) rewrite(cmd string) string {
+	var b strings.Builder
+	prev := byte(' ')
+	for i := 0; i < len(cmd); {
+		c := cmd[i]
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(cmd[i+1:], '\'')
+			if end < 0 {
+				b.WriteString(cmd[i:])
+				return b.String()
+			}
+			quoted := cmd[i+1 : i+1+end]
+			if !strings.ContainsAny(quoted, "{^") {
+				quoted = n.rewrite(quoted)
+			}
+			b.WriteString("'" + quoted + "'")
+			i += end + 2
+			prev = '\''
+		case c == '/' && isBoundary(prev):
+			end := i
+			for end < len(cmd) && !isTerminator(cmd[end]) {
+				end++
+			}
+			b.WriteString(n.rootPath(cmd[i:end]))
+			prev = cmd[end-1]
+			i = end
+		default:
+			b.WriteByte(c)
+			prev = c
+			i++
+		}
+	}
+	return b.String()
+}
+
+func (n *Node) rootPath(p string) string {
+	if p == "/dev/null" {
+		return p
+	}
+	return n.Root + p
+}
+
+func isBoundary(c byte) bool {
+	return strings.IndexByte(" \t\n=(<>\";|&`", c) >= 0
+}
+
+func isTerminator(c byte) bool {
+	return strings.IndexByte(" \t\n'\";|&)<>`", c) >= 0
+}
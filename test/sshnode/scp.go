@@ -0,0 +1,114 @@
+package sshnode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// scpSink receives one file with the scp protocol, returns the exit status
+func (n *Node) scpSink(rw io.ReadWriter, remotePath string) int {
+	r := bufio.NewReader(rw)
+	if _, err := rw.Write([]byte{0}); err != nil {
+		return 1
+	}
+
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return 1
+	}
+	fields := strings.SplitN(strings.TrimSpace(header), " ", 3)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "C") {
+		return scpError(rw, "scp: protocol error: unexpected %q", header)
+	}
+	mode, err := strconv.ParseUint(fields[0][1:], 8, 32)
+	if err != nil {
+		return scpError(rw, "scp: protocol error: bad mode")
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return scpError(rw, "scp: protocol error: size not delimited")
+	}
+
+	target := n.localPath(remotePath)
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		target = filepath.Join(target, fields[2])
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(mode))
+	if err != nil {
+		return scpError(rw, "scp: %s: %s", remotePath, errorText(err))
+	}
+	defer f.Close()
+
+	if _, err = rw.Write([]byte{0}); err != nil {
+		return 1
+	}
+	if _, err = io.CopyN(f, r, size); err != nil {
+		return 1
+	}
+	if ack, err := r.ReadByte(); err != nil || ack != 0 {
+		return 1
+	}
+	if err = f.Close(); err != nil {
+		return scpError(rw, "scp: %s: %s", remotePath, errorText(err))
+	}
+	if _, err = rw.Write([]byte{0}); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// scpSource sends one file with the scp protocol, returns the exit status
+func (n *Node) scpSource(rw io.ReadWriter, remotePath string) int {
+	r := bufio.NewReader(rw)
+	if ack, err := r.ReadByte(); err != nil || ack != 0 {
+		return 1
+	}
+
+	b, err := ioutil.ReadFile(n.localPath(remotePath))
+	if err != nil {
+		return scpError(rw, "scp: %s: %s", remotePath, errorText(err))
+	}
+	info, err := os.Stat(n.localPath(remotePath))
+	if err != nil {
+		return scpError(rw, "scp: %s: %s", remotePath, errorText(err))
+	}
+
+	if _, err = fmt.Fprintf(rw, "C%04o %d %s\n", info.Mode().Perm(), len(b), filepath.Base(remotePath)); err != nil {
+		return 1
+	}
+	if ack, err := r.ReadByte(); err != nil || ack != 0 {
+		return 1
+	}
+	if _, err = rw.Write(append(b, 0)); err != nil {
+		return 1
+	}
+	if ack, err := r.ReadByte(); err != nil || ack != 0 {
+		return 1
+	}
+	return 0
+}
+
+// scpError sends an error to the other side
+func scpError(w io.Writer, format string, a ...interface{}) int {
+	_, _ = fmt.Fprintf(w, "\x01"+format+"\n", a...)
+	return 1
+}
+
+// errorText error text as printed by scp
+func errorText(err error) string {
+	switch {
+	case os.IsNotExist(err):
+		return "No such file or directory"
+	case os.IsPermission(err):
+		return "Permission denied"
+	case strings.Contains(err.Error(), "is a directory"):
+		return "not a regular file"
+	}
+	return err.Error()
+}
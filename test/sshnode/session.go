@@ -0,0 +1,216 @@
+package sshnode
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// session an ssh session running one command or a shell
+type session struct {
+	node    *Node
+	channel ssh.Channel
+
+	m       sync.Mutex
+	env     []string
+	started bool
+	process *os.Process
+}
+
+func (s *session) serve(requests <-chan *ssh.Request) {
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			ok := ssh.Unmarshal(req.Payload, &kv) == nil
+			if ok {
+				s.m.Lock()
+				s.env = append(s.env, kv.Name+"="+kv.Value)
+				s.m.Unlock()
+			}
+			_ = req.Reply(ok, nil)
+		case "pty-req":
+			// no real pty, the terminal type and size are passed to the command in the environment
+			var pty struct {
+				Term                                     string
+				Columns, Rows, WidthPixels, HeightPixels uint32
+				Modes                                    string
+			}
+			ok := ssh.Unmarshal(req.Payload, &pty) == nil
+			if ok {
+				s.m.Lock()
+				s.env = append(s.env, "TERM="+pty.Term, fmt.Sprintf("COLUMNS=%d", pty.Columns), fmt.Sprintf("LINES=%d", pty.Rows))
+				s.m.Unlock()
+				s.node.resized(pty.Columns, pty.Rows)
+			}
+			_ = req.Reply(ok, nil)
+		case "window-change":
+			var size struct{ Columns, Rows, WidthPixels, HeightPixels uint32 }
+			if ssh.Unmarshal(req.Payload, &size) == nil {
+				s.node.resized(size.Columns, size.Rows)
+			}
+		case "exec", "shell":
+			var payload struct{ Command string }
+			s.m.Lock()
+			ok := !s.started && (req.Type == "shell" || ssh.Unmarshal(req.Payload, &payload) == nil)
+			s.started = s.started || ok
+			s.m.Unlock()
+			_ = req.Reply(ok, nil)
+			if ok {
+				go s.exec(payload.Command)
+			}
+		case "signal":
+			s.m.Lock()
+			if s.process != nil {
+				_ = s.process.Signal(syscall.SIGTERM)
+			}
+			s.m.Unlock()
+		default:
+			// no subsystems like sftp
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+// exec runs the command, or a shell reading commands from stdin if empty, and reports the exit status
+func (s *session) exec(command string) {
+	if len(command) > 0 {
+		s.node.record(command)
+	}
+	defer s.channel.Close()
+
+	var status int
+	if args := shellWords(command); len(args) == 3 && args[0] == "scp" && (args[1] == "-qt" || args[1] == "-t") {
+		status = s.node.scpSink(s.channel, args[2])
+	} else if len(args) == 3 && args[0] == "scp" && (args[1] == "-qf" || args[1] == "-f") {
+		status = s.node.scpSource(s.channel, args[2])
+	} else {
+		status = s.run(command)
+	}
+	_, _ = s.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+// shellWords splits a command into words like the shell, single quotes and backslashes are removed
+func shellWords(command string) []string {
+	var words []string
+	var word strings.Builder
+	inWord, quoted, escaped := false, false, false
+	for _, r := range command {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quoted:
+			if r == '\'' {
+				quoted = false
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'':
+			quoted, inWord = true, true
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// run runs the command with /bin/sh, absolute paths are mapped into the node root. Commands of a
+// shell aren't mapped.
+func (s *session) run(command string) int {
+	stdout := &unrootWriter{w: s.channel, root: s.node.Root}
+	stderr := &unrootWriter{w: s.channel.Stderr(), root: s.node.Root}
+	cmd := exec.Command("/bin/sh")
+	if len(command) > 0 {
+		cmd = exec.Command("/bin/sh", "-c", s.node.rewrite(command))
+	}
+	cmd.Dir = s.node.localPath("~")
+	s.m.Lock()
+	cmd.Env = append(s.node.env(), s.env...)
+	s.m.Unlock()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	// stdin isn't waited for, the client may keep it open after the command has exited
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 255
+	}
+	if err = cmd.Start(); err != nil {
+		_, _ = io.WriteString(s.channel.Stderr(), err.Error()+"\n")
+		return 127
+	}
+	s.m.Lock()
+	s.process = cmd.Process
+	s.m.Unlock()
+	go func() {
+		_, _ = io.Copy(stdin, s.channel)
+		_ = stdin.Close()
+	}()
+
+	err = cmd.Wait()
+	_ = stdin.Close()
+	stdout.Flush()
+	stderr.Flush()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		return 128 + int(syscall.SIGTERM)
+	}
+	if err != nil {
+		return 255
+	}
+	return 0
+}
+
+// unrootWriter removes the node root from paths in the output. The end of a write that could be the
+// start of the root is held back until the next write or Flush.
+type unrootWriter struct {
+	w       io.Writer
+	root    string
+	pending []byte
+}
+
+func (u *unrootWriter) Write(b []byte) (int, error) {
+	u.pending = append(u.pending, b...)
+	out := bytes.ReplaceAll(u.pending, []byte(u.root), nil)
+	keep := 0
+	for i := len(u.root) - 1; i > 0; i-- {
+		if bytes.HasSuffix(out, []byte(u.root[:i])) {
+			keep = i
+			break
+		}
+	}
+	u.pending = append([]byte(nil), out[len(out)-keep:]...)
+	if _, err := u.w.Write(out[:len(out)-keep]); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush writes what's held back
+func (u *unrootWriter) Flush() {
+	if len(u.pending) > 0 {
+		_, _ = u.w.Write(u.pending)
+		u.pending = nil
+	}
+}
@@ -0,0 +1,311 @@
+// Package sshnode runs in-process ssh servers emulating k3OS and Ubuntu nodes for integration tests.
+//
+// Each node has a temp directory laid out like the root of the node. Commands are run with /bin/sh on
+// the test host with absolute paths mapped into the node root, uname, sudo, reboot and a few more
+// commands are emulated and scp is implemented by the server. Requires a Linux box with the usual
+// command line tools.
+package sshnode
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// LayoutK3OS node running k3OS, user rancher
+	LayoutK3OS = "k3os"
+	// LayoutUbuntu node running Ubuntu, user ubuntu
+	LayoutUbuntu = "ubuntu"
+)
+
+// Options fake node options, zero values are given defaults
+type Options struct {
+	// Layout of the root directory, LayoutK3OS or LayoutUbuntu
+	Layout string
+	// Hostname defaults to k3os-node or ubuntu-node
+	Hostname string
+	// Arch as printed by uname -m, defaults to aarch64
+	Arch string
+	// User defaults to rancher for k3OS and ubuntu for Ubuntu
+	User string
+	// Password accepted for the user, empty disables password authentication
+	Password string
+	// AuthorizedKeys keys accepted for the user
+	AuthorizedKeys []ssh.PublicKey
+	// SudoPassword password sudo asks for, empty for passwordless sudo
+	SudoPassword string
+	// NoSudo the user isn't allowed to use sudo
+	NoSudo bool
+}
+
+// Node a fake node listening on 127.0.0.1
+type Node struct {
+	Options
+	// Address of the ssh server with the host key pinned
+	Address model.Address
+	// Root directory of the node's file system
+	Root string
+
+	dir      string
+	bin      string
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	m        sync.Mutex
+	conns    map[*ssh.ServerConn]bool
+	commands []string
+	windows  []string
+	reboots  int
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Start starts a fake node, Close stops it and removes its files
+func Start(opts Options) (*Node, error) {
+	if len(opts.Layout) == 0 {
+		opts.Layout = LayoutK3OS
+	}
+	if opts.Layout != LayoutK3OS && opts.Layout != LayoutUbuntu {
+		return nil, fmt.Errorf("unknown layout: %s", opts.Layout)
+	}
+	if len(opts.Hostname) == 0 {
+		opts.Hostname = opts.Layout + "-node"
+	}
+	if len(opts.Arch) == 0 {
+		opts.Arch = "aarch64"
+	}
+	if len(opts.User) == 0 {
+		opts.User = map[string]string{LayoutK3OS: "rancher", LayoutUbuntu: "ubuntu"}[opts.Layout]
+	}
+
+	dir, err := ioutil.TempDir("", "sshnode-")
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		Options: opts,
+		Root:    filepath.Join(dir, "root"),
+		dir:     dir,
+		bin:     filepath.Join(dir, "bin"),
+		conns:   make(map[*ssh.ServerConn]bool),
+		done:    make(chan struct{}),
+	}
+	if err = n.layout(); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	n.config = &ssh.ServerConfig{PublicKeyCallback: n.publicKeyCallback}
+	if len(opts.Password) > 0 {
+		n.config.PasswordCallback = n.passwordCallback
+	}
+	n.config.AddHostKey(signer)
+
+	n.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	port := n.listener.Addr().(*net.TCPAddr).Port
+	n.Address = model.Address{IP: "127.0.0.1", Port: port, HostKey: client.MarshalHostKey(signer.PublicKey())}
+
+	n.wg.Add(2)
+	go n.serve()
+	go n.watchReboot()
+	return n, nil
+}
+
+// Node the node as found by a scan, with password auth if a password is set
+func (n *Node) Node() *model.Node {
+	auth := model.Auth{Type: model.AuthTypeSSHKey, User: n.User}
+	if len(n.Password) > 0 {
+		auth = model.Auth{Type: model.AuthTypeBasicAuth, User: n.User, Password: n.Password}
+	}
+	return &model.Node{Hostname: n.Hostname, Address: n.Address, Auth: auth, Arch: n.Arch}
+}
+
+// Path local path of a file on the node, ~/ is the user's home directory
+func (n *Node) Path(name string) string {
+	return n.localPath(name)
+}
+
+// Commands commands run on the node, in order
+func (n *Node) Commands() []string {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return append([]string(nil), n.commands...)
+}
+
+// WindowSizes terminal sizes of the pty requests and window changes as columns x rows, in order
+func (n *Node) WindowSizes() []string {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return append([]string(nil), n.windows...)
+}
+
+// Reboots number of times the node has been rebooted, a reboot closes all connections
+func (n *Node) Reboots() int {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return n.reboots
+}
+
+// Close stops the server and removes the node's files
+func (n *Node) Close() error {
+	select {
+	case <-n.done:
+		return nil
+	default:
+	}
+	close(n.done)
+	err := n.listener.Close()
+	n.closeConns()
+	n.wg.Wait()
+	if rmErr := os.RemoveAll(n.dir); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+func (n *Node) passwordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if conn.User() == n.User && string(password) == n.Password {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("password rejected for %s", conn.User())
+}
+
+func (n *Node) publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if conn.User() == n.User {
+		for _, k := range n.AuthorizedKeys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown public key for %s", conn.User())
+}
+
+func (n *Node) serve() {
+	defer n.wg.Done()
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			return
+		}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.handleConn(conn)
+		}()
+	}
+}
+
+func (n *Node) handleConn(conn net.Conn) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, n.config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	n.m.Lock()
+	n.conns[serverConn] = true
+	n.m.Unlock()
+	defer func() {
+		n.m.Lock()
+		delete(n.conns, serverConn)
+		n.m.Unlock()
+		_ = serverConn.Close()
+	}()
+
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		s := &session{node: n, channel: channel}
+		go s.serve(channelRequests)
+	}
+}
+
+func (n *Node) closeConns() {
+	n.m.Lock()
+	defer n.m.Unlock()
+	for conn := range n.conns {
+		_ = conn.Close()
+	}
+}
+
+// watchReboot closes all connections when the reboot command has been run
+func (n *Node) watchReboot() {
+	defer n.wg.Done()
+	marker := filepath.Join(n.dir, "reboot")
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			if _, err := os.Stat(marker); err == nil {
+				_ = os.Remove(marker)
+				n.m.Lock()
+				n.reboots++
+				n.m.Unlock()
+				n.closeConns()
+			}
+		}
+	}
+}
+
+func (n *Node) record(cmd string) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.commands = append(n.commands, cmd)
+}
+
+func (n *Node) resized(columns, rows uint32) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.windows = append(n.windows, fmt.Sprintf("%dx%d", columns, rows))
+}
+
+// env environment of commands run on the node
+func (n *Node) env() []string {
+	return []string{
+		"PATH=" + n.bin + ":/usr/local/bin:/usr/bin:/bin",
+		"HOME=" + n.localPath("~"),
+		"USER=" + n.User,
+		"LOGNAME=" + n.User,
+		"LC_ALL=C",
+		"SSHNODE_ARCH=" + n.Arch,
+		"SSHNODE_DIR=" + n.dir,
+		"SSHNODE_SUDO_PASSWORD=" + n.SudoPassword,
+		"SSHNODE_NO_SUDO=" + strconv.FormatBool(n.NoSudo),
+	}
+}
@@ -0,0 +1,419 @@
+package sshnode_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"github.com/TheNatureOfSoftware/k3pi/mocks"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/client"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/cmd"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/install"
+	"github.com/TheNatureOfSoftware/k3pi/pkg/model"
+	"github.com/TheNatureOfSoftware/k3pi/test/sshnode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// start starts a node, host keys are recorded in a temp known hosts file. Call stop when done.
+func start(t *testing.T, opts sshnode.Options) (node *sshnode.Node, stop func()) {
+	knownHosts, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = knownHosts.Close()
+	verifier := client.DefaultHostKeyVerifier
+	client.DefaultHostKeyVerifier = client.NewHostKeyVerifier(client.HostKeyCheckingAcceptNew, knownHosts.Name())
+
+	restore := func() {
+		client.DefaultHostKeyVerifier = verifier
+		_ = os.Remove(knownHosts.Name())
+	}
+	node, err = sshnode.Start(opts)
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	return node, func() {
+		_ = node.Close()
+		restore()
+	}
+}
+
+func connect(t *testing.T, node *sshnode.Node) client.Client {
+	c, err := client.NewClient(context.Background(), &node.Node().Auth, &node.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func tempDir(t *testing.T) (dir string, remove func()) {
+	dir, err := ioutil.TempDir("", "sshnode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestNode_Exec(t *testing.T) {
+	node, stop := start(t, sshnode.Options{Hostname: "k3s-node1", Password: "rancher"})
+	defer stop()
+	c := connect(t, node)
+	defer c.Close()
+
+	results, err := c.Cmd("uname -m").Cmd("cat /etc/hostname").Cmd("echo $HOME").Cmd("ls /nothing").Exec(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, "aarch64", strings.TrimSpace(string(results[0].Stdout)))
+	assert.Equal(t, "k3s-node1", strings.TrimSpace(string(results[1].Stdout)))
+	assert.Equal(t, "/home/rancher", strings.TrimSpace(string(results[2].Stdout)))
+	assert.Equal(t, 2, results[3].ExitStatus)
+	assert.Contains(t, string(results[3].Stderr), "'/nothing'")
+	assert.Equal(t, []string{"uname -m", "cat /etc/hostname", "echo $HOME", "ls /nothing"}, node.Commands())
+}
+
+func TestNode_SSHKey(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _ := ssh.NewPublicKey(public)
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, remove := tempDir(t)
+	defer remove()
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	node, stop := start(t, sshnode.Options{Layout: sshnode.LayoutUbuntu, AuthorizedKeys: []ssh.PublicKey{publicKey}})
+	defer stop()
+	auth := &model.Auth{Type: model.AuthTypeSSHKey, User: "ubuntu", SSHKey: keyFile}
+	c, err := client.NewClient(context.Background(), auth, &node.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	out, err := c.Cmd("whoami >/dev/null && echo $USER").Output(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ubuntu", strings.TrimSpace(string(out)))
+
+	auth.User = "root"
+	_, err = client.NewClient(context.Background(), auth, &node.Address)
+	assert.Error(t, err)
+}
+
+func TestNode_Upload(t *testing.T) {
+	node, stop := start(t, sshnode.Options{Password: "rancher"})
+	defer stop()
+	c := connect(t, node)
+	defer c.Close()
+
+	data := bytes.Repeat([]byte("k3s"), 100000)
+	dir, remove := tempDir(t)
+	defer remove()
+	filename := filepath.Join(dir, "k3s-arm64")
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(node.Path("~/k3s.part"), data[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var last client.Progress
+	err := c.Copy(context.Background(), filename, "~/k3s", func(p client.Progress) { last = p })
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), last.Resumed)
+	uploaded, _ := ioutil.ReadFile(node.Path("~/k3s"))
+	assert.Equal(t, data, uploaded)
+
+	b := []byte("token\n")
+	assert.NoError(t, c.CopyBytes(context.Background(), &b, "/tmp/token"))
+	if info, err := os.Stat(node.Path("/tmp/token")); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "uploaded files should only be readable by the user")
+	}
+	var downloaded bytes.Buffer
+	assert.NoError(t, c.Download(context.Background(), "/tmp/token", &downloaded))
+	assert.Equal(t, "token\n", downloaded.String())
+
+	// remote paths are quoted
+	assert.NoError(t, c.Copy(context.Background(), filename, "~/k3s arm64", nil))
+	uploaded, _ = ioutil.ReadFile(node.Path("~/k3s arm64"))
+	assert.Equal(t, data, uploaded)
+	assert.NoError(t, c.CopyBytes(context.Background(), &b, "/tmp/node token"))
+	downloaded.Reset()
+	assert.NoError(t, c.Download(context.Background(), "/tmp/node token", &downloaded))
+	assert.Equal(t, "token\n", downloaded.String())
+
+	assert.EqualError(t, c.Download(context.Background(), "/tmp/nothing", &downloaded),
+		"failed to copy from /tmp/nothing: scp: /tmp/nothing: No such file or directory")
+}
+
+func TestNode_FS(t *testing.T) {
+	node, stop := start(t, sshnode.Options{Password: "rancher"})
+	defer stop()
+	c := connect(t, node)
+	defer c.Close()
+	fs := c.FS().Sudo()
+	ctx := context.Background()
+
+	assert.NoError(t, fs.MkdirAll(ctx, "/var/lib/rancher/k3s/server", 0755))
+	assert.NoError(t, fs.WriteFile(ctx, "/var/lib/rancher/k3s/server/node-token", []byte("K10::secret\n"), 0600, ""))
+	assert.NoError(t, fs.Symlink(ctx, "/var/lib/rancher/k3s/server", "/var/lib/rancher/server"))
+
+	b, err := fs.ReadFile(ctx, "/var/lib/rancher/server/node-token")
+	assert.NoError(t, err)
+	assert.Equal(t, "K10::secret\n", string(b))
+	info, err := fs.Stat(ctx, "/var/lib/rancher/k3s/server/node-token")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	link, err := fs.Readlink(ctx, "/var/lib/rancher/server")
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/lib/rancher/k3s/server", link)
+
+	assert.NoError(t, fs.Remove(ctx, "/var/lib/rancher/k3s"))
+	_, err = fs.Stat(ctx, "/var/lib/rancher/k3s/server/node-token")
+	assert.True(t, os.IsNotExist(err), "expected not exist, was: %v", err)
+}
+
+func TestNode_Sudo(t *testing.T) {
+	node, stop := start(t, sshnode.Options{Password: "rancher", SudoPassword: "secret"})
+	defer stop()
+	ctx := context.Background()
+
+	auth := node.Node().Auth
+	auth.SudoPassword = "secret"
+	c, err := client.NewClient(ctx, &auth, &node.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	assert.NoError(t, client.CheckSudo(ctx, c))
+	out, err := c.Cmd("sudo cat /etc/hostname").Output(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "k3os-node", strings.TrimSpace(string(out)))
+
+	auth.SudoPassword = "wrong"
+	c, err = client.NewClient(ctx, &auth, &node.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	err = client.CheckSudo(ctx, c)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "incorrect password")
+
+	// sudo doesn't prompt, the command gets no input
+	noPassword, stopNoPassword := start(t, sshnode.Options{Password: "rancher"})
+	defer stopNoPassword()
+	auth = noPassword.Node().Auth
+	auth.SudoPassword = "secret"
+	c, err = client.NewClient(ctx, &auth, &noPassword.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	timeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	out, err = c.Cmd("sudo cat").Output(timeout)
+	assert.NoError(t, err)
+	assert.Empty(t, out)
+
+	noSudo, stopNoSudo := start(t, sshnode.Options{Password: "rancher", NoSudo: true})
+	defer stopNoSudo()
+	c = connect(t, noSudo)
+	defer c.Close()
+	err = client.CheckSudo(ctx, c)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the user is not allowed to use sudo")
+}
+
+func TestScanForNodes(t *testing.T) {
+	node, stop := start(t, sshnode.Options{Hostname: "k3s-node1", Password: "rancher"})
+	defer stop()
+
+	hostScanner := &mocks.HostScanner{}
+	hostScanner.On("ScanForAliveHosts", mock.Anything, "127.0.0.1/32").Return(&[]string{"127.0.0.1"}, nil)
+	request := &cmd.ScanRequest{
+		Cidr:              "127.0.0.1/32",
+		Port:              node.Address.Port,
+		HostnameSubString: "k3s",
+		SSHAuth:           &model.Auth{Type: model.AuthTypeBasicAuth, User: "rancher", Password: "rancher"},
+	}
+	nodes, err := cmd.ScanForNodes(context.Background(), client.NewPooledClientFactory(), request, hostScanner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, *nodes, 1)
+	found := (*nodes)[0]
+	assert.Equal(t, "k3s-node1", found.Hostname)
+	assert.Equal(t, "aarch64", found.Arch)
+	assert.Equal(t, node.Address.HostKey, found.Address.HostKey)
+	assert.Equal(t, "v0.9.1", found.Facts.K3OSVersion)
+	assert.Equal(t, "v1.17.2+k3s1", found.Facts.K3s.Version)
+	assert.Equal(t, 4, found.Facts.CPUs)
+	assert.Equal(t, "Raspberry Pi 4 Model B Rev 1.2", found.Facts.Model)
+	assert.Equal(t, model.DiskTypeSD, found.Facts.RootDisk.Type)
+	assert.Equal(t, "mmcblk0", found.Facts.RootDisk.Name)
+	assert.Equal(t, map[string]string{"eth0": "dc:a6:32:00:00:01"}, found.Facts.MACAddresses, "only physical NICs")
+}
+
+func TestK3sUpgrade(t *testing.T) {
+	node, stop := start(t, sshnode.Options{Password: "rancher"})
+	defer stop()
+	resourceDir, remove := tempDir(t)
+	defer remove()
+	k3s := "#!/bin/sh\necho 'k3s version v1.17.3+k3s1 (5b17a175)'\n"
+	if err := ioutil.WriteFile(filepath.Join(resourceDir, "k3s-arm64"), []byte(k3s), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cf := client.NewPooledClientFactory()
+	defer cf.Close()
+	task := &install.K3sUpgradeTask{Version: "v1.17.3+k3s1", Nodes: model.Nodes{node.Node()}, ClientFactory: cf}
+	installers := (&install.K3sInstallerFactory{}).MakeInstallers(task, resourceDir)
+	if err := install.Run(context.Background(), installers, install.NewOutput(ioutil.Discard, true)); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); node.Reboots() == 0 && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, 1, node.Reboots())
+
+	c := connect(t, node)
+	defer c.Close()
+	out, err := c.Cmd("/k3os/system/k3s/current/k3s --version").Output(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "k3s version v1.17.3+k3s1 (5b17a175)", strings.TrimSpace(string(out)))
+}
+
+func TestK3sUpgrade_RecordReplay(t *testing.T) {
+	node, stop := start(t, sshnode.Options{Password: "rancher"})
+	defer stop()
+	resourceDir, remove := tempDir(t)
+	defer remove()
+	k3s := "#!/bin/sh\necho 'k3s version v1.17.3+k3s1 (5b17a175)'\n"
+	if err := ioutil.WriteFile(filepath.Join(resourceDir, "k3s-arm64"), []byte(k3s), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	upgrade := func(cf *client.Factory) error {
+		task := &install.K3sUpgradeTask{Version: "v1.17.3+k3s1", Nodes: model.Nodes{node.Node()}, ClientFactory: cf}
+		installers := (&install.K3sInstallerFactory{}).MakeInstallers(task, resourceDir)
+		return install.Run(context.Background(), installers, install.NewOutput(ioutil.Discard, true))
+	}
+
+	var recording bytes.Buffer
+	recorder := client.NewRecorder(&recording)
+	cf := client.NewRecordingFactory(client.NewPooledClientFactory(), recorder)
+	if err := upgrade(cf); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, cf.Close())
+	assert.NoError(t, recorder.Err())
+
+	interactions, err := client.ReadRecording(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, node.Close())
+	replay := client.NewReplayFactory(interactions)
+	assert.NoError(t, upgrade(replay))
+	assert.NoError(t, replay.Close())
+}
+
+func TestExec(t *testing.T) {
+	node1, stop1 := start(t, sshnode.Options{Hostname: "k3s-node1", Password: "rancher"})
+	defer stop1()
+	node2, stop2 := start(t, sshnode.Options{Hostname: "k3s-node2", Password: "rancher", NoSudo: true})
+	defer stop2()
+
+	cf := client.NewPooledClientFactory()
+	defer cf.Close()
+	args := &cmd.ExecArgs{Nodes: model.Nodes{node1.Node(), node2.Node()}, Command: "cat /etc/hostname", Sudo: true}
+	results, err := cmd.Exec(context.Background(), cf, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, results, 2)
+	assert.True(t, results[0].Success())
+	assert.Equal(t, "k3s-node1\n", results[0].Stdout)
+	assert.False(t, results[1].Success())
+	assert.Contains(t, results[1].Error, "the user is not allowed to use sudo")
+}
+
+func TestShell(t *testing.T) {
+	node, stop := start(t, sshnode.Options{Hostname: "k3s-node1", Password: "rancher"})
+	defer stop()
+
+	cf := client.NewClientFactory()
+	defer cf.Close()
+	found, err := cmd.FindNode(model.Nodes{node.Node()}, "k3s-node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m sync.Mutex
+	width, height := 100, 30
+	resized := make(chan struct{}, 1)
+	stdin, input := io.Pipe()
+	var stdout, stderr bytes.Buffer
+	terminal := &client.Terminal{
+		Stdin:  stdin,
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Type:   "xterm",
+		Size: func() (int, int, error) {
+			m.Lock()
+			defer m.Unlock()
+			return width, height, nil
+		},
+		Resized: resized,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Shell(context.Background(), cf, found, "", terminal)
+	}()
+
+	_, _ = io.WriteString(input, "echo $TERM $COLUMNS $LINES\n")
+	m.Lock()
+	width, height = 120, 40
+	m.Unlock()
+	resized <- struct{}{}
+	for i := 0; i < 100 && len(node.WindowSizes()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, _ = io.WriteString(input, "exit 3\n")
+	_ = input.Close()
+
+	err = <-done
+	if cmdErr, ok := err.(*client.CommandError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, 3, cmdErr.Result.ExitStatus)
+	}
+	assert.Equal(t, "xterm 100 30\n", stdout.String())
+	assert.Empty(t, stderr.String())
+	assert.Equal(t, []string{"100x30", "120x40"}, node.WindowSizes())
+
+	stdout.Reset()
+	err = cmd.Shell(context.Background(), cf, found, "cat /etc/hostname", &client.Terminal{Stdout: &stdout})
+	assert.NoError(t, err)
+	assert.Equal(t, "k3s-node1\n", stdout.String())
+}